@@ -1,18 +1,26 @@
 package app
 
 import (
+	"bambucam/auth"
 	"bambucam/config"
 	"bambucam/printer"
+	"bambucam/printer/events"
+	"bambucam/printer/history"
+	"bambucam/printer/jobs"
 	"bambucam/printer/mqtt"
 	"bambucam/printer/timelapse"
+	"bambucam/printer/upload"
 	"bambucam/tgbot"
 	"bambucam/web"
+	"context"
 	"log"
 	"os"
 	"os/signal"
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 const version = "1.0.4"
@@ -23,19 +31,33 @@ type App struct {
 	fps       float64
 	status    sync.Map
 	online    atomic.Bool
+	events    *events.Bus
 
-	configMutex sync.RWMutex
-	frameMutex  sync.RWMutex
+	configMutex   sync.RWMutex
+	configModTime time.Time
+	frameMutex    sync.RWMutex
+	// lifecycleMutex не дает перезапуску компонентов пересечься с запуском и остановкой
+	lifecycleMutex sync.Mutex
+	watchStop      chan struct{}
 
 	webserver    *web.Server
 	bambuManager *mqtt.BambuManager
 	bambucam     *printer.BambuCamera
-	timelapse    *timelapse.Timelapse
-	telega       *tgbot.Telegram
+	// timelapse меняется при перезапуске, а очередь сборки читает его из своих горутин
+	timelapse atomic.Pointer[timelapse.Timelapse]
+	telega    *tgbot.Telegram
+	history   *history.History
+	jobs      *jobs.Queue
+	uploads   *upload.Uploader
+	audit     *auth.AuditLog
+	// limiter живет дольше веб-сервера: перезапуск сервера при сохранении настроек
+	// не должен снимать блокировки входа
+	limiter *auth.LoginLimiter
 }
 
 func New() *App {
-	a := &App{}
+	a := &App{events: events.NewBus(), limiter: auth.NewLoginLimiter()}
+	a.audit = auth.NewAuditLog(config.Path("audit.jsonl"), a.events)
 
 	var err error
 	a.cfg, err = config.Load()
@@ -53,7 +75,9 @@ func (a *App) IsOnline() bool {
 }
 
 func (a *App) SetOnline(online bool) {
-	a.online.Store(online)
+	if a.online.Swap(online) != online {
+		a.events.Publish(events.TypeCamera, map[string]any{"online": online})
+	}
 }
 
 func (a *App) GetFrame() []byte {
@@ -66,7 +90,11 @@ func (a *App) UpdateFrame(frame []byte, fps float64) {
 	a.frameMutex.Lock()
 	defer a.frameMutex.Unlock()
 	a.lastFrame = frame
-	a.fps = fps
+	// Камера считает FPS раз в секунду, на остальных кадрах приходит 0
+	if (fps > 0 || frame == nil) && fps != a.fps {
+		a.fps = fps
+		a.events.Publish(events.TypeStatus, map[string]any{"fps": fps})
+	}
 }
 
 func (a *App) GetStatus() map[string]any {
@@ -81,10 +109,22 @@ func (a *App) GetStatus() map[string]any {
 	return normalMap
 }
 
+// UpdateStatus сохраняет отчет принтера и рассылает подписчикам только изменившиеся поля
 func (a *App) UpdateStatus(status map[string]any) {
+	delta := make(map[string]any)
 	for key, val := range status {
-		a.status.Store(key, val)
+		old, loaded := a.status.Swap(key, val)
+		if !loaded || !reflect.DeepEqual(old, val) {
+			delta[key] = val
+		}
 	}
+	if len(delta) > 0 {
+		a.events.Publish(events.TypeStatus, delta)
+	}
+}
+
+func (a *App) Events() *events.Bus {
+	return a.events
 }
 
 func (a *App) GetConfig() *config.Config {
@@ -93,18 +133,34 @@ func (a *App) GetConfig() *config.Config {
 	return a.cfg
 }
 
+// SetConfig сохраняет настройки и перезапускает компоненты, которых они касаются.
+// Поля, заданные переменными окружения, изменить таким образом нельзя.
 func (a *App) SetConfig(cfg *config.Config) {
+	cfg.ApplyEnv()
+	a.replaceConfig(cfg, true)
+}
+
+func (a *App) replaceConfig(cfg *config.Config, save bool) {
 	a.configMutex.Lock()
-	defer a.configMutex.Unlock()
+	old := a.cfg
 	a.cfg = cfg
 	err := os.MkdirAll(a.cfg.Timelapse.SavePath, os.ModePerm)
 	if err != nil {
 		log.Println("Error creating dir for timelapse:", a.cfg.Timelapse.SavePath, "\n", err)
 	}
-	err = a.cfg.Save()
-	if err != nil {
-		log.Printf("Failed to save config: %v", err)
+	if save {
+		err = a.cfg.Save()
+		if err != nil {
+			log.Printf("Failed to save config: %v", err)
+		}
 	}
+	// Запоминаем время изменения файла, чтобы не принять свою же запись за ручную правку
+	if info, err := os.Stat(config.File()); err == nil {
+		a.configModTime = info.ModTime()
+	}
+	a.configMutex.Unlock()
+
+	go a.reload(old, cfg)
 }
 
 func (a *App) ToggleLight() {
@@ -125,12 +181,28 @@ func (a *App) TogglePause() {
 	}
 }
 
-func (a *App) AssembleVideo(folderName string) error {
-	err := a.timelapse.AssembleVideo(folderName)
-	if err != nil {
-		return err
+func (a *App) Jobs() *jobs.Queue {
+	return a.jobs
+}
+
+func (a *App) Uploads() *upload.Uploader {
+	return a.uploads
+}
+
+// runJob передает задание очереди текущему экземпляру таймлапса (он меняется при перезапуске)
+func (a *App) runJob(ctx context.Context, job jobs.Job, report func(stage string, progress float64)) error {
+	return a.timelapse.Load().RunJob(ctx, job, report)
+}
+
+func (a *App) GetHistory() []history.Record {
+	if a.history == nil {
+		return nil
 	}
-	return a.timelapse.AssemblePreview(folderName)
+	return a.history.Records()
+}
+
+func (a *App) Audit() *auth.AuditLog {
+	return a.audit
 }
 
 func (a *App) GetAppVersion() string {
@@ -149,14 +221,36 @@ func (a *App) Run() {
 }
 
 func (a *App) Start() {
-	var err error
-	a.cfg, err = config.Load()
+	a.lifecycleMutex.Lock()
+	defer a.lifecycleMutex.Unlock()
+
+	cfg, err := config.Load()
 	if err != nil {
 		log.Println("Error loading config:", err)
 		os.Exit(1)
 	}
+	a.configMutex.Lock()
+	a.cfg = cfg
+	if info, err := os.Stat(config.File()); err == nil {
+		a.configModTime = info.ModTime()
+	}
+	a.configMutex.Unlock()
+
+	a.history = history.NewHistory(a)
+	a.history.Start()
+
+	// Очередь сборки создается один раз: задания переживают перезапуск компонентов
+	if a.jobs == nil {
+		a.jobs = jobs.NewQueue(a, a.runJob)
+		a.jobs.Load()
+	}
+	if a.uploads == nil {
+		a.uploads = upload.NewUploader(a)
+		a.uploads.Start()
+	}
+	a.timelapse.Store(timelapse.NewTimelapse(a))
 
-	a.webserver = web.NewServer(a)
+	a.webserver = web.NewServer(a, a.limiter)
 	a.webserver.Start()
 
 	a.telega = tgbot.NewTelegram(a)
@@ -168,8 +262,10 @@ func (a *App) Start() {
 	a.bambuManager = mqtt.NewBambuManager(a)
 	a.bambuManager.Start()
 
-	a.timelapse = timelapse.NewTimelapse(a)
-	a.timelapse.Start()
+	a.timelapse.Load().Start()
+
+	a.watchStop = make(chan struct{})
+	go a.watchConfig(a.watchStop)
 }
 
 func (a *App) Restart() {
@@ -178,9 +274,19 @@ func (a *App) Restart() {
 }
 
 func (a *App) Stop() {
+	a.lifecycleMutex.Lock()
+	defer a.lifecycleMutex.Unlock()
+
+	if a.watchStop != nil {
+		close(a.watchStop)
+		a.watchStop = nil
+	}
+	a.history.Stop()
 	a.webserver.Stop()
 	a.telega.Stop()
-	a.timelapse.Stop()
+	a.timelapse.Load().Stop()
 	a.bambucam.Stop()
 	a.bambuManager.Stop()
+	// Отложенные перезапуски компонентов после остановки не выполняются
+	a.webserver = nil
 }
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"bambucam/config"
+	"bambucam/printer"
+	"bambucam/printer/mqtt"
+	"bambucam/printer/timelapse"
+	"bambucam/tgbot"
+	"bambucam/web"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Поля конфига, при изменении которых нужно перезапустить компонент.
+// Остальные настройки компоненты читают на лету и подхватывают сами.
+var (
+	cameraFields = []string{"printer.hostname", "printer.password"}
+	mqttFields   = []string{"printer.hostname", "printer.password", "printer.serial"}
+	webFields    = []string{"web.bind_address", "web.port", "web.hostname", "web.tls_mode", "web.tls_cert_file",
+		"web.tls_key_file", "web.acme_email", "web.http_redirect_port", "web.trusted_proxies"}
+	// Таймлапс перезапускается, чтобы восстановить прерванные сессии и применить правила
+	// хранения уже в новой папке. Сессия, которая записывалась в момент смены, остается
+	// в прежней папке (она продолжится, если вернуть путь обратно), а остаток печати
+	// записывается новой сессией.
+	timelapseFields = []string{"timelapse.save_path"}
+	telegramPrefix  = "telegram."
+)
+
+// Как часто проверять config.yaml на изменения, сделанные вручную
+const configWatchInterval = 2 * time.Second
+
+func affects(changed, fields []string) bool {
+	for _, field := range changed {
+		if slices.Contains(fields, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// reload перезапускает только те компоненты, которых касаются изменения конфига
+func (a *App) reload(old, new *config.Config) {
+	a.lifecycleMutex.Lock()
+	defer a.lifecycleMutex.Unlock()
+
+	// Приложение остановлено или еще запускается: новые настройки подхватятся при старте
+	if a.webserver == nil {
+		return
+	}
+
+	changed := config.ChangedFields(old, new)
+	if len(changed) == 0 {
+		return
+	}
+	log.Println("[Config] Изменены настройки:", strings.Join(changed, ", "))
+
+	var restarted []string
+
+	if affects(changed, cameraFields) {
+		a.bambucam.Stop()
+		a.bambucam = printer.NewBambuCamera(a)
+		a.bambucam.Start()
+		restarted = append(restarted, "камера")
+	}
+
+	if affects(changed, mqttFields) {
+		a.bambuManager.Stop()
+		a.bambuManager = mqtt.NewBambuManager(a)
+		a.bambuManager.Start()
+		restarted = append(restarted, "MQTT")
+	}
+
+	if affects(changed, timelapseFields) {
+		a.timelapse.Load().Stop()
+		tl := timelapse.NewTimelapse(a)
+		a.timelapse.Store(tl)
+		tl.Start()
+		restarted = append(restarted, "таймлапс")
+	}
+
+	if slices.ContainsFunc(changed, func(field string) bool { return strings.HasPrefix(field, telegramPrefix) }) {
+		a.telega.Stop()
+		a.telega = tgbot.NewTelegram(a)
+		a.telega.Start()
+		restarted = append(restarted, "Telegram")
+	}
+
+	if affects(changed, webFields) {
+		// Stop дожидается завершения текущих запросов, в том числе того, который сохранил настройки
+		a.webserver.Stop()
+		a.webserver = web.NewServer(a, a.limiter)
+		a.webserver.Start()
+		restarted = append(restarted, "веб-сервер")
+	}
+
+	if len(restarted) > 0 {
+		log.Println("[Config] Перезапущены:", strings.Join(restarted, ", "))
+	}
+}
+
+// watchConfig следит за config.yaml и применяет изменения, сделанные в файле вручную
+func (a *App) watchConfig(stop chan struct{}) {
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(config.File())
+		if err != nil {
+			continue
+		}
+
+		a.configMutex.RLock()
+		known := a.configModTime
+		a.configMutex.RUnlock()
+		if info.ModTime().Equal(known) {
+			continue
+		}
+
+		cfg, err := config.Load()
+		if err != nil {
+			log.Println("[Config] Ошибка чтения измененного config.yaml, оставлены прежние настройки:", err)
+			a.configMutex.Lock()
+			a.configModTime = info.ModTime()
+			a.configMutex.Unlock()
+			continue
+		}
+
+		log.Println("[Config] Файл config.yaml изменен, применяю настройки")
+		a.replaceConfig(cfg, false)
+	}
+}
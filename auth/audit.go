@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"bambucam/printer/events"
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Действия, которые попадают в журнал аудита
+const (
+	ActionLogin       = "login"
+	ActionLoginFailed = "login_failed"
+	ActionLoginLocked = "login_locked"
+	ActionLogout      = "logout"
+	ActionConfig      = "config_change"
+	ActionUsers       = "users_change"
+	ActionTokens      = "tokens_change"
+	ActionCommand     = "printer_command"
+)
+
+// Источники действий
+const (
+	SourceWeb      = "web"
+	SourceAPI      = "api"
+	SourceTelegram = "telegram"
+)
+
+// Размер журнала, после которого он переименовывается в .1 и начинается заново
+const auditMaxSize = 5 << 20
+
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Source  string    `json:"source"`
+	User    string    `json:"user,omitempty"`
+	IP      string    `json:"ip,omitempty"`
+	Details string    `json:"details,omitempty"`
+	Success bool      `json:"success"`
+}
+
+// AuditLog дописывает записи в JSONL файл и рассылает их по шине событий.
+// Методы безопасно вызывать у nil (например, в тестовых сборках без журнала).
+type AuditLog struct {
+	mu   sync.Mutex
+	path string
+	bus  *events.Bus
+}
+
+func NewAuditLog(path string, bus *events.Bus) *AuditLog {
+	return &AuditLog{path: path, bus: bus}
+}
+
+func (a *AuditLog) Record(entry AuditEntry) {
+	if a == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if info, err := os.Stat(a.path); err == nil && info.Size() > auditMaxSize {
+		os.Rename(a.path, a.path+".1")
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Println("[Audit] Ошибка записи журнала:", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Println("[Audit] Ошибка записи журнала:", err)
+	}
+
+	a.bus.Publish(events.TypeAudit, entry)
+}
+
+// List возвращает последние limit записей, новые первыми
+func (a *AuditLog) List(limit int) []AuditEntry {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+		if limit > 0 && len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
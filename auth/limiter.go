@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Параметры защиты от перебора паролей
+const (
+	loginFreeAttempts = 5                // неудачных попыток до первой блокировки
+	loginBaseLockout  = 30 * time.Second // первая блокировка, далее удваивается
+	loginMaxLockout   = time.Hour
+	loginForgetAfter  = 24 * time.Hour // счетчик сбрасывается после суток без ошибок
+)
+
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginLimiter считает неудачные входы по ключам (IP и имя пользователя) и
+// блокирует ключ на экспоненциально растущее время
+type LoginLimiter struct {
+	mu   sync.Mutex
+	keys map[string]*attempts
+}
+
+func NewLoginLimiter() *LoginLimiter {
+	return &LoginLimiter{keys: make(map[string]*attempts)}
+}
+
+// Locked возвращает оставшееся время блокировки (максимальное среди ключей)
+func (l *LoginLimiter) Locked(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		if a, ok := l.keys[key]; ok {
+			wait = max(wait, time.Until(a.lockedUntil))
+		}
+	}
+	return wait
+}
+
+// Fail отмечает неудачную попытку и возвращает время блокировки, если она началась
+func (l *LoginLimiter) Fail(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.cleanup(now)
+
+	var lockout time.Duration
+	for _, key := range keys {
+		a, ok := l.keys[key]
+		if !ok {
+			a = &attempts{}
+			l.keys[key] = a
+		}
+		a.failures++
+		a.lastFailure = now
+
+		if a.failures < loginFreeAttempts {
+			continue
+		}
+		d := loginBaseLockout << min(a.failures-loginFreeAttempts, 10)
+		d = min(d, loginMaxLockout)
+		a.lockedUntil = now.Add(d)
+		lockout = max(lockout, d)
+	}
+	return lockout
+}
+
+// Success сбрасывает счетчики после успешного входа
+func (l *LoginLimiter) Success(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		delete(l.keys, key)
+	}
+}
+
+func (l *LoginLimiter) cleanup(now time.Time) {
+	for key, a := range l.keys {
+		if now.Sub(a.lastFailure) > loginForgetAfter && now.After(a.lockedUntil) {
+			delete(l.keys, key)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	l := NewLoginLimiter()
+	const ip, user = "10.0.0.1", "alice"
+
+	for i := 1; i < loginFreeAttempts; i++ {
+		if d := l.Fail(ip, user); d != 0 {
+			t.Fatalf("attempt %d locked for %v", i, d)
+		}
+	}
+	if d := l.Locked(ip); d != 0 {
+		t.Fatalf("locked before the limit: %v", d)
+	}
+
+	// Дальше каждая ошибка удваивает блокировку до часа
+	tests := []time.Duration{loginBaseLockout, 2 * loginBaseLockout, 4 * loginBaseLockout}
+	for _, want := range tests {
+		if got := l.Fail(ip, user); got != want {
+			t.Fatalf("Fail = %v, want %v", got, want)
+		}
+	}
+	if d := l.Locked("other-ip", user); d <= 0 {
+		t.Error("user is not locked from another IP")
+	}
+	for range 20 {
+		l.Fail(ip)
+	}
+	if d := l.Locked(ip); d > loginMaxLockout {
+		t.Errorf("lockout %v exceeds %v", d, loginMaxLockout)
+	}
+
+	l.Success(ip, user)
+	if d := l.Locked(ip, user); d != 0 {
+		t.Errorf("still locked after success: %v", d)
+	}
+}
@@ -0,0 +1,33 @@
+package auth
+
+// Scope - уровень доступа. Уровни вложены: admin включает control, control включает read.
+type Scope string
+
+const (
+	ScopeRead    Scope = "read"    // просмотр состояния, камеры и таймлапсов
+	ScopeControl Scope = "control" // команды принтеру, сборка и удаление таймлапсов
+	ScopeAdmin   Scope = "admin"   // настройки и управление доступом
+)
+
+var Scopes = []Scope{ScopeRead, ScopeControl, ScopeAdmin}
+
+func (s Scope) level() int {
+	switch s {
+	case ScopeRead:
+		return 1
+	case ScopeControl:
+		return 2
+	case ScopeAdmin:
+		return 3
+	}
+	return 0
+}
+
+func (s Scope) Valid() bool {
+	return s.level() > 0
+}
+
+// Includes проверяет, покрывает ли уровень s требуемый уровень
+func (s Scope) Includes(required Scope) bool {
+	return s.Valid() && s.level() >= required.level()
+}
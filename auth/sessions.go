@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("сессия не найдена")
+
+// Session - запись о входе через веб-форму, ее ID хранится в JWT как jti
+type Session struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// SessionStore хранит активные сессии в JSON файле. Удаление записи завершает сессию,
+// даже если JWT в браузере еще не истек.
+type SessionStore struct {
+	mu       sync.Mutex
+	path     string
+	sessions []Session
+}
+
+func NewSessionStore(path string) *SessionStore {
+	s := &SessionStore{path: path}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.sessions); err != nil {
+			log.Println("[Auth] Ошибка чтения сессий:", err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dropExpired() {
+		s.save()
+	}
+	return s
+}
+
+func (s *SessionStore) Create(username, ip, userAgent string, lifetime time.Duration) (Session, error) {
+	id, err := randomString(16)
+	if err != nil {
+		return Session{}, err
+	}
+
+	now := time.Now()
+	session := Session{
+		ID:         id,
+		Username:   username,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(lifetime),
+		IP:         ip,
+		UserAgent:  userAgent,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropExpired()
+	s.sessions = append(s.sessions, session)
+	return session, s.save()
+}
+
+// Touch проверяет сессию и отмечает активность. Если передан extendTo, срок действия продлевается.
+func (s *SessionStore) Touch(id, username string, extendTo time.Time) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := slices.IndexFunc(s.sessions, func(ss Session) bool { return ss.ID == id })
+	if idx < 0 {
+		return Session{}, false
+	}
+	session := &s.sessions[idx]
+	if session.Username != username || time.Now().After(session.ExpiresAt) {
+		return Session{}, false
+	}
+
+	changed := false
+	// Не пишем файл на каждый запрос, точности до минуты достаточно
+	if time.Since(session.LastSeenAt) > time.Minute {
+		session.LastSeenAt = time.Now()
+		changed = true
+	}
+	if extendTo.After(session.ExpiresAt) {
+		session.ExpiresAt = extendTo
+		changed = true
+	}
+	if changed {
+		s.save()
+	}
+	return *session, true
+}
+
+// List возвращает сессии пользователя, или все сессии, если username пустой
+func (s *SessionStore) List(username string) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Session
+	for _, session := range s.sessions {
+		if username == "" || session.Username == username {
+			list = append(list, session)
+		}
+	}
+	return list
+}
+
+func (s *SessionStore) Get(id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := slices.IndexFunc(s.sessions, func(ss Session) bool { return ss.ID == id })
+	if idx < 0 {
+		return Session{}, false
+	}
+	return s.sessions[idx], true
+}
+
+func (s *SessionStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := slices.IndexFunc(s.sessions, func(ss Session) bool { return ss.ID == id })
+	if idx < 0 {
+		return ErrSessionNotFound
+	}
+	s.sessions = slices.Delete(s.sessions, idx, idx+1)
+	return s.save()
+}
+
+// RevokeUser завершает все сессии пользователя, кроме except (если указана)
+func (s *SessionStore) RevokeUser(username, except string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = slices.DeleteFunc(s.sessions, func(ss Session) bool {
+		return ss.Username == username && ss.ID != except
+	})
+	return s.save()
+}
+
+func (s *SessionStore) dropExpired() bool {
+	now := time.Now()
+	before := len(s.sessions)
+	s.sessions = slices.DeleteFunc(s.sessions, func(ss Session) bool {
+		return now.After(ss.ExpiresAt)
+	})
+	return len(s.sessions) != before
+}
+
+func (s *SessionStore) save() error {
+	data, err := json.MarshalIndent(s.sessions, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+// LoadOrCreateKey читает ключ подписи из файла, а при первом запуске генерирует случайный.
+// Если ключ не удалось сохранить, возвращается сгенерированный ключ вместе с ошибкой.
+func LoadOrCreateKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil && len(key) >= 32 {
+		return key, nil
+	}
+
+	key = make([]byte, 64)
+	rand.Read(key)
+	if err != nil && !os.IsNotExist(err) {
+		return key, err
+	}
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		return key, err
+	}
+	log.Println("[Auth] Сгенерирован новый ключ подписи сессий")
+	return key, nil
+}
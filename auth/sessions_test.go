@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessionStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	store := NewSessionStore(path)
+
+	alice, err := store.Create("alice", "10.0.0.1", "test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := store.Create("alice", "10.0.0.2", "test", time.Hour)
+	bob, _ := store.Create("bob", "10.0.0.3", "test", time.Hour)
+
+	tests := []struct {
+		name     string
+		id, user string
+		ok       bool
+	}{
+		{"own session", alice.ID, "alice", true},
+		{"other user's jti", bob.ID, "alice", false},
+		{"unknown jti", "missing", "alice", false},
+	}
+	for _, tt := range tests {
+		if _, ok := store.Touch(tt.id, tt.user, time.Time{}); ok != tt.ok {
+			t.Errorf("%s: Touch = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+
+	// Продление только сдвигает срок вперед
+	later := alice.ExpiresAt.Add(time.Hour)
+	if got, _ := store.Touch(alice.ID, "alice", later); !got.ExpiresAt.Equal(later) {
+		t.Errorf("extended ExpiresAt = %v, want %v", got.ExpiresAt, later)
+	}
+	if got, _ := store.Touch(alice.ID, "alice", alice.ExpiresAt); !got.ExpiresAt.Equal(later) {
+		t.Errorf("ExpiresAt moved back to %v", got.ExpiresAt)
+	}
+
+	// Выход со всех устройств, кроме текущего, переживает перезапуск
+	if err := store.RevokeUser("alice", second.ID); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewSessionStore(path)
+	if _, ok := reloaded.Touch(alice.ID, "alice", time.Time{}); ok {
+		t.Error("revoked session is still valid")
+	}
+	if _, ok := reloaded.Touch(second.ID, "alice", time.Time{}); !ok {
+		t.Error("kept session was revoked")
+	}
+	if len(reloaded.List("")) != 2 || len(reloaded.List("bob")) != 1 {
+		t.Errorf("List after reload = %+v", reloaded.List(""))
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	store := NewSessionStore(filepath.Join(t.TempDir(), "sessions.json"))
+	session, err := store.Create("alice", "", "", -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Touch(session.ID, "alice", time.Now().Add(time.Hour)); ok {
+		t.Error("expired session was renewed")
+	}
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenPrefix помогает узнать токен в логах и конфигурации сторонних систем
+const tokenPrefix = "bm_"
+
+var ErrTokenNotFound = errors.New("токен не найден")
+
+// Token - именованный ключ для программного доступа. Сам ключ не хранится, только его хеш.
+type Token struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Hash       string    `json:"hash"`
+	Hint       string    `json:"hint"` // первые символы ключа для отображения
+	Scopes     []Scope   `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+}
+
+// TokenStore хранит токены в JSON файле
+type TokenStore struct {
+	mu     sync.Mutex
+	path   string
+	tokens []Token
+}
+
+func NewTokenStore(path string) *TokenStore {
+	s := &TokenStore{path: path}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.tokens); err != nil {
+			log.Println("[Auth] Ошибка чтения токенов:", err)
+		}
+	}
+	return s
+}
+
+func (s *TokenStore) List() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.tokens)
+}
+
+// Create выпускает новый токен. Открытое значение возвращается один раз и больше нигде не сохраняется.
+func (s *TokenStore) Create(name string, scopes []Scope) (string, Token, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", Token{}, errors.New("укажите название токена")
+	}
+	if len(scopes) == 0 {
+		return "", Token{}, errors.New("выберите хотя бы одно право")
+	}
+	for _, scope := range scopes {
+		if !scope.Valid() {
+			return "", Token{}, errors.New("неизвестное право: " + string(scope))
+		}
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		return "", Token{}, err
+	}
+	id, err := randomString(8)
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	plain := tokenPrefix + secret
+	token := Token{
+		ID:        id,
+		Name:      name,
+		Hash:      hashToken(plain),
+		Hint:      plain[:len(tokenPrefix)+4],
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = append(s.tokens, token)
+	return plain, token, s.save()
+}
+
+func (s *TokenStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := slices.IndexFunc(s.tokens, func(t Token) bool { return t.ID == id })
+	if idx < 0 {
+		return ErrTokenNotFound
+	}
+	s.tokens = slices.Delete(s.tokens, idx, idx+1)
+	return s.save()
+}
+
+// Verify ищет токен по открытому значению и отмечает время использования
+func (s *TokenStore) Verify(plain string) (Token, bool) {
+	if !strings.HasPrefix(plain, tokenPrefix) {
+		return Token{}, false
+	}
+	hash := hashToken(plain)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.tokens {
+		if s.tokens[i].Hash == hash {
+			// Не пишем файл на каждый запрос, точности до минуты достаточно
+			if time.Since(s.tokens[i].LastUsedAt) > time.Minute {
+				s.tokens[i].LastUsedAt = time.Now()
+				s.save()
+			}
+			return s.tokens[i], true
+		}
+	}
+	return Token{}, false
+}
+
+func (s *TokenStore) save() error {
+	data, err := json.MarshalIndent(s.tokens, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+func hashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
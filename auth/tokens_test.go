@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store := NewTokenStore(path)
+
+	plain, token, err := store.Create(" ci ", []Scope{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := store.Create("backup", []Scope{ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Name != "ci" || !strings.HasPrefix(plain, tokenPrefix) || !strings.HasPrefix(plain, token.Hint) {
+		t.Fatalf("Create = %q, %+v", plain, token)
+	}
+
+	// На диске только хеш, открытого значения нет
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), plain) || !strings.Contains(string(data), hashToken(plain)) {
+		t.Fatalf("tokens.json stores the token in plain text:\n%s", data)
+	}
+
+	tests := []struct {
+		name  string
+		plain string
+		ok    bool
+	}{
+		{"valid", plain, true},
+		{"second token", other, true},
+		{"wrong secret", plain + "x", false},
+		{"hash instead of token", hashToken(plain), false},
+		{"without prefix", strings.TrimPrefix(plain, tokenPrefix), false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := store.Verify(tt.plain); ok != tt.ok {
+				t.Errorf("Verify = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+
+	// Отзыв сохраняется в файл и не задевает другие токены
+	if err := store.Revoke(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(token.ID); err != ErrTokenNotFound {
+		t.Fatalf("second Revoke = %v, want ErrTokenNotFound", err)
+	}
+	reloaded := NewTokenStore(path)
+	if _, ok := reloaded.Verify(plain); ok {
+		t.Error("revoked token still verifies after reload")
+	}
+	if got, ok := reloaded.Verify(other); !ok || got.Scopes[0] != ScopeAdmin {
+		t.Errorf("other token after reload = %+v, %v", got, ok)
+	}
+}
+
+func TestTokenCreateValidation(t *testing.T) {
+	store := NewTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	tests := []struct {
+		name   string
+		scopes []Scope
+	}{
+		{"", []Scope{ScopeRead}},
+		{"no scopes", nil},
+		{"unknown scope", []Scope{"root"}},
+	}
+	for _, tt := range tests {
+		if _, _, err := store.Create(tt.name, tt.scopes); err == nil {
+			t.Errorf("Create(%q, %v) succeeded", tt.name, tt.scopes)
+		}
+	}
+	if len(store.List()) != 0 {
+		t.Errorf("List() = %v", store.List())
+	}
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUserNotFound = errors.New("пользователь не найден")
+	ErrUserExists   = errors.New("пользователь уже существует")
+	ErrLastAdmin    = errors.New("нельзя удалить или понизить последнего администратора")
+	ErrBadUsername  = errors.New("имя пользователя не может быть пустым")
+	ErrBadPassword  = errors.New("пароль должен быть не короче 6 символов")
+	ErrBadRole      = errors.New("неизвестная роль")
+)
+
+const minPasswordLength = 6
+
+// Role - роль пользователя веб-интерфейса
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // только просмотр
+	RoleOperator Role = "operator" // просмотр и управление печатью
+	RoleAdmin    Role = "admin"    // полный доступ, включая настройки и пользователей
+)
+
+var Roles = []Role{RoleViewer, RoleOperator, RoleAdmin}
+
+// Scope возвращает право, соответствующее роли
+func (r Role) Scope() Scope {
+	switch r {
+	case RoleViewer:
+		return ScopeRead
+	case RoleOperator:
+		return ScopeControl
+	case RoleAdmin:
+		return ScopeAdmin
+	}
+	return ""
+}
+
+func (r Role) Valid() bool {
+	return r.Scope() != ""
+}
+
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	Role         Role      `json:"role"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UserStore хранит учетные записи в JSON файле, пароли - в виде bcrypt хешей
+type UserStore struct {
+	mu    sync.RWMutex
+	path  string
+	users []User
+}
+
+// dummyHash сравнивается при входе под несуществующим именем, чтобы время ответа не выдавало наличие пользователя
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("bambu-monitor"), bcrypt.DefaultCost)
+
+func NewUserStore(path string) *UserStore {
+	s := &UserStore{path: path}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.users); err != nil {
+			log.Println("[Auth] Ошибка чтения пользователей:", err)
+		}
+	}
+	return s
+}
+
+// Empty сообщает, что пользователей нет и авторизация отключена
+func (s *UserStore) Empty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users) == 0
+}
+
+func (s *UserStore) List() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.users)
+}
+
+func (s *UserStore) Get(username string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx := s.index(username)
+	if idx < 0 {
+		return User{}, false
+	}
+	return s.users[idx], true
+}
+
+// Authenticate проверяет имя и пароль
+func (s *UserStore) Authenticate(username, password string) (User, bool) {
+	user, ok := s.Get(username)
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return User{}, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return User{}, false
+	}
+	return user, true
+}
+
+func (s *UserStore) Create(username, password string, role Role) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return ErrBadUsername
+	}
+	if !role.Valid() {
+		return ErrBadRole
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index(username) >= 0 {
+		return ErrUserExists
+	}
+	s.users = append(s.users, User{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+		CreatedAt:    time.Now(),
+	})
+	return s.save()
+}
+
+func (s *UserStore) SetPassword(username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.index(username)
+	if idx < 0 {
+		return ErrUserNotFound
+	}
+	s.users[idx].PasswordHash = hash
+	return s.save()
+}
+
+func (s *UserStore) SetRole(username string, role Role) error {
+	if !role.Valid() {
+		return ErrBadRole
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.index(username)
+	if idx < 0 {
+		return ErrUserNotFound
+	}
+	if s.users[idx].Role == RoleAdmin && role != RoleAdmin && s.admins() == 1 {
+		return ErrLastAdmin
+	}
+	s.users[idx].Role = role
+	return s.save()
+}
+
+func (s *UserStore) Delete(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.index(username)
+	if idx < 0 {
+		return ErrUserNotFound
+	}
+	if s.users[idx].Role == RoleAdmin && s.admins() == 1 {
+		return ErrLastAdmin
+	}
+	s.users = slices.Delete(s.users, idx, idx+1)
+	return s.save()
+}
+
+// MigrateLegacy переносит логин и пароль из старого config.yaml в хранилище пользователей.
+// Возвращает true, если учетная запись была создана.
+func (s *UserStore) MigrateLegacy(username, password string) (bool, error) {
+	if username == "" || password == "" || !s.Empty() {
+		return false, nil
+	}
+
+	// Старый пароль мог быть короче нынешнего минимума, поэтому хешируем напрямую
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = append(s.users, User{
+		Username:     username,
+		PasswordHash: string(hash),
+		Role:         RoleAdmin,
+		CreatedAt:    time.Now(),
+	})
+	return true, s.save()
+}
+
+func (s *UserStore) index(username string) int {
+	return slices.IndexFunc(s.users, func(u User) bool { return u.Username == username })
+}
+
+func (s *UserStore) admins() int {
+	count := 0
+	for _, u := range s.users {
+		if u.Role == RoleAdmin {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *UserStore) save() error {
+	data, err := json.MarshalIndent(s.users, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", ErrBadPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
@@ -1,8 +1,44 @@
 package main
 
-import "bambucam/app"
+import (
+	"bambucam/app"
+	"bambucam/config"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+const usageText = `Использование: %s [флаги]
+
+Порядок настроек (каждый следующий источник важнее):
+  1. значения по умолчанию
+  2. файл config.yaml (--config или BAMBU_CONFIG, по умолчанию в папке данных)
+  3. переменные окружения BAMBU_<СЕКЦИЯ>_<ПОЛЕ>, например BAMBU_PRINTER_PASSWORD,
+     BAMBU_WEB_PORT, BAMBU_TELEGRAM_ADMIN_IDS=1,2
+  4. BAMBU_<СЕКЦИЯ>_<ПОЛЕ>_FILE - значение читается из файла (секреты docker/systemd)
+Флаги важнее переменных BAMBU_CONFIG и BAMBU_DATA_DIR.
+
+Секреты в config.yaml шифруются, если задан ключ: BAMBU_SECRET_KEY,
+BAMBU_SECRET_KEY_FILE или файл secret.key в папке данных.
+
+Флаги:
+`
 
 func main() {
+	configFile := flag.String("config", "", "путь к config.yaml")
+	dataDir := flag.String("data-dir", "", "папка данных: пользователи, история, ключи, сертификаты (по умолчанию папка программы)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usageText, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := config.SetPaths(*dataDir, *configFile); err != nil {
+		log.Println("Error creating data dir:", err)
+		os.Exit(1)
+	}
+
 	app := app.New()
 	app.Run()
 }
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"bambucam/auth"
 	"bambucam/config"
 	"bambucam/printer"
+	"bambucam/printer/events"
+	"bambucam/printer/history"
+	"bambucam/printer/jobs"
 	"bambucam/printer/timelapse"
+	"bambucam/printer/upload"
 	"log"
 	"os"
 	"sync"
@@ -14,6 +19,7 @@ type MockApp struct {
 	cfg       *config.Config
 	lastFrame []byte
 	status    map[string]any
+	events    *events.Bus
 
 	configMutex sync.RWMutex
 	frameMutex  sync.RWMutex
@@ -21,6 +27,8 @@ type MockApp struct {
 
 	bambucam  *printer.BambuCamera
 	timelapse *timelapse.Timelapse
+	jobs      *jobs.Queue
+	uploads   *upload.Uploader
 }
 
 func (a *MockApp) GetFrame() []byte {
@@ -49,6 +57,14 @@ func (a *MockApp) UpdateStatus(status map[string]any) {
 	}
 }
 
+func (a *MockApp) Events() *events.Bus {
+	return a.events
+}
+
+func (a *MockApp) Audit() *auth.AuditLog {
+	return nil
+}
+
 func (a *MockApp) GetConfig() *config.Config {
 	a.configMutex.RLock()
 	defer a.configMutex.RUnlock()
@@ -76,12 +92,16 @@ func (a *MockApp) GetAppVersion() string {
 	return "Test timelapse"
 }
 
-func (a *MockApp) AssembleVideo(folderName string) error {
-	err := a.timelapse.AssembleVideo(folderName)
-	if err != nil {
-		return err
-	}
-	return a.timelapse.AssemblePreview(folderName)
+func (a *MockApp) GetHistory() []history.Record {
+	return nil
+}
+
+func (a *MockApp) Jobs() *jobs.Queue {
+	return a.jobs
+}
+
+func (a *MockApp) Uploads() *upload.Uploader {
+	return a.uploads
 }
 
 func (a *MockApp) Run() {
@@ -120,6 +140,13 @@ func (a *MockApp) Start() {
 	a.bambucam.Start()
 
 	a.timelapse = timelapse.NewTimelapse(a)
+	if a.jobs == nil {
+		a.jobs = jobs.NewQueue(a, a.timelapse.RunJob)
+	}
+	if a.uploads == nil {
+		a.uploads = upload.NewUploader(a)
+		a.uploads.Start()
+	}
 	a.timelapse.Start()
 }
 
@@ -147,7 +174,8 @@ func main() {
 	cfg.Timelapse.SavePath = "./timelapse"
 
 	mock := &MockApp{
-		cfg: cfg,
+		cfg:    cfg,
+		events: events.NewBus(),
 	}
 
 	mock.Run()
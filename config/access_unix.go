@@ -0,0 +1,10 @@
+//go:build !windows
+
+package config
+
+import "golang.org/x/sys/unix"
+
+// canWrite - может ли процесс создавать файлы в существующей папке dir
+func canWrite(dir string) error {
+	return unix.Access(dir, unix.W_OK|unix.X_OK)
+}
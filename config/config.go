@@ -1,91 +1,368 @@
 package config
 
 import (
+	"fmt"
+	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config описывает все настройки приложения
 type Config struct {
+	// Version - версия схемы файла, по ней Load понимает, какие миграции нужно применить
+	Version int `yaml:"version" json:"version"`
+
 	Printer struct {
-		Hostname   string `yaml:"hostname"`
-		Password   string `yaml:"password"`
-		EncodeWait int    `yaml:"encode_wait"`
-		Serial     string `yaml:"serial"`
-	} `yaml:"printer"`
+		Hostname   string `yaml:"hostname" json:"hostname"`
+		Password   string `yaml:"password" json:"password"`
+		EncodeWait int    `yaml:"encode_wait" json:"encode_wait"`
+		Serial     string `yaml:"serial" json:"serial"`
+	} `yaml:"printer" json:"printer"`
 
 	Web struct {
-		Hostname    string `yaml:"hostname"`
-		BindAddress string `yaml:"bind_address"`
-		Port        int    `yaml:"port"`
-		Username    string `yaml:"username"`
-		Password    string `yaml:"password"`
-	} `yaml:"web"`
+		Hostname    string `yaml:"hostname" json:"hostname"`
+		BindAddress string `yaml:"bind_address" json:"bind_address"`
+		Port        int    `yaml:"port" json:"port"`
+		// SessionHours - время жизни сессии входа, продлевается при активности
+		SessionHours int `yaml:"session_hours" json:"session_hours"`
+		// CookieSameSite - режим SameSite для cookie: lax, strict или none (только с HTTPS)
+		CookieSameSite string `yaml:"cookie_samesite" json:"cookie_samesite"`
+		// TLSMode: "" - HTTP, "files" - свои сертификат и ключ, "self_signed" - сгенерированный
+		// сертификат, "acme" - сертификат Let's Encrypt для домена из Hostname
+		TLSMode     string `yaml:"tls_mode" json:"tls_mode"`
+		TLSCertFile string `yaml:"tls_cert_file" json:"tls_cert_file"`
+		TLSKeyFile  string `yaml:"tls_key_file" json:"tls_key_file"`
+		ACMEEmail   string `yaml:"acme_email" json:"acme_email"`
+		// HTTPRedirectPort - порт, с которого HTTP перенаправляется на HTTPS (0 - выключено)
+		HTTPRedirectPort int `yaml:"http_redirect_port" json:"http_redirect_port"`
+		// BasePath - префикс адресов, если приложение опубликовано за прокси не в корне (например /printers/bambu)
+		BasePath string `yaml:"base_path" json:"base_path"`
+		// TrustedProxies - IP или подсети прокси, чьим заголовкам X-Forwarded-* можно верить
+		TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies"`
+		// Metrics - доступ к /metrics: MetricsToken, MetricsPublic или MetricsOff
+		Metrics string `yaml:"metrics" json:"metrics"`
+		// Устаревшие поля: при первом запуске переносятся в users.json и очищаются
+		Username string `yaml:"username,omitempty" json:"username,omitempty"`
+		Password string `yaml:"password,omitempty" json:"password,omitempty"`
+	} `yaml:"web" json:"web"`
 
 	Timelapse struct {
-		Enabled    bool   `yaml:"enabled"`
-		Interval   int    `yaml:"interval_seconds"`
-		SavePath   string `yaml:"save_path"`
-		Fps        int    `yaml:"fps"`
-		AfterLayer int    `yaml:"after_layer"`
-		AddTime    bool   `yaml:"add_time"`
-	} `yaml:"timelapse"`
+		Enabled    bool   `yaml:"enabled" json:"enabled"`
+		Interval   int    `yaml:"interval_seconds" json:"interval_seconds"`
+		SavePath   string `yaml:"save_path" json:"save_path"`
+		Fps        int    `yaml:"fps" json:"fps"`
+		AfterLayer int    `yaml:"after_layer" json:"after_layer"`
+		// Smooth - плавный режим по слоям: после смены слоя в течение SmoothWindow секунд
+		// выбирается самый неподвижный кадр, голова в видео не скачет
+		Smooth       bool `yaml:"smooth" json:"smooth"`
+		SmoothWindow int  `yaml:"smooth_window_seconds" json:"smooth_window_seconds"`
+		// DedupThreshold - в режиме по времени кадр пропускается, если от последнего сохраненного
+		// изменилось меньше этого процента картинки (0 - не пропускать). DedupMaxGap - через сколько
+		// секунд кадр сохраняется даже без изменений (0 - без ограничения).
+		DedupThreshold int `yaml:"dedup_threshold" json:"dedup_threshold"`
+		DedupMaxGap    int `yaml:"dedup_max_gap_seconds" json:"dedup_max_gap_seconds"`
+		// Stages - какие кадры брать в видео по умолчанию: StagesAll или StagesPrinting
+		Stages string `yaml:"stages" json:"stages"`
+		// AddTime включает наложение при сборке видео, Overlay - его шаблон, Overlays - свои шаблоны
+		AddTime  bool              `yaml:"add_time" json:"add_time"`
+		Overlay  string            `yaml:"overlay" json:"overlay"`
+		Overlays []OverlayTemplate `yaml:"overlays" json:"overlays"`
+		// Profile - профиль сборки видео по умолчанию, Profiles - свои профили в дополнение к встроенным
+		Profile  string            `yaml:"profile" json:"profile"`
+		Profiles []EncodingProfile `yaml:"profiles" json:"profiles"`
+		// DeleteFrames - удалять исходные кадры после успешной авто-сборки, оставляя каждый
+		// KeepEveryFrame-й (0 - только последний как обложку)
+		DeleteFrames   bool `yaml:"delete_frames" json:"delete_frames"`
+		KeepEveryFrame int  `yaml:"keep_every_frame" json:"keep_every_frame"`
+		// MaxSizeMB и MaxAgeDays - пределы хранения, сверх них удаляются самые старые сессии (0 - без предела).
+		// MinFreeMB - запись не начинается, если на диске свободно меньше.
+		MaxSizeMB  int `yaml:"max_size_mb" json:"max_size_mb"`
+		MaxAgeDays int `yaml:"max_age_days" json:"max_age_days"`
+		MinFreeMB  int `yaml:"min_free_mb" json:"min_free_mb"`
+		// MaxJobs - сколько сборок видео может идти одновременно, остальные ждут в очереди
+		MaxJobs int `yaml:"max_jobs" json:"max_jobs"`
+	} `yaml:"timelapse" json:"timelapse"`
+
+	Upload struct {
+		// Auto - выгружать таймлапс во все места назначения после авто-сборки по окончании печати
+		Auto bool `yaml:"auto" json:"auto"`
+		// Retries - сколько раз повторять неудачную выгрузку, RetryDelay - пауза перед первым
+		// повтором в секундах, дальше она удваивается
+		Retries    int            `yaml:"retries" json:"retries"`
+		RetryDelay int            `yaml:"retry_delay_seconds" json:"retry_delay_seconds"`
+		Targets    []UploadTarget `yaml:"targets" json:"targets"`
+	} `yaml:"upload" json:"upload"`
 
 	Telegram struct {
-		Token    string  `yaml:"token"`
-		AdminIds []int64 `yaml:"admin_ids"`
-	} `yaml:"telegram"`
+		Token    string  `yaml:"token" json:"token"`
+		AdminIds []int64 `yaml:"admin_ids" json:"admin_ids"`
+		// AuditNotify - пересылать записи журнала аудита администраторам
+		AuditNotify bool `yaml:"audit_notify" json:"audit_notify"`
+	} `yaml:"telegram" json:"telegram"`
 }
 
+// Отбор кадров при сборке: все или только снятые во время печати (без нагрева, калибровки и простоя)
+const (
+	StagesAll      = "all"
+	StagesPrinting = "printing"
+)
+
+// Доступ к метрикам Prometheus: по API токену с правом чтения (или сессии входа), без
+// авторизации или никак
+const (
+	MetricsToken  = "token"
+	MetricsPublic = "public"
+	MetricsOff    = "off"
+)
+
 // DefaultConfig возвращает настройки по умолчанию
 func DefaultConfig() *Config {
-	cfg := &Config{}
+	cfg := &Config{Version: CurrentVersion}
 	cfg.Printer.EncodeWait = 500
 	cfg.Web.BindAddress = "0.0.0.0"
 	cfg.Web.Port = 8080
+	cfg.Web.SessionHours = 30 * 24
+	cfg.Web.CookieSameSite = "lax"
+	cfg.Web.Metrics = MetricsToken
 	cfg.Timelapse.Enabled = true
 	cfg.Timelapse.Interval = 0
 	cfg.Timelapse.SavePath = "timelapse"
 	cfg.Timelapse.Fps = 20
 	cfg.Timelapse.AfterLayer = 0
+	cfg.Timelapse.SmoothWindow = 8
+	cfg.Timelapse.DedupMaxGap = 60
+	cfg.Timelapse.Stages = StagesAll
 	cfg.Timelapse.AddTime = true
+	cfg.Timelapse.Profile = DefaultProfile
+	cfg.Timelapse.MaxJobs = 1
+	cfg.Timelapse.MinFreeMB = 500
+	cfg.Upload.Auto = true
+	cfg.Upload.Retries = 5
+	cfg.Upload.RetryDelay = 30
+	cfg.Timelapse.Overlay = DefaultOverlay
 	return cfg
 }
 
-// Load загружает конфиг из файла. Если файла нет — создает дефолтный.
+// Path возвращает путь к файлу данных приложения (лежат в папке данных, см. DataDir)
+func Path(name string) string {
+	return filepath.Join(DataDir(), name)
+}
+
+// Load загружает конфиг из файла и применяет переменные окружения. Если файла нет — создает дефолтный.
 func Load() (*Config, error) {
 	cfg := DefaultConfig()
-	dir := filepath.Dir(os.Args[0])
-	filename := filepath.Join(dir, "config.yaml")
+	filename := File()
 
 	data, err := os.ReadFile(filename)
-	if err != nil {
-		if os.IsNotExist(err) {
-			err = cfg.Save()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	created := err != nil
+
+	fileVersion, migrated, resave := 0, false, false
+	if !created {
+		// Файлы без поля version - самые старые, с них начинается цепочка миграций
+		cfg.Version = 0
+		err = yaml.Unmarshal(data, cfg)
+		if err != nil {
+			return nil, err
+		}
+		fileVersion = cfg.Version
+		migrated = cfg.migrate()
+
+		plaintext, err := cfg.decryptSecrets()
+		if err != nil {
+			return nil, err
+		}
+		if plaintext {
+			log.Println("[Config] Задан ключ шифрования, секреты в файле будут зашифрованы")
+		}
+		resave = plaintext
+		sealBackups(filename)
+	}
+	fromFile := cfg.Clone()
+
+	applied, envErrs := cfg.applyEnv()
+	for _, err := range envErrs {
+		log.Println("[Config] Переменная окружения пропущена:", err)
+	}
+	overridesMutex.Lock()
+	overrides, fileValues = applied, fromFile
+	overridesMutex.Unlock()
+	if len(applied) > 0 {
+		fields := make([]string, 0, len(applied))
+		for field := range applied {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		log.Println("[Config] Из переменных окружения заданы:", strings.Join(fields, ", "))
+	}
+
+	if created {
+		if err := cfg.Save(); err != nil {
 			return cfg, err
 		}
-		return nil, err
+	}
+	if migrated {
+		// Исходный файл сохраняем отдельно: обычная копия .bak перезапишется при следующем сохранении
+		if err := backup(filename, fmt.Sprintf(".v%d.bak", fileVersion)); err != nil {
+			log.Println("[Config] Не удалось сохранить копию старого файла, обновленный файл не записан:", err)
+		} else if err := cfg.Save(); err != nil {
+			log.Println("[Config] Не удалось записать обновленный файл:", err)
+		}
+	} else if resave {
+		if err := cfg.Save(); err != nil {
+			log.Println("[Config] Не удалось записать файл с зашифрованными секретами:", err)
+		}
 	}
 
-	err = yaml.Unmarshal(data, cfg)
-	if err != nil {
-		return nil, err
+	// Ошибочные значения не мешают запуску: берем значения по умолчанию, а файл
+	// оставляем как есть, чтобы пользователь мог исправить его в веб-интерфейсе
+	if errs := cfg.Validate(); len(errs) > 0 {
+		for field, msg := range errs {
+			log.Printf("[Config] %s: %s", field, msg)
+		}
+		cfg.resetInvalid(errs)
 	}
 
 	return cfg, nil
 }
 
-// Save записывает текущие настройки в файл
+// Save записывает текущие настройки в файл (атомарно, доступ только владельцу), предыдущая
+// версия файла остается в config.yaml.bak. Поля из переменных окружения сохраняются со
+// значениями, которые были в файле, секреты шифруются, если задан ключ.
 func (cfg *Config) Save() error {
-	dir := filepath.Dir(os.Args[0])
-	filename := filepath.Join(dir, "config.yaml")
+	filename := File()
+
+	out := cfg.withoutOverrides()
+	out.Version = CurrentVersion
+	if err := out.encryptSecrets(); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	if err := backup(filename, ".bak"); err != nil {
+		log.Println("[Config]", err)
+	}
+	return writeFileAtomic(filename, data, 0600)
+}
 
-	data, err := yaml.Marshal(cfg)
+// writeFileAtomic пишет данные во временный файл рядом с целевым и переименовывает его:
+// при сбое посреди записи на диске останется либо старый, либо новый файл целиком
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	return os.WriteFile(filename, data, 0644)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Clone возвращает независимую копию настроек
+func (cfg *Config) Clone() *Config {
+	c := *cfg
+	c.Web.TrustedProxies = slices.Clone(cfg.Web.TrustedProxies)
+	c.Telegram.AdminIds = slices.Clone(cfg.Telegram.AdminIds)
+	c.Timelapse.Profiles = slices.Clone(cfg.Timelapse.Profiles)
+	for i := range c.Timelapse.Profiles {
+		c.Timelapse.Profiles[i].ExtraArgs = slices.Clone(cfg.Timelapse.Profiles[i].ExtraArgs)
+	}
+	c.Timelapse.Overlays = slices.Clone(cfg.Timelapse.Overlays)
+	for i := range c.Timelapse.Overlays {
+		c.Timelapse.Overlays[i].Fields = slices.Clone(cfg.Timelapse.Overlays[i].Fields)
+	}
+	c.Upload.Targets = slices.Clone(cfg.Upload.Targets)
+	return &c
+}
+
+// ChangedFields возвращает изменившиеся поля в виде "секция.поле" (имена как в yaml).
+// Пустой и nil список считаются одинаковыми, чтобы чтение файла не давало ложных изменений.
+func ChangedFields(old, new *Config) []string {
+	var changed []string
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	forEachField(func(field string, index []int) {
+		if !sameValue(ov.FieldByIndex(index), nv.FieldByIndex(index)) {
+			changed = append(changed, field)
+		}
+	})
+	return changed
+}
+
+// forEachField обходит поля всех секций конфига, передавая путь "секция.поле" и индекс для FieldByIndex
+func forEachField(fn func(field string, index []int)) {
+	t := reflect.TypeFor[Config]()
+	for i := 0; i < t.NumField(); i++ {
+		section := t.Field(i)
+		if section.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.Type.NumField(); j++ {
+			fn(yamlName(section)+"."+yamlName(section.Type.Field(j)), []int{i, j})
+		}
+	}
+}
+
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	return name
+}
+
+func sameValue(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// NormalizeBasePath приводит базовый путь к виду "/prefix" без слеша в конце, корень - пустая строка
+func NormalizeBasePath(path string) string {
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// HostOnly выделяет имя хоста из настройки Web.Hostname, где может быть схема, порт и путь
+func HostOnly(hostname string) string {
+	if hostname == "" {
+		return ""
+	}
+	if !strings.Contains(hostname, "://") {
+		hostname = "http://" + hostname
+	}
+	u, err := url.Parse(hostname)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
 }
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Откуда берутся настройки, каждый следующий источник важнее предыдущего:
+//
+//  1. значения по умолчанию (DefaultConfig);
+//  2. файл config.yaml - по умолчанию в папке данных, путь меняется флагом
+//     --config или переменной BAMBU_CONFIG;
+//  3. переменные окружения BAMBU_<СЕКЦИЯ>_<ПОЛЕ>, имена как в yaml:
+//     BAMBU_PRINTER_PASSWORD, BAMBU_WEB_PORT, BAMBU_TIMELAPSE_INTERVAL_SECONDS.
+//     Списки задаются через запятую: BAMBU_TELEGRAM_ADMIN_IDS=1,2. В списках
+//     с полями (места выгрузки, профили, шаблоны наложения) поле элемента задается
+//     по номеру с нуля: BAMBU_UPLOAD_TARGETS_0_URL, BAMBU_TIMELAPSE_PROFILES_1_CRF;
+//     номер за концом списка из файла добавляет новый элемент;
+//  4. переменные BAMBU_<СЕКЦИЯ>_<ПОЛЕ>_FILE - значение читается из файла
+//     (секреты docker и systemd). Если заданы обе переменные, берется файл.
+//
+// Папка данных (users.json, история, ключи, сертификаты) по умолчанию - папка
+// с исполняемым файлом, меняется флагом --data-dir или переменной BAMBU_DATA_DIR.
+// Флаги командной строки важнее переменных окружения.
+//
+// Поля, заданные через окружение, не записываются в config.yaml: в файле
+// остается то, что было в нем раньше, поэтому секреты не попадают на диск.
+
+// EnvPrefix - префикс переменных окружения с настройками
+const EnvPrefix = "BAMBU_"
+
+var (
+	pathsMutex sync.RWMutex
+	dataDir    string
+	configFile string
+
+	// overrides - поля, заданные через окружение при последней загрузке ("web.port" -> "BAMBU_WEB_PORT"),
+	// fileValues - настройки в том виде, в каком они прочитаны из файла
+	overridesMutex sync.RWMutex
+	overrides      = map[string]string{}
+	fileValues     *Config
+)
+
+// SetPaths задает папку данных и путь к config.yaml из флагов командной строки.
+// Пустые значения означают переменные окружения или пути по умолчанию.
+func SetPaths(dir, file string) error {
+	pathsMutex.Lock()
+	dataDir, configFile = dir, file
+	pathsMutex.Unlock()
+
+	return os.MkdirAll(DataDir(), 0755)
+}
+
+// DataDir возвращает папку, где хранятся данные приложения
+func DataDir() string {
+	pathsMutex.RLock()
+	dir := dataDir
+	pathsMutex.RUnlock()
+
+	if dir == "" {
+		dir = os.Getenv(EnvPrefix + "DATA_DIR")
+	}
+	if dir == "" {
+		dir = filepath.Dir(os.Args[0])
+	}
+	return dir
+}
+
+// File возвращает путь к config.yaml
+func File() string {
+	pathsMutex.RLock()
+	file := configFile
+	pathsMutex.RUnlock()
+
+	if file == "" {
+		file = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if file == "" {
+		file = Path("config.yaml")
+	}
+	return file
+}
+
+// Overrides возвращает поля, заданные переменными окружения, и имена этих переменных
+func Overrides() map[string]string {
+	overridesMutex.RLock()
+	defer overridesMutex.RUnlock()
+
+	out := make(map[string]string, len(overrides))
+	for field, env := range overrides {
+		out[field] = env
+	}
+	return out
+}
+
+// ApplyEnv заново применяет переменные окружения, чтобы они оставались важнее
+// значений, измененных в веб-интерфейсе или через API
+func (cfg *Config) ApplyEnv() {
+	cfg.applyEnv()
+}
+
+// EnvName возвращает имя переменной окружения для поля "секция.поле"
+func EnvName(field string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(field, ".", "_"))
+}
+
+// applyEnv записывает в настройки значения из окружения. Возвращает заданные поля
+// и ошибки разбора - поля с ошибками остаются без изменений.
+func (cfg *Config) applyEnv() (map[string]string, []error) {
+	applied := map[string]string{}
+	var errs []error
+
+	current := reflect.ValueOf(cfg).Elem()
+	forEachField(func(field string, index []int) {
+		raw, source, ok, err := lookupEnv(EnvName(field))
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if !ok {
+			return
+		}
+		if err := setField(current.FieldByIndex(index), raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source, err))
+			return
+		}
+		applied[field] = source
+	})
+
+	forEachListField(func(list string, index []int) {
+		items := current.FieldByIndex(index)
+		for _, i := range envIndexes(EnvName(list)) {
+			if i >= maxEnvIndex {
+				errs = append(errs, fmt.Errorf("%s_%d: номер элемента должен быть меньше %d", EnvName(list), i, maxEnvIndex))
+				continue
+			}
+			if i >= items.Len() {
+				items.Set(reflect.AppendSlice(items, reflect.MakeSlice(items.Type(), i+1-items.Len(), i+1-items.Len())))
+			}
+			item := items.Index(i)
+			for j := 0; j < item.NumField(); j++ {
+				field := listField(list, i, item.Type().Field(j))
+				raw, source, ok, err := lookupEnv(EnvName(field))
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				if !ok {
+					continue
+				}
+				if err := setField(item.Field(j), raw); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", source, err))
+					continue
+				}
+				applied[field] = source
+			}
+		}
+	})
+	return applied, errs
+}
+
+// maxEnvIndex ограничивает номер элемента списка в переменной окружения, чтобы
+// опечатка вроде BAMBU_UPLOAD_TARGETS_1000000_URL не создала миллион пустых элементов
+const maxEnvIndex = 100
+
+// forEachListField обходит поля-списки элементов со своими полями (upload.targets,
+// timelapse.profiles, timelapse.overlays)
+func forEachListField(fn func(list string, index []int)) {
+	t := reflect.TypeFor[Config]()
+	forEachField(func(field string, index []int) {
+		if f := t.FieldByIndex(index); f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct {
+			fn(field, index)
+		}
+	})
+}
+
+// listField возвращает путь поля элемента списка: "upload.targets.0.url"
+func listField(list string, i int, field reflect.StructField) string {
+	return fmt.Sprintf("%s.%d.%s", list, i, yamlName(field))
+}
+
+// envIndexes возвращает номера элементов, упомянутые в переменных вида PREFIX_<номер>_<ПОЛЕ>
+func envIndexes(prefix string) []int {
+	var indexes []int
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		rest, ok := strings.CutPrefix(name, prefix+"_")
+		if !ok {
+			continue
+		}
+		num, _, ok := strings.Cut(rest, "_")
+		if i, err := strconv.Atoi(num); ok && err == nil && i >= 0 && !slices.Contains(indexes, i) {
+			indexes = append(indexes, i)
+		}
+	}
+	slices.Sort(indexes)
+	return indexes
+}
+
+// lookupEnv читает значение из NAME_FILE или NAME и возвращает имя переменной, откуда оно взято
+func lookupEnv(name string) (value, source string, ok bool, err error) {
+	if path, found := os.LookupEnv(name + "_FILE"); found && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", false, fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), name + "_FILE", true, nil
+	}
+	if value, found := os.LookupEnv(name); found {
+		return value, name, true, nil
+	}
+	return "", "", false, nil
+}
+
+func setField(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+
+	case reflect.Int:
+		val, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("нужно целое число, задано %q", raw)
+		}
+		field.SetInt(int64(val))
+
+	case reflect.Bool:
+		val, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("нужно true или false, задано %q", raw)
+		}
+		field.SetBool(val)
+
+	case reflect.Slice:
+		items := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, part := range strings.Split(raw, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			item := reflect.New(field.Type().Elem()).Elem()
+			switch item.Kind() {
+			case reflect.String:
+				item.SetString(part)
+			case reflect.Int64:
+				val, err := strconv.ParseInt(part, 10, 64)
+				if err != nil {
+					return fmt.Errorf("нужен список чисел через запятую, задано %q", raw)
+				}
+				item.SetInt(val)
+			default:
+				return fmt.Errorf("неподдерживаемый тип списка %s", field.Type())
+			}
+			items = reflect.Append(items, item)
+		}
+		field.Set(items)
+
+	default:
+		return fmt.Errorf("неподдерживаемый тип %s", field.Type())
+	}
+	return nil
+}
+
+// withoutOverrides возвращает копию настроек, в которой поля из окружения
+// заменены значениями из файла, - именно она записывается на диск
+func (cfg *Config) withoutOverrides() *Config {
+	overridesMutex.RLock()
+	defer overridesMutex.RUnlock()
+
+	out := cfg.Clone()
+	if len(overrides) == 0 {
+		return out
+	}
+	base := fileValues
+	if base == nil {
+		base = DefaultConfig()
+	}
+
+	dst, src := reflect.ValueOf(out).Elem(), reflect.ValueOf(base.Clone()).Elem()
+	forEachField(func(field string, index []int) {
+		if _, ok := overrides[field]; ok {
+			dst.FieldByIndex(index).Set(src.FieldByIndex(index))
+		}
+	})
+
+	// В элементах списков возвращаются значения из файла, а элементы, которых в файле
+	// не было, не записываются вовсе
+	forEachListField(func(list string, index []int) {
+		items, fileItems := dst.FieldByIndex(index), src.FieldByIndex(index)
+		kept := reflect.MakeSlice(items.Type(), 0, items.Len())
+		for i := 0; i < items.Len(); i++ {
+			item, fromEnv := items.Index(i), false
+			for j := 0; j < item.NumField(); j++ {
+				if _, ok := overrides[listField(list, i, item.Type().Field(j))]; !ok {
+					continue
+				}
+				fromEnv = true
+				if i < fileItems.Len() {
+					item.Field(j).Set(fileItems.Index(i).Field(j))
+				}
+			}
+			if !fromEnv || i < fileItems.Len() {
+				kept = reflect.Append(kept, item)
+			}
+		}
+		items.Set(kept)
+	})
+	return out
+}
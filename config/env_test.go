@@ -0,0 +1,151 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "token")
+	writeFile(t, secret, "file-token\n")
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		check  func(cfg *Config) any
+		want   any
+		source map[string]string
+		errors int
+	}{
+		{
+			name:   "string",
+			env:    map[string]string{"BAMBU_PRINTER_HOSTNAME": "10.0.0.5"},
+			check:  func(cfg *Config) any { return cfg.Printer.Hostname },
+			want:   "10.0.0.5",
+			source: map[string]string{"printer.hostname": "BAMBU_PRINTER_HOSTNAME"},
+		},
+		{
+			name:   "int",
+			env:    map[string]string{"BAMBU_WEB_PORT": " 9090 "},
+			check:  func(cfg *Config) any { return cfg.Web.Port },
+			want:   9090,
+			source: map[string]string{"web.port": "BAMBU_WEB_PORT"},
+		},
+		{
+			name:   "bool",
+			env:    map[string]string{"BAMBU_TIMELAPSE_ENABLED": "false"},
+			check:  func(cfg *Config) any { return cfg.Timelapse.Enabled },
+			want:   false,
+			source: map[string]string{"timelapse.enabled": "BAMBU_TIMELAPSE_ENABLED"},
+		},
+		{
+			name:   "list",
+			env:    map[string]string{"BAMBU_TELEGRAM_ADMIN_IDS": "1, 2,,3"},
+			check:  func(cfg *Config) any { return cfg.Telegram.AdminIds },
+			want:   []int64{1, 2, 3},
+			source: map[string]string{"telegram.admin_ids": "BAMBU_TELEGRAM_ADMIN_IDS"},
+		},
+		{
+			name:   "file wins over value",
+			env:    map[string]string{"BAMBU_TELEGRAM_TOKEN": "env-token", "BAMBU_TELEGRAM_TOKEN_FILE": secret},
+			check:  func(cfg *Config) any { return cfg.Telegram.Token },
+			want:   "file-token",
+			source: map[string]string{"telegram.token": "BAMBU_TELEGRAM_TOKEN_FILE"},
+		},
+		{
+			name:   "missing file",
+			env:    map[string]string{"BAMBU_TELEGRAM_TOKEN_FILE": filepath.Join(dir, "missing")},
+			check:  func(cfg *Config) any { return cfg.Telegram.Token },
+			want:   "",
+			errors: 1,
+		},
+		{
+			name:   "bad int keeps default",
+			env:    map[string]string{"BAMBU_WEB_PORT": "http"},
+			check:  func(cfg *Config) any { return cfg.Web.Port },
+			want:   DefaultConfig().Web.Port,
+			errors: 1,
+		},
+		{
+			name:  "list element by index",
+			env:   map[string]string{"BAMBU_UPLOAD_TARGETS_1_NAME": "nas", "BAMBU_UPLOAD_TARGETS_1_KEY_FILE": "/id"},
+			check: func(cfg *Config) any { return cfg.Upload.Targets },
+			want:  []UploadTarget{{}, {Name: "nas", KeyFile: "/id"}},
+			source: map[string]string{
+				"upload.targets.1.name":     "BAMBU_UPLOAD_TARGETS_1_NAME",
+				"upload.targets.1.key_file": "BAMBU_UPLOAD_TARGETS_1_KEY_FILE",
+			},
+		},
+		{
+			name:   "list index too large",
+			env:    map[string]string{"BAMBU_TIMELAPSE_PROFILES_1000_CRF": "20"},
+			check:  func(cfg *Config) any { return len(cfg.Timelapse.Profiles) },
+			want:   0,
+			errors: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cfg := DefaultConfig()
+			applied, errs := cfg.applyEnv()
+			if got := tt.check(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("value = %#v, want %#v", got, tt.want)
+			}
+			if len(errs) != tt.errors {
+				t.Errorf("errors = %v, want %d", errs, tt.errors)
+			}
+			if tt.source == nil {
+				tt.source = map[string]string{}
+			}
+			if !reflect.DeepEqual(applied, tt.source) {
+				t.Errorf("applied = %v, want %v", applied, tt.source)
+			}
+		})
+	}
+}
+
+// TestEnvOverridesNotSaved - значения из окружения, в том числе элементы списков,
+// не попадают в config.yaml
+func TestEnvOverridesNotSaved(t *testing.T) {
+	dir := useDataDir(t)
+	writeFile(t, filepath.Join(dir, "config.yaml"), `version: 1
+printer:
+    password: from-file
+upload:
+    targets:
+        - name: nas
+          type: local
+          path: /mnt/nas
+`)
+	t.Setenv("BAMBU_PRINTER_PASSWORD", "from-env")
+	t.Setenv("BAMBU_UPLOAD_TARGETS_0_PATH", "/mnt/env")
+	t.Setenv("BAMBU_UPLOAD_TARGETS_1_NAME", "extra")
+	t.Setenv("BAMBU_UPLOAD_TARGETS_1_TYPE", "local")
+	t.Setenv("BAMBU_UPLOAD_TARGETS_1_PATH", "/mnt/extra")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Printer.Password != "from-env" || len(cfg.Upload.Targets) != 2 || cfg.Upload.Targets[0].Path != "/mnt/env" {
+		t.Fatalf("loaded %q, %+v", cfg.Printer.Password, cfg.Upload.Targets)
+	}
+	if Overrides()["upload.targets.1.path"] != "BAMBU_UPLOAD_TARGETS_1_PATH" {
+		t.Errorf("Overrides() = %v", Overrides())
+	}
+
+	saved := cfg.withoutOverrides()
+	if saved.Printer.Password != "from-file" {
+		t.Errorf("saved password = %q", saved.Printer.Password)
+	}
+	want := []UploadTarget{{Name: "nas", Type: UploadLocal, Path: "/mnt/nas"}}
+	if !reflect.DeepEqual(saved.Upload.Targets, want) {
+		t.Errorf("saved targets = %+v, want %+v", saved.Upload.Targets, want)
+	}
+}
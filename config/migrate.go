@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// CurrentVersion - текущая версия схемы конфига, равна числу миграций
+const CurrentVersion = 1
+
+// migrations[i] переводит настройки из версии i в версию i+1. Новые миграции
+// добавляются в конец (и увеличивают CurrentVersion), уже выпущенные не меняются.
+var migrations = []func(cfg *Config){
+	// 0 -> 1: файлы без версии. Старые сборки сохраняли нули в полях, которые тогда
+	// не использовались (encode_wait, fps, порт), - заполняем их значениями по умолчанию
+	fillZeroDefaults,
+}
+
+// migrate применяет недостающие миграции. Возвращает true, если настройки изменились.
+func (cfg *Config) migrate() bool {
+	from := max(cfg.Version, 0)
+	if from > CurrentVersion {
+		log.Printf("[Config] Файл настроек версии %d новее программы (%d), неизвестные поля будут потеряны при сохранении", from, CurrentVersion)
+		return false
+	}
+	if from == CurrentVersion {
+		return false
+	}
+
+	for version := from; version < CurrentVersion; version++ {
+		migrations[version](cfg)
+	}
+	cfg.Version = CurrentVersion
+	log.Printf("[Config] Настройки обновлены с версии %d до %d", from, cfg.Version)
+	return true
+}
+
+// fillZeroDefaults записывает значения по умолчанию в пустые поля, у которых
+// значение по умолчанию не пустое. Флаги не трогаем: false в них - осознанный выбор.
+func fillZeroDefaults(cfg *Config) {
+	defaults := reflect.ValueOf(DefaultConfig()).Elem()
+	current := reflect.ValueOf(cfg).Elem()
+	forEachField(func(field string, index []int) {
+		value, def := current.FieldByIndex(index), defaults.FieldByIndex(index)
+		if value.Kind() == reflect.Bool || !value.IsZero() || def.IsZero() {
+			return
+		}
+		value.Set(def)
+		log.Printf("[Config] %s: пустое значение заменено на %v", field, def.Interface())
+	})
+}
+
+// backup копирует текущий файл настроек рядом с ним с указанным суффиксом. Если задан
+// ключ шифрования, секреты в копии шифруются: файл на диске еще может быть старым,
+// незашифрованным, и копия не должна оставлять их открытыми.
+func backup(filename, suffix string) error {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if data, _, err = sealSecrets(data); err != nil {
+		return fmt.Errorf("резервная копия %s: %w", filename+suffix, err)
+	}
+	if err := writeFileAtomic(filename+suffix, data, 0600); err != nil {
+		return fmt.Errorf("резервная копия %s: %w", filename+suffix, err)
+	}
+	return nil
+}
+
+// sealBackups шифрует секреты в уже лежащих рядом копиях config.yaml*.bak - они могли
+// остаться открытыми с тех пор, когда ключ шифрования еще не был задан
+func sealBackups(filename string) {
+	backups, _ := filepath.Glob(filename + "*.bak")
+	for _, name := range backups {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		sealed, changed, err := sealSecrets(data)
+		if err == nil && changed {
+			err = writeFileAtomic(name, sealed, 0600)
+		}
+		if err != nil {
+			log.Printf("[Config] Не удалось зашифровать секреты в %s: %v", name, err)
+		} else if changed {
+			log.Printf("[Config] Секреты в %s зашифрованы", name)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		changed bool
+	}{
+		{"without version", 0, true},
+		{"current", CurrentVersion, false},
+		{"newer than program", CurrentVersion + 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Version: tt.version}
+			if got := cfg.migrate(); got != tt.changed {
+				t.Fatalf("migrate() = %v, want %v", got, tt.changed)
+			}
+			if tt.changed && cfg.Version != CurrentVersion {
+				t.Errorf("Version = %d, want %d", cfg.Version, CurrentVersion)
+			}
+			if !tt.changed && cfg.Version != tt.version {
+				t.Errorf("Version changed to %d", cfg.Version)
+			}
+		})
+	}
+}
+
+// TestMigrateV0File - старый файл без версии с нулями в неиспользовавшихся тогда полях
+func TestMigrateV0File(t *testing.T) {
+	dir := useDataDir(t)
+	old := `printer:
+    hostname: 192.168.1.10
+    encode_wait: 0
+web:
+    port: 0
+timelapse:
+    enabled: false
+    fps: 0
+    save_path: timelapse
+`
+	writeFile(t, filepath.Join(dir, "config.yaml"), old)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultConfig()
+	if cfg.Printer.EncodeWait != defaults.Printer.EncodeWait || cfg.Web.Port != defaults.Web.Port || cfg.Timelapse.Fps != defaults.Timelapse.Fps {
+		t.Errorf("zeros not filled: encode_wait %d, port %d, fps %d", cfg.Printer.EncodeWait, cfg.Web.Port, cfg.Timelapse.Fps)
+	}
+	// false во флаге - выбор пользователя, а не пропуск
+	if cfg.Timelapse.Enabled || cfg.Printer.Hostname != "192.168.1.10" {
+		t.Errorf("user values changed: enabled %v, hostname %q", cfg.Timelapse.Enabled, cfg.Printer.Hostname)
+	}
+
+	backup, err := os.ReadFile(filepath.Join(dir, "config.yaml.v0.bak"))
+	if err != nil || string(backup) != old {
+		t.Fatalf("v0 backup = %q, %v", backup, err)
+	}
+	saved, err := os.ReadFile(filepath.Join(dir, "config.yaml"))
+	if err != nil || !strings.Contains(string(saved), "version: 1") {
+		t.Fatalf("migrated file not saved:\n%s", saved)
+	}
+
+	// Повторная загрузка ничего не мигрирует и копию не перезаписывает
+	os.Remove(filepath.Join(dir, "config.yaml.v0.bak"))
+	if _, err := Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config.yaml.v0.bak")); !os.IsNotExist(err) {
+		t.Errorf("second load created a backup again: %v", err)
+	}
+}
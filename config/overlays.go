@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"image/color"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// OverlayTemplate - что и как рисовать поверх кадров таймлапса
+type OverlayTemplate struct {
+	Name string `yaml:"name" json:"name"`
+	// Fields - поля по строкам сверху вниз, см. OverlayFields
+	Fields []string `yaml:"fields" json:"fields"`
+	// Position - угол кадра, см. OverlayPositions
+	Position string `yaml:"position" json:"position"`
+	FontSize int    `yaml:"font_size" json:"font_size"`
+	// Color и Background - цвет текста и подложки в виде #RRGGBB или #RRGGBBAA
+	Color      string `yaml:"color" json:"color"`
+	Background string `yaml:"background" json:"background"`
+	// FontFile - путь к своему TTF/OTF шрифту, пусто - встроенный Go Regular
+	FontFile string `yaml:"font_file" json:"font_file"`
+}
+
+// OverlayFields - поля, которые умеет рисовать наложение
+var OverlayFields = []string{"time", "elapsed", "layer", "percent", "nozzle", "bed", "task", "filament"}
+
+// OverlayPositions - допустимые положения блока наложения
+var OverlayPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right"}
+
+// DefaultOverlay - шаблон по умолчанию, совпадает с прежней меткой времени.
+// NoOverlay - зарезервированное имя для сборки без наложения.
+const (
+	DefaultOverlay = "time"
+	NoOverlay      = "none"
+)
+
+// builtinOverlays доступны всегда; шаблон с тем же именем в конфиге их заменяет
+var builtinOverlays = []OverlayTemplate{
+	{Name: DefaultOverlay, Fields: []string{"time"}, Position: "top-right", FontSize: 20, Color: "#FFFFFF", Background: "#00000096"},
+	{Name: "progress", Fields: []string{"layer", "percent"}, Position: "top-right", FontSize: 20, Color: "#FFFFFF", Background: "#00000096"},
+	{Name: "full", Fields: []string{"task", "time", "elapsed", "layer", "percent", "nozzle", "bed", "filament"},
+		Position: "bottom-left", FontSize: 18, Color: "#FFFFFF", Background: "#00000096"},
+}
+
+// OverlayTemplates возвращает встроенные шаблоны с учетом шаблонов из конфига
+func (cfg *Config) OverlayTemplates() []OverlayTemplate {
+	templates := slices.Clone(builtinOverlays)
+	for _, tpl := range cfg.Timelapse.Overlays {
+		if i := slices.IndexFunc(templates, func(t OverlayTemplate) bool { return t.Name == tpl.Name }); i >= 0 {
+			templates[i] = tpl
+		} else {
+			templates = append(templates, tpl)
+		}
+	}
+	return templates
+}
+
+// OverlayTemplate ищет шаблон по имени, пустое имя - шаблон, выбранный в настройках
+func (cfg *Config) OverlayTemplate(name string) (OverlayTemplate, bool) {
+	if name == "" {
+		name = cfg.Timelapse.Overlay
+	}
+	for _, tpl := range cfg.OverlayTemplates() {
+		if tpl.Name == name {
+			return tpl, true
+		}
+	}
+	return OverlayTemplate{}, false
+}
+
+// ParseColor разбирает цвет вида #RRGGBB или #RRGGBBAA
+func ParseColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return color.RGBA{}, fmt.Errorf("цвет %q должен быть вида #RRGGBB или #RRGGBBAA", s)
+	}
+	if len(hex) == 6 {
+		hex += "FF"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("цвет %q должен быть вида #RRGGBB или #RRGGBBAA", s)
+	}
+	// Компоненты image/color хранятся умноженными на альфу
+	r, g, b, a := uint8(v>>24), uint8(v>>16), uint8(v>>8), uint8(v)
+	return color.RGBA{
+		R: uint8(uint16(r) * uint16(a) / 255),
+		G: uint8(uint16(g) * uint16(a) / 255),
+		B: uint8(uint16(b) * uint16(a) / 255),
+		A: a,
+	}, nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EncodingProfile - набор параметров ffmpeg для сборки таймлапса
+type EncodingProfile struct {
+	Name string `yaml:"name" json:"name"`
+	// Codec: h264, h265, vp9 или av1 (программные кодеки, видеокарта не нужна)
+	Codec string `yaml:"codec" json:"codec"`
+	CRF   int    `yaml:"crf" json:"crf"`
+	// Preset - скорость/качество кодирования: для h264/h265 ultrafast..veryslow,
+	// для av1 число 0..13, для vp9 good/best/realtime. Пусто - по умолчанию кодека.
+	Preset string `yaml:"preset" json:"preset"`
+	// Width - ширина видео, высота подбирается по пропорциям. 0 - как у камеры.
+	Width int `yaml:"width" json:"width"`
+	// ExtraArgs - дополнительные аргументы ffmpeg перед именем выходного файла: пары
+	// "-ключ значение", ключи только из ExtraArgFlags
+	ExtraArgs []string `yaml:"extra_args" json:"extra_args"`
+	// PreviewSpeed - во сколько раз превью быстрее видео, PreviewWidth - его ширина
+	PreviewSpeed int `yaml:"preview_speed" json:"preview_speed"`
+	PreviewWidth int `yaml:"preview_width" json:"preview_width"`
+}
+
+// Codecs - поддерживаемые кодеки профилей
+var Codecs = []string{"h264", "h265", "vp9", "av1"}
+
+// ExtraArgFlags - ключи ffmpeg, разрешенные в extra_args. Только параметры кодирования:
+// ключи с путями к файлам (-i, -passlogfile, фильтры, *-params) дали бы читать и писать
+// произвольные файлы от имени сервиса.
+var ExtraArgFlags = []string{
+	"-tune", "-g", "-bf", "-keyint_min", "-sc_threshold", "-r",
+	"-b:v", "-maxrate", "-minrate", "-bufsize", "-threads",
+	"-row-mt", "-tile-columns", "-cpu-used", "-aq-mode",
+	"-profile:v", "-level", "-pix_fmt", "-movflags",
+}
+
+// CheckExtraArgs проверяет, что extra_args - пары "-ключ значение" с разрешенными ключами
+func CheckExtraArgs(args []string) error {
+	if len(args)%2 != 0 {
+		return errors.New("extra_args должны идти парами \"-ключ значение\"")
+	}
+	for i := 0; i < len(args); i += 2 {
+		flag, value := args[i], args[i+1]
+		if !slices.Contains(ExtraArgFlags, flag) {
+			return fmt.Errorf("ключ %q в extra_args не разрешен, допустимы: %s", flag, strings.Join(ExtraArgFlags, " "))
+		}
+		// Значение, похожее на ключ, сдвинуло бы разбор: следующий аргумент стал бы значением
+		if _, err := strconv.ParseFloat(value, 64); value == "" || strings.HasPrefix(value, "-") && err != nil {
+			return fmt.Errorf("у ключа %s в extra_args нет значения", flag)
+		}
+	}
+	return nil
+}
+
+// DefaultProfile - профиль по умолчанию, совпадает с прежними параметрами сборки
+const DefaultProfile = "default"
+
+// builtinProfiles доступны всегда; профиль с тем же именем в конфиге их заменяет
+var builtinProfiles = []EncodingProfile{
+	{Name: DefaultProfile, Codec: "h264", CRF: 23, PreviewSpeed: 5, PreviewWidth: 320},
+	{Name: "quality", Codec: "h264", CRF: 18, Preset: "slow", PreviewSpeed: 5, PreviewWidth: 320},
+	{Name: "small", Codec: "h265", CRF: 28, Preset: "medium", Width: 1280, PreviewSpeed: 5, PreviewWidth: 320},
+	{Name: "vp9", Codec: "vp9", CRF: 32, Preset: "good", PreviewSpeed: 5, PreviewWidth: 320},
+	{Name: "av1", Codec: "av1", CRF: 35, Preset: "8", PreviewSpeed: 5, PreviewWidth: 320},
+}
+
+// EncodingProfiles возвращает встроенные профили с учетом профилей из конфига
+func (cfg *Config) EncodingProfiles() []EncodingProfile {
+	profiles := slices.Clone(builtinProfiles)
+	for _, profile := range cfg.Timelapse.Profiles {
+		if i := slices.IndexFunc(profiles, func(p EncodingProfile) bool { return p.Name == profile.Name }); i >= 0 {
+			profiles[i] = profile
+		} else {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// EncodingProfile ищет профиль по имени, пустое имя - профиль, выбранный в настройках
+func (cfg *Config) EncodingProfile(name string) (EncodingProfile, bool) {
+	if name == "" {
+		name = cfg.Timelapse.Profile
+	}
+	for _, profile := range cfg.EncodingProfiles() {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return EncodingProfile{}, false
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Секреты в config.yaml можно хранить зашифрованными (AES-256-GCM). Ключ берется из
+// переменной BAMBU_SECRET_KEY, из файла, указанного в BAMBU_SECRET_KEY_FILE, или из
+// файла secret.key в папке данных. Ключ - произвольная строка, из нее берется SHA-256.
+// Без ключа секреты хранятся как есть. Зашифрованное значение выглядит как "enc:v1:...".
+
+// secretFields - поля, которые шифруются при сохранении. Пароли и ключи мест выгрузки
+// (upload.targets) шифруются тоже, см. secretValues.
+var secretFields = []string{"printer.password", "web.password", "telegram.token"}
+
+const (
+	encryptedPrefix = "enc:v1:"
+	secretKeyFile   = "secret.key"
+)
+
+// secretKey возвращает ключ шифрования или nil, если шифрование не настроено
+func secretKey() ([]byte, error) {
+	raw, _, ok, err := lookupEnv(EnvPrefix + "SECRET_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		data, err := os.ReadFile(Path(secretKeyFile))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw = strings.TrimRight(string(data), "\r\n")
+	}
+	if raw == "" {
+		return nil, errors.New("ключ шифрования пустой")
+	}
+	sum := sha256.Sum256([]byte(raw))
+	return sum[:], nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptSecret(key []byte, value string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(key []byte, value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("слишком короткое значение")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("неверный ключ или поврежденное значение")
+	}
+	return string(plain), nil
+}
+
+// secretValues вызывает fn для каждого секретного поля
+func (cfg *Config) secretValues(fn func(field string, value reflect.Value)) {
+	current := reflect.ValueOf(cfg).Elem()
+	forEachField(func(field string, index []int) {
+		for _, secret := range secretFields {
+			if field == secret {
+				fn(field, current.FieldByIndex(index))
+			}
+		}
+	})
+	for i := range cfg.Upload.Targets {
+		target := &cfg.Upload.Targets[i]
+		fn("upload.targets."+target.Name+".password", reflect.ValueOf(&target.Password).Elem())
+		fn("upload.targets."+target.Name+".secret_key", reflect.ValueOf(&target.SecretKey).Elem())
+	}
+}
+
+// decryptSecrets расшифровывает значения, прочитанные из файла. Возвращает true, если
+// в файле есть незашифрованные секреты, а ключ задан, - тогда файл стоит пересохранить.
+// Если значение расшифровать нельзя, возвращается ошибка: иначе следующее сохранение
+// затерло бы секрет пустой строкой.
+func (cfg *Config) decryptSecrets() (bool, error) {
+	key, err := secretKey()
+	if err != nil {
+		return false, fmt.Errorf("ключ шифрования: %w", err)
+	}
+
+	plaintext := false
+	cfg.secretValues(func(field string, value reflect.Value) {
+		if err != nil || value.String() == "" {
+			return
+		}
+		if !strings.HasPrefix(value.String(), encryptedPrefix) {
+			plaintext = plaintext || key != nil
+			return
+		}
+		if key == nil {
+			err = fmt.Errorf("%s: значение зашифровано, но ключ не задан (%sSECRET_KEY или %s)", field, EnvPrefix, secretKeyFile)
+			return
+		}
+		var plain string
+		if plain, err = decryptSecret(key, value.String()); err != nil {
+			err = fmt.Errorf("%s: %w", field, err)
+			return
+		}
+		value.SetString(plain)
+	})
+	return plaintext, err
+}
+
+// sealSecrets шифрует незашифрованные секреты прямо в тексте yaml, не трогая остальное
+// (версию, неизвестные поля, комментарии), - для резервных копий, которые должны остаться
+// копиями. Без ключа возвращает данные как есть. changed - были ли открытые секреты.
+func sealSecrets(data []byte) (sealed []byte, changed bool, err error) {
+	key, err := secretKey()
+	if err != nil || key == nil {
+		return data, false, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, false, err
+	}
+	if len(doc.Content) == 0 {
+		return data, false, nil
+	}
+
+	seal := func(node *yaml.Node) {
+		if err != nil || node == nil || node.Kind != yaml.ScalarNode || node.Value == "" || strings.HasPrefix(node.Value, encryptedPrefix) {
+			return
+		}
+		node.Value, err = encryptSecret(key, node.Value)
+		node.Tag, node.Style = "!!str", 0
+		changed = true
+	}
+	root := doc.Content[0]
+	for _, field := range secretFields {
+		section, name, _ := strings.Cut(field, ".")
+		seal(yamlValue(yamlValue(root, section), name))
+	}
+	if targets := yamlValue(yamlValue(root, "upload"), "targets"); targets != nil && targets.Kind == yaml.SequenceNode {
+		for _, target := range targets.Content {
+			seal(yamlValue(target, "password"))
+			seal(yamlValue(target, "secret_key"))
+		}
+	}
+	if err != nil || !changed {
+		return data, false, err
+	}
+
+	sealed, err = yaml.Marshal(&doc)
+	return sealed, true, err
+}
+
+// yamlValue возвращает значение ключа в mapping-узле или nil
+func yamlValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// encryptSecrets шифрует секреты перед записью, если задан ключ
+func (cfg *Config) encryptSecrets() error {
+	key, err := secretKey()
+	if err != nil {
+		return fmt.Errorf("ключ шифрования: %w", err)
+	}
+	if key == nil {
+		return nil
+	}
+
+	cfg.secretValues(func(field string, value reflect.Value) {
+		if err != nil || value.String() == "" {
+			return
+		}
+		var sealed string
+		sealed, err = encryptSecret(key, value.String())
+		value.SetString(sealed)
+	})
+	return err
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"path"
+	"strings"
+)
+
+// UploadTarget - куда копировать готовые таймлапсы
+type UploadTarget struct {
+	Name string `yaml:"name" json:"name"`
+	// Type - вид хранилища, см. UploadTypes
+	Type     string `yaml:"type" json:"type"`
+	Disabled bool   `yaml:"disabled" json:"disabled"`
+	// Path - папка назначения (для s3 - префикс ключей), внутри нее создается папка сессии
+	Path string `yaml:"path" json:"path"`
+	// URL - адрес WebDAV (https://host/remote.php/dav/files/user) или S3 (http://minio:9000),
+	// для SFTP - host:port
+	URL      string `yaml:"url" json:"url"`
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+	// KeyFile - закрытый ключ SFTP, HostKey - отпечаток ключа сервера вида SHA256:...
+	// (обязателен: к серверу с другим ключом подключения не будет)
+	KeyFile string `yaml:"key_file" json:"key_file"`
+	HostKey string `yaml:"host_key" json:"host_key"`
+	// Bucket, Region, AccessKey и SecretKey - для S3-совместимых хранилищ
+	Bucket    string `yaml:"bucket" json:"bucket"`
+	Region    string `yaml:"region" json:"region"`
+	AccessKey string `yaml:"access_key" json:"access_key"`
+	SecretKey string `yaml:"secret_key" json:"secret_key"`
+}
+
+// Виды хранилищ для выгрузки
+const (
+	UploadLocal  = "local"
+	UploadWebDAV = "webdav"
+	UploadSFTP   = "sftp"
+	UploadS3     = "s3"
+)
+
+var UploadTypes = []string{UploadLocal, UploadWebDAV, UploadSFTP, UploadS3}
+
+// Destination - куда выгружает место назначения, для логов и интерфейса (без секретов)
+func (t UploadTarget) Destination() string {
+	switch t.Type {
+	case UploadLocal:
+		return t.Path
+	case UploadS3:
+		return strings.TrimRight(t.URL, "/") + "/" + path.Join(t.Bucket, t.Path)
+	default:
+		return strings.TrimRight(strings.TrimRight(t.URL, "/")+"/"+strings.TrimLeft(t.Path, "/"), "/")
+	}
+}
+
+// UploadTargets возвращает включенные места назначения
+func (cfg *Config) UploadTargets() []UploadTarget {
+	var targets []UploadTarget
+	for _, target := range cfg.Upload.Targets {
+		if !target.Disabled {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// UploadTarget ищет место назначения по имени, в том числе выключенное
+func (cfg *Config) UploadTarget(name string) (UploadTarget, bool) {
+	for _, target := range cfg.Upload.Targets {
+		if target.Name == name {
+			return target, true
+		}
+	}
+	return UploadTarget{}, false
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// FieldErrors - ошибки проверки настроек по полям. Ключ - путь поля как в yaml ("web.port").
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var parts []string
+	for _, field := range fields {
+		parts = append(parts, field+": "+e[field])
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Add запоминает первую ошибку поля
+func (e FieldErrors) Add(field, message string) {
+	if _, ok := e[field]; !ok {
+		e[field] = message
+	}
+}
+
+var (
+	hostnameRe = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?$`)
+	serialRe   = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	tgTokenRe  = regexp.MustCompile(`^\d+:[A-Za-z0-9_-]+$`)
+)
+
+// Validate проверяет настройки и возвращает ошибки по полям (пустой список, если все в порядке)
+func (cfg *Config) Validate() FieldErrors {
+	errs := FieldErrors{}
+
+	// Принтер
+	switch {
+	case cfg.Printer.Hostname == "":
+		errs.Add("printer.hostname", "Укажите адрес принтера")
+	case net.ParseIP(cfg.Printer.Hostname) == nil && !hostnameRe.MatchString(cfg.Printer.Hostname):
+		errs.Add("printer.hostname", "Нужен IP или имя хоста без схемы и порта")
+	}
+	if cfg.Printer.EncodeWait <= 0 || cfg.Printer.EncodeWait > 60000 {
+		errs.Add("printer.encode_wait", "Допустимо от 1 до 60000 мс")
+	}
+	if cfg.Printer.Serial != "" && !serialRe.MatchString(cfg.Printer.Serial) {
+		errs.Add("printer.serial", "Серийный номер состоит только из букв и цифр")
+	}
+
+	// Веб
+	if cfg.Web.BindAddress != "" && net.ParseIP(cfg.Web.BindAddress) == nil && cfg.Web.BindAddress != "localhost" {
+		errs.Add("web.bind_address", "Нужен IP адрес, например 0.0.0.0")
+	}
+	if !validPort(cfg.Web.Port) {
+		errs.Add("web.port", "Порт должен быть от 1 до 65535")
+	}
+	if cfg.Web.SessionHours < 1 {
+		errs.Add("web.session_hours", "Не меньше 1 часа")
+	}
+	switch cfg.Web.CookieSameSite {
+	case "lax", "strict", "none":
+	default:
+		errs.Add("web.cookie_samesite", "Допустимо: lax, strict, none")
+	}
+	switch cfg.Web.Metrics {
+	case MetricsToken, MetricsPublic, MetricsOff:
+	default:
+		errs.Add("web.metrics", "Допустимо: token, public, off")
+	}
+	if strings.ContainsAny(cfg.Web.BasePath, " ?#\\") {
+		errs.Add("web.base_path", "Путь не должен содержать пробелы и символы ? # \\")
+	}
+	for _, proxy := range cfg.Web.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(proxy); err != nil {
+			errs.Add("web.trusted_proxies", "Неверный IP или подсеть: "+proxy)
+		}
+	}
+	if cfg.Web.HTTPRedirectPort != 0 {
+		if !validPort(cfg.Web.HTTPRedirectPort) {
+			errs.Add("web.http_redirect_port", "Порт должен быть от 1 до 65535 или 0")
+		} else if cfg.Web.HTTPRedirectPort == cfg.Web.Port {
+			errs.Add("web.http_redirect_port", "Должен отличаться от основного порта")
+		}
+	}
+	switch cfg.Web.TLSMode {
+	case "", "self_signed":
+	case "files":
+		if !readable(cfg.Web.TLSCertFile) {
+			errs.Add("web.tls_cert_file", "Файл сертификата не найден или недоступен")
+		}
+		if !readable(cfg.Web.TLSKeyFile) {
+			errs.Add("web.tls_key_file", "Файл ключа не найден или недоступен")
+		}
+	case "acme":
+		host := HostOnly(cfg.Web.Hostname)
+		if host == "" || net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+			errs.Add("web.hostname", "Для Let's Encrypt нужно доменное имя")
+		}
+	default:
+		errs.Add("web.tls_mode", "Неизвестный режим HTTPS")
+	}
+
+	// Таймлапс
+	if cfg.Timelapse.Interval < 0 {
+		errs.Add("timelapse.interval_seconds", "Не может быть отрицательным")
+	}
+	if cfg.Timelapse.Fps < 1 || cfg.Timelapse.Fps > 120 {
+		errs.Add("timelapse.fps", "Допустимо от 1 до 120")
+	}
+	if cfg.Timelapse.AfterLayer < 0 {
+		errs.Add("timelapse.after_layer", "Не может быть отрицательным")
+	}
+	if cfg.Timelapse.SmoothWindow < 1 || cfg.Timelapse.SmoothWindow > 60 {
+		errs.Add("timelapse.smooth_window_seconds", "Допустимо от 1 до 60")
+	}
+	if cfg.Timelapse.DedupThreshold < 0 || cfg.Timelapse.DedupThreshold > 100 {
+		errs.Add("timelapse.dedup_threshold", "Допустимо от 0 до 100")
+	}
+	if cfg.Timelapse.DedupMaxGap < 0 {
+		errs.Add("timelapse.dedup_max_gap_seconds", "Не может быть отрицательным")
+	}
+	if cfg.Timelapse.Stages != StagesAll && cfg.Timelapse.Stages != StagesPrinting {
+		errs.Add("timelapse.stages", "Допустимо "+StagesAll+" или "+StagesPrinting)
+	}
+	for field, value := range map[string]int{
+		"timelapse.keep_every_frame": cfg.Timelapse.KeepEveryFrame,
+		"timelapse.max_size_mb":      cfg.Timelapse.MaxSizeMB,
+		"timelapse.max_age_days":     cfg.Timelapse.MaxAgeDays,
+		"timelapse.min_free_mb":      cfg.Timelapse.MinFreeMB,
+	} {
+		if value < 0 {
+			errs.Add(field, "Не может быть отрицательным")
+		}
+	}
+	if cfg.Timelapse.MaxJobs < 1 || cfg.Timelapse.MaxJobs > 8 {
+		errs.Add("timelapse.max_jobs", "Допустимо от 1 до 8")
+	}
+	if cfg.Timelapse.SavePath == "" {
+		errs.Add("timelapse.save_path", "Укажите папку для таймлапсов")
+	} else if err := checkWritable(cfg.Timelapse.SavePath); err != nil {
+		errs.Add("timelapse.save_path", "Нет доступа на запись: "+err.Error())
+	}
+
+	names := map[string]bool{}
+	for _, profile := range cfg.Timelapse.Profiles {
+		switch {
+		case profile.Name == "":
+			errs.Add("timelapse.profiles", "У профиля не указано имя")
+		case names[profile.Name]:
+			errs.Add("timelapse.profiles", "Профиль "+profile.Name+" описан дважды")
+		case !slices.Contains(Codecs, profile.Codec):
+			errs.Add("timelapse.profiles", profile.Name+": кодек должен быть одним из "+strings.Join(Codecs, ", "))
+		case profile.CRF < 0 || profile.CRF > 63:
+			errs.Add("timelapse.profiles", profile.Name+": CRF допустим от 0 до 63")
+		case profile.Width < 0 || profile.PreviewWidth < 0 || profile.PreviewSpeed < 0:
+			errs.Add("timelapse.profiles", profile.Name+": размеры и скорость не могут быть отрицательными")
+		default:
+			if err := CheckExtraArgs(profile.ExtraArgs); err != nil {
+				errs.Add("timelapse.profiles", profile.Name+": "+err.Error())
+			}
+		}
+		names[profile.Name] = true
+	}
+	if _, ok := cfg.EncodingProfile(cfg.Timelapse.Profile); !ok {
+		errs.Add("timelapse.profile", "Нет профиля с именем "+cfg.Timelapse.Profile)
+	}
+
+	names = map[string]bool{}
+	for _, tpl := range cfg.Timelapse.Overlays {
+		if err := validateOverlay(tpl, names); err != "" {
+			errs.Add("timelapse.overlays", err)
+		}
+		names[tpl.Name] = true
+	}
+	if _, ok := cfg.OverlayTemplate(cfg.Timelapse.Overlay); !ok {
+		errs.Add("timelapse.overlay", "Нет шаблона с именем "+cfg.Timelapse.Overlay)
+	}
+
+	// Выгрузка
+	if cfg.Upload.Retries < 0 || cfg.Upload.Retries > 100 {
+		errs.Add("upload.retries", "Допустимо от 0 до 100")
+	}
+	if cfg.Upload.RetryDelay < 1 {
+		errs.Add("upload.retry_delay_seconds", "Не меньше 1 секунды")
+	}
+	names = map[string]bool{}
+	for _, target := range cfg.Upload.Targets {
+		if err := validateUploadTarget(target, names); err != "" {
+			errs.Add("upload.targets", err)
+		}
+		names[target.Name] = true
+	}
+
+	// Telegram
+	if cfg.Telegram.Token != "" && !tgTokenRe.MatchString(cfg.Telegram.Token) {
+		errs.Add("telegram.token", "Токен выглядит как 123456:ABC-DEF...")
+	}
+	for _, id := range cfg.Telegram.AdminIds {
+		if id <= 0 {
+			errs.Add("telegram.admin_ids", "ID пользователя должен быть положительным числом")
+		}
+	}
+
+	return errs
+}
+
+// resetInvalid заменяет поля с ошибками значениями по умолчанию
+func (cfg *Config) resetInvalid(errs FieldErrors) {
+	defaults := reflect.ValueOf(DefaultConfig()).Elem()
+	current := reflect.ValueOf(cfg).Elem()
+	forEachField(func(field string, index []int) {
+		if _, ok := errs[field]; ok {
+			current.FieldByIndex(index).Set(defaults.FieldByIndex(index))
+		}
+	})
+}
+
+func validPort(port int) bool {
+	return port >= 1 && port <= 65535
+}
+
+func readable(path string) bool {
+	if path == "" {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// checkWritable проверяет, что в папку можно писать, ничего не создавая на диске.
+// Несуществующую папку создаст replaceConfig, поэтому для нее проверяется ближайшая
+// существующая родительская.
+func checkWritable(dir string) error {
+	dir = filepath.Clean(dir)
+	for {
+		st, err := os.Stat(dir)
+		if err == nil {
+			if !st.IsDir() {
+				return errors.New(dir + " не папка")
+			}
+			return canWrite(dir)
+		}
+		parent := filepath.Dir(dir)
+		if !os.IsNotExist(err) || parent == dir {
+			return err
+		}
+		dir = parent
+	}
+}
+
+// validateOverlay проверяет шаблон наложения; пустые цвет, размер и положение заменяются значениями по умолчанию
+func validateOverlay(tpl OverlayTemplate, seen map[string]bool) string {
+	switch {
+	case tpl.Name == "":
+		return "У шаблона наложения не указано имя"
+	case tpl.Name == NoOverlay:
+		return "Имя шаблона " + NoOverlay + " зарезервировано для сборки без наложения"
+	case seen[tpl.Name]:
+		return "Шаблон " + tpl.Name + " описан дважды"
+	case tpl.Position != "" && !slices.Contains(OverlayPositions, tpl.Position):
+		return tpl.Name + ": положение должно быть одним из " + strings.Join(OverlayPositions, ", ")
+	case tpl.FontSize != 0 && (tpl.FontSize < 6 || tpl.FontSize > 200):
+		return tpl.Name + ": размер шрифта допустим от 6 до 200"
+	}
+	for _, field := range tpl.Fields {
+		if !slices.Contains(OverlayFields, field) {
+			return tpl.Name + ": неизвестное поле " + field + ", допустимы " + strings.Join(OverlayFields, ", ")
+		}
+	}
+	for _, c := range []string{tpl.Color, tpl.Background} {
+		if c == "" {
+			continue
+		}
+		if _, err := ParseColor(c); err != nil {
+			return tpl.Name + ": " + err.Error()
+		}
+	}
+	if tpl.FontFile != "" {
+		if _, err := os.Stat(tpl.FontFile); err != nil {
+			return tpl.Name + ": файл шрифта недоступен: " + err.Error()
+		}
+	}
+	return ""
+}
+
+func validateUploadTarget(target UploadTarget, seen map[string]bool) string {
+	switch {
+	case target.Name == "":
+		return "У места выгрузки не указано имя"
+	case strings.ContainsAny(target.Name, `/\ `):
+		return target.Name + ": имя не может содержать пробелы и слэши"
+	case seen[target.Name]:
+		return "Место выгрузки " + target.Name + " описано дважды"
+	case !slices.Contains(UploadTypes, target.Type):
+		return target.Name + ": тип должен быть одним из " + strings.Join(UploadTypes, ", ")
+	}
+
+	switch target.Type {
+	case UploadLocal:
+		if target.Path == "" {
+			return target.Name + ": укажите папку (path)"
+		}
+	case UploadWebDAV:
+		if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return target.Name + ": url должен быть вида https://host/путь"
+		}
+	case UploadSFTP:
+		if _, _, err := net.SplitHostPort(target.URL); err != nil {
+			return target.Name + ": url должен быть вида host:port"
+		}
+		if target.Username == "" || (target.Password == "" && target.KeyFile == "") {
+			return target.Name + ": укажите username и password или key_file"
+		}
+		// Без проверки ключа сервера пароль или ключ ушли бы любому, кто ответит на адрес
+		if !strings.HasPrefix(target.HostKey, "SHA256:") {
+			return target.Name + ": укажите host_key - отпечаток ключа сервера вида SHA256:... (ssh-keyscan host | ssh-keygen -lf -)"
+		}
+		if target.KeyFile != "" {
+			if _, err := os.Stat(target.KeyFile); err != nil {
+				return target.Name + ": файл ключа недоступен: " + err.Error()
+			}
+		}
+	case UploadS3:
+		if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return target.Name + ": url должен быть вида http://minio:9000"
+		}
+		if target.Bucket == "" || target.AccessKey == "" || target.SecretKey == "" {
+			return target.Name + ": укажите bucket, access_key и secret_key"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// validConfig - настройки по умолчанию, которые проходят проверку
+func validConfig(t *testing.T) *Config {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Printer.Hostname = "192.168.1.10"
+	cfg.Timelapse.SavePath = t.TempDir()
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	if errs := validConfig(t).Validate(); len(errs) != 0 {
+		t.Fatalf("default config is invalid: %v", errs)
+	}
+
+	sftp := UploadTarget{Name: "nas", Type: UploadSFTP, URL: "nas:22", Username: "pi", Password: "x"}
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		field  string // "" - ошибок быть не должно
+	}{
+		{"hostname with scheme", func(cfg *Config) { cfg.Printer.Hostname = "http://printer" }, "printer.hostname"},
+		{"hostname as name", func(cfg *Config) { cfg.Printer.Hostname = "printer.lan" }, ""},
+		{"empty hostname", func(cfg *Config) { cfg.Printer.Hostname = "" }, "printer.hostname"},
+		{"encode wait", func(cfg *Config) { cfg.Printer.EncodeWait = 0 }, "printer.encode_wait"},
+		{"serial", func(cfg *Config) { cfg.Printer.Serial = "01-P" }, "printer.serial"},
+		{"port", func(cfg *Config) { cfg.Web.Port = 70000 }, "web.port"},
+		{"redirect to same port", func(cfg *Config) { cfg.Web.HTTPRedirectPort = cfg.Web.Port }, "web.http_redirect_port"},
+		{"samesite", func(cfg *Config) { cfg.Web.CookieSameSite = "off" }, "web.cookie_samesite"},
+		{"metrics", func(cfg *Config) { cfg.Web.Metrics = "open" }, "web.metrics"},
+		{"proxy subnet", func(cfg *Config) { cfg.Web.TrustedProxies = []string{"10.0.0.0/8", "::1"} }, ""},
+		{"proxy garbage", func(cfg *Config) { cfg.Web.TrustedProxies = []string{"proxy"} }, "web.trusted_proxies"},
+		{"acme without domain", func(cfg *Config) { cfg.Web.TLSMode = "acme"; cfg.Web.Hostname = "192.168.1.5" }, "web.hostname"},
+		{"tls files missing", func(cfg *Config) {
+			cfg.Web.TLSMode, cfg.Web.TLSCertFile, cfg.Web.TLSKeyFile = "files", "missing.crt", "validate_test.go"
+		}, "web.tls_cert_file"},
+		{"fps", func(cfg *Config) { cfg.Timelapse.Fps = 0 }, "timelapse.fps"},
+		{"stages", func(cfg *Config) { cfg.Timelapse.Stages = "some" }, "timelapse.stages"},
+		{"negative retention", func(cfg *Config) { cfg.Timelapse.MaxAgeDays = -1 }, "timelapse.max_age_days"},
+		{"max jobs", func(cfg *Config) { cfg.Timelapse.MaxJobs = 0 }, "timelapse.max_jobs"},
+		{"unknown profile", func(cfg *Config) { cfg.Timelapse.Profile = "missing" }, "timelapse.profile"},
+		{"profile codec", func(cfg *Config) {
+			cfg.Timelapse.Profiles = []EncodingProfile{{Name: "mine", Codec: "mpeg2"}}
+		}, "timelapse.profiles"},
+		{"profile extra args", func(cfg *Config) {
+			cfg.Timelapse.Profiles = []EncodingProfile{{Name: "mine", Codec: "h264", ExtraArgs: []string{"-tune", "film", "-bf", "-1"}}}
+		}, ""},
+		{"extra args with input", func(cfg *Config) {
+			cfg.Timelapse.Profiles = []EncodingProfile{{Name: "mine", Codec: "h264", ExtraArgs: []string{"-i", "/etc/passwd"}}}
+		}, "timelapse.profiles"},
+		{"extra args with output file", func(cfg *Config) {
+			cfg.Timelapse.Profiles = []EncodingProfile{{Name: "mine", Codec: "h264", ExtraArgs: []string{"-tune", "film", "/tmp/copy.mp4"}}}
+		}, "timelapse.profiles"},
+		{"extra args flag as value", func(cfg *Config) {
+			cfg.Timelapse.Profiles = []EncodingProfile{{Name: "mine", Codec: "h264", ExtraArgs: []string{"-tune", "-y"}}}
+		}, "timelapse.profiles"},
+		{"unknown overlay", func(cfg *Config) { cfg.Timelapse.Overlay = "missing" }, "timelapse.overlay"},
+		{"sftp without host key", func(cfg *Config) { cfg.Upload.Targets = []UploadTarget{sftp} }, "upload.targets"},
+		{"sftp with host key", func(cfg *Config) {
+			target := sftp
+			target.HostKey = "SHA256:abc"
+			cfg.Upload.Targets = []UploadTarget{target}
+		}, ""},
+		{"duplicate target", func(cfg *Config) {
+			local := UploadTarget{Name: "disk", Type: UploadLocal, Path: "/mnt"}
+			cfg.Upload.Targets = []UploadTarget{local, local}
+		}, "upload.targets"},
+		{"telegram token", func(cfg *Config) { cfg.Telegram.Token = "token" }, "telegram.token"},
+		{"telegram admin", func(cfg *Config) { cfg.Telegram.AdminIds = []int64{-5} }, "telegram.admin_ids"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig(t)
+			tt.change(cfg)
+			errs := cfg.Validate()
+			if tt.field == "" {
+				if len(errs) != 0 {
+					t.Fatalf("unexpected errors: %v", errs)
+				}
+				return
+			}
+			if _, ok := errs[tt.field]; !ok || len(errs) != 1 {
+				t.Fatalf("errors = %v, want only %s", errs, tt.field)
+			}
+		})
+	}
+}
+
+func TestResetInvalid(t *testing.T) {
+	cfg := validConfig(t)
+	cfg.Web.Port = 0
+	cfg.Timelapse.Fps = 500
+	cfg.Telegram.AdminIds = []int64{1, -1}
+	cfg.Printer.Serial = "SERIAL01"
+
+	errs := cfg.Validate()
+	cfg.resetInvalid(errs)
+
+	defaults := DefaultConfig()
+	if cfg.Web.Port != defaults.Web.Port || cfg.Timelapse.Fps != defaults.Timelapse.Fps || cfg.Telegram.AdminIds != nil {
+		t.Errorf("invalid fields not reset: port %d, fps %d, admins %v", cfg.Web.Port, cfg.Timelapse.Fps, cfg.Telegram.AdminIds)
+	}
+	if cfg.Printer.Serial != "SERIAL01" || cfg.Printer.Hostname != "192.168.1.10" {
+		t.Errorf("valid fields changed: %+v", cfg.Printer)
+	}
+	if errs := cfg.Validate(); len(errs) != 0 {
+		t.Errorf("still invalid after reset: %v", errs)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "file")
+	os.WriteFile(file, nil, 0644)
+
+	tests := []struct {
+		name string
+		dir  string
+		ok   bool
+	}{
+		{"existing", root, true},
+		{"missing", filepath.Join(root, "a", "b"), true},
+		{"file", file, false},
+		{"under file", filepath.Join(file, "a"), false},
+	}
+	for _, tt := range tests {
+		if err := checkWritable(tt.dir); (err == nil) != tt.ok {
+			t.Errorf("checkWritable(%s) = %v", tt.name, err)
+		}
+	}
+	// Проверка ничего не создает - папки создаются только при сохранении настроек
+	if _, err := os.Stat(filepath.Join(root, "a")); !os.IsNotExist(err) {
+		t.Errorf("checkWritable created a folder: %v", err)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Errorf("checkWritable left files: %v", entries)
+	}
+}
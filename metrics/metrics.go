@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "bambu"
+
+// Счетчики живут на уровне пакета, чтобы не обнуляться при перезапуске компонентов
+var (
+	CameraReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "camera_reconnects_total",
+		Help:      "Количество переподключений к камере принтера.",
+	})
+
+	MQTTDisconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_disconnects_total",
+		Help:      "Количество потерь связи с MQTT брокером принтера.",
+	})
+
+	FramesCaptured = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "timelapse_frames_captured_total",
+		Help:      "Количество кадров, сохраненных для таймлапсов.",
+	})
+
+	FramesSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "timelapse_frames_skipped_total",
+		Help:      "Количество кадров, пропущенных как повтор предыдущего.",
+	})
+
+	TimelapseAssemblies = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "timelapse_assemblies_total",
+		Help:      "Количество успешных сборок видео таймлапса.",
+	})
+
+	TimelapseFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "timelapse_assembly_failures_total",
+		Help:      "Количество неудачных сборок видео таймлапса.",
+	})
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Количество HTTP запросов к веб-серверу.",
+	}, []string{"method", "route", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Время обработки HTTP запросов.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// GinMiddleware собирает метрики HTTP запросов роутера
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// Неизвестные роуты схлопываем в один лейбл, иначе сканеры раздуют кардинальность
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler отдает метрики в формате Prometheus. Реестр создается на каждый запуск
+// веб-сервера, поэтому перезапуск приложения не приводит к повторной регистрации.
+func Handler(src StatusSource) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		CameraReconnects,
+		MQTTDisconnects,
+		FramesCaptured,
+		FramesSkipped,
+		TimelapseAssemblies,
+		TimelapseFailures,
+		httpRequests,
+		httpDuration,
+		newPrinterCollector(src),
+	)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatusSource отдает текущее состояние принтера (реализуется App)
+type StatusSource interface {
+	GetStatus() map[string]any
+}
+
+// gcodeStates перечисляет известные значения gcode_state для метрики-перечисления
+var gcodeStates = []string{"IDLE", "PREPARE", "RUNNING", "PAUSE", "FINISH", "FAILED", "SLICING"}
+
+type statusGauge struct {
+	desc  *prometheus.Desc
+	key   string
+	scale float64
+}
+
+// printerCollector снимает значения из статуса принтера в момент опроса
+type printerCollector struct {
+	gauges []statusGauge
+	state  *prometheus.Desc
+	online *prometheus.Desc
+	fps    *prometheus.Desc
+	src    StatusSource
+}
+
+func newPrinterCollector(src StatusSource) *printerCollector {
+	gauge := func(name, help, key string, scale float64) statusGauge {
+		return statusGauge{
+			desc:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "printer", name), help, nil, nil),
+			key:   key,
+			scale: scale,
+		}
+	}
+
+	return &printerCollector{
+		src: src,
+		gauges: []statusGauge{
+			gauge("nozzle_temperature_celsius", "Текущая температура сопла.", "nozzle_temper", 1),
+			gauge("nozzle_target_temperature_celsius", "Целевая температура сопла.", "nozzle_target_temper", 1),
+			gauge("bed_temperature_celsius", "Текущая температура стола.", "bed_temper", 1),
+			gauge("bed_target_temperature_celsius", "Целевая температура стола.", "bed_target_temper", 1),
+			gauge("chamber_temperature_celsius", "Температура в камере.", "chamber_temper", 1),
+			gauge("progress_percent", "Прогресс печати в процентах.", "mc_percent", 1),
+			gauge("layer", "Текущий слой.", "layer_num", 1),
+			gauge("layers_total", "Всего слоев в задании.", "total_layer_num", 1),
+			gauge("remaining_seconds", "Оставшееся время печати.", "mc_remaining_time", 60),
+		},
+		state: prometheus.NewDesc(prometheus.BuildFQName(namespace, "printer", "gcode_state"),
+			"Состояние печати (1 для текущего значения gcode_state).", []string{"state"}, nil),
+		online: prometheus.NewDesc(prometheus.BuildFQName(namespace, "camera", "online"),
+			"Камера принтера на связи.", nil, nil),
+		fps: prometheus.NewDesc(prometheus.BuildFQName(namespace, "camera", "fps"),
+			"Частота кадров камеры.", nil, nil),
+	}
+}
+
+func (p *printerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, g := range p.gauges {
+		ch <- g.desc
+	}
+	ch <- p.state
+	ch <- p.online
+	ch <- p.fps
+}
+
+func (p *printerCollector) Collect(ch chan<- prometheus.Metric) {
+	status := p.src.GetStatus()
+
+	for _, g := range p.gauges {
+		if val, ok := toFloat(status[g.key]); ok {
+			ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, val*g.scale)
+		}
+	}
+
+	current, _ := status["gcode_state"].(string)
+	known := false
+	for _, state := range gcodeStates {
+		val := 0.0
+		if state == current {
+			val = 1
+			known = true
+		}
+		ch <- prometheus.MustNewConstMetric(p.state, prometheus.GaugeValue, val, state)
+	}
+	if !known && current != "" {
+		ch <- prometheus.MustNewConstMetric(p.state, prometheus.GaugeValue, 1, current)
+	}
+
+	online := 0.0
+	if val, _ := status["online"].(bool); val {
+		online = 1
+	}
+	ch <- prometheus.MustNewConstMetric(p.online, prometheus.GaugeValue, online)
+
+	if val, ok := toFloat(status["fps"]); ok {
+		ch <- prometheus.MustNewConstMetric(p.fps, prometheus.GaugeValue, val)
+	}
+}
+
+// toFloat приводит числовые значения из JSON отчета принтера к float64.
+// Часть полей (например, температуры на некоторых прошивках) приходит строкой.
+func toFloat(v any) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case int:
+		return float64(val), true
+	case string:
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
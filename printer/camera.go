@@ -1,6 +1,7 @@
 package printer
 
 import (
+	"bambucam/metrics"
 	"crypto/tls"
 	"encoding/binary"
 	"fmt"
@@ -41,11 +42,17 @@ func (b *BambuCamera) run() {
 	copy(authData[16:48], username)
 	copy(authData[48:80], b.core.GetConfig().Printer.Password)
 
+	attempt := 0
 	for {
 		select {
 		case <-b.stopChan:
 			return
 		default:
+			if attempt > 0 {
+				metrics.CameraReconnects.Inc()
+			}
+			attempt++
+
 			log.Printf("[Camera] Connecting to %s:%d", b.core.GetConfig().Printer.Hostname, port)
 			conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", b.core.GetConfig().Printer.Hostname, port), 5*time.Second)
 			if err != nil {
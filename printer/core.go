@@ -1,6 +1,13 @@
 package printer
 
-import "bambucam/config"
+import (
+	"bambucam/auth"
+	"bambucam/config"
+	"bambucam/printer/events"
+	"bambucam/printer/history"
+	"bambucam/printer/jobs"
+	"bambucam/printer/upload"
+)
 
 type Core interface {
 	Start()
@@ -13,6 +20,7 @@ type Core interface {
 	UpdateFrame(frame []byte, fps float64)
 	GetStatus() map[string]any
 	UpdateStatus(status map[string]any)
+	Events() *events.Bus
 	GetConfig() *config.Config
 	SetConfig(cfg *config.Config)
 
@@ -20,7 +28,12 @@ type Core interface {
 	StopPrinting()
 	TogglePause()
 
-	AssembleVideo(folderName string) error
+	// Jobs - очередь сборки видео и превью таймлапсов
+	Jobs() *jobs.Queue
+	// Uploads - выгрузка готовых таймлапсов в места из upload.targets
+	Uploads() *upload.Uploader
+	GetHistory() []history.Record
+	Audit() *auth.AuditLog
 
 	GetAppVersion() string
 }
@@ -0,0 +1,100 @@
+package events
+
+import (
+	"slices"
+	"sync"
+)
+
+// Типы событий, рассылаемых подписчикам
+const (
+	TypeStatus    = "status"    // изменившиеся поля статуса принтера
+	TypeCamera    = "camera"    // камера появилась или пропала
+	TypeTimelapse = "timelapse" // смена состояния записи таймлапса
+	TypeJob       = "job"       // изменение задания сборки таймлапса
+	TypeUpload    = "upload"    // изменение состояния выгрузки таймлапса
+	TypeAudit     = "audit"     // запись журнала аудита (в SSE не отдается)
+)
+
+type Event struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// Bus рассылает события всем подписчикам. Медленный подписчик не тормозит
+// остальных: если его буфер заполнен, подписка закрывается. Так подписчик узнает,
+// что пропустил события, и может подписаться заново и запросить полное состояние -
+// браузер, например, переподключается к SSE и получает полный статус.
+type Bus struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   map[int]subscriber
+}
+
+type subscriber struct {
+	ch chan Event
+	// types - нужные подписчику типы событий, пусто - все
+	types []string
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[int]subscriber)}
+}
+
+// Subscribe возвращает канал событий и функцию отписки. Канал закрывается после
+// отписки или если подписчик не успевает забирать события. Если указаны types,
+// приходят только события этих типов: частые изменения статуса не вытеснят редкие,
+// но важные события.
+func (b *Bus) Subscribe(buffer int, types ...string) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	ch := make(chan Event, buffer)
+	b.subs[id] = subscriber{ch: ch, types: types}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(id)
+	}
+}
+
+// remove закрывает канал подписчика, если он еще не закрыт. Вызывается под b.mu.
+func (b *Bus) remove(id int) {
+	if sub, ok := b.subs[id]; ok {
+		delete(b.subs, id)
+		close(sub.ch)
+	}
+}
+
+func (b *Bus) Publish(eventType string, data any) {
+	if b == nil {
+		return
+	}
+
+	evt := Event{Type: eventType, Data: data}
+	var overflow []int
+
+	b.mu.RLock()
+	for id, sub := range b.subs {
+		if len(sub.types) > 0 && !slices.Contains(sub.types, eventType) {
+			continue
+		}
+		select {
+		case sub.ch <- evt:
+		default:
+			overflow = append(overflow, id)
+		}
+	}
+	b.mu.RUnlock()
+
+	if len(overflow) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range overflow {
+		b.remove(id)
+	}
+}
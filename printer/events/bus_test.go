@@ -0,0 +1,50 @@
+package events
+
+import "testing"
+
+func TestPublishClosesSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	slow, unsubscribeSlow := bus.Subscribe(1)
+	fast, unsubscribeFast := bus.Subscribe(4)
+	defer unsubscribeFast()
+
+	bus.Publish(TypeStatus, 1)
+	bus.Publish(TypeStatus, 2)
+
+	// Первое событие доходит, после переполнения канал закрыт
+	if evt, ok := <-slow; !ok || evt.Data != 1 {
+		t.Fatalf("first event = %v, %v", evt, ok)
+	}
+	if _, ok := <-slow; ok {
+		t.Fatal("slow subscriber channel is still open after overflow")
+	}
+	// Повторная отписка после закрытия не паникует
+	unsubscribeSlow()
+
+	for want := 1; want <= 2; want++ {
+		if evt := <-fast; evt.Data != want {
+			t.Fatalf("fast subscriber got %v, want %d", evt.Data, want)
+		}
+	}
+}
+
+func TestSubscribeTypes(t *testing.T) {
+	bus := NewBus()
+	audit, unsubscribe := bus.Subscribe(1, TypeAudit)
+	defer unsubscribe()
+
+	// Поток изменений статуса не переполняет подписку только на аудит
+	for i := range 10 {
+		bus.Publish(TypeStatus, i)
+	}
+	bus.Publish(TypeAudit, "login")
+
+	if evt, ok := <-audit; !ok || evt.Type != TypeAudit || evt.Data != "login" {
+		t.Fatalf("audit subscriber got %v, %v", evt, ok)
+	}
+	select {
+	case evt, ok := <-audit:
+		t.Fatalf("unexpected event %v, %v", evt, ok)
+	default:
+	}
+}
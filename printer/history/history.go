@@ -0,0 +1,161 @@
+package history
+
+import (
+	"bambucam/config"
+	"bambucam/printer/events"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxRecords ограничивает размер файла истории
+const maxRecords = 500
+
+type Record struct {
+	Task       string    `json:"task"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	// Result - итоговый gcode_state (FINISH, FAILED, ...), пусто пока печать идет
+	Result      string `json:"result"`
+	TotalLayers int    `json:"total_layers"`
+}
+
+// Source - часть printer.Core, нужная истории (сам Core импортировать нельзя из-за цикла)
+type Source interface {
+	Events() *events.Bus
+	GetStatus() map[string]any
+}
+
+// History записывает начало и окончание каждой печати по событиям статуса принтера
+type History struct {
+	core Source
+
+	mu        sync.RWMutex
+	records   []Record
+	lastState string
+	stop      chan struct{}
+}
+
+func NewHistory(core Source) *History {
+	return &History{
+		core: core,
+		stop: make(chan struct{}),
+	}
+}
+
+func (h *History) Start() {
+	h.load()
+	ch, unsubscribe := h.core.Events().Subscribe(32)
+	go func() {
+		defer func() { unsubscribe() }()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case evt, ok := <-ch:
+				if !ok {
+					// Не успели за событиями: подписываемся заново и сверяемся с полным статусом
+					log.Println("[History] Пропущены события статуса, переподписка")
+					ch, unsubscribe = h.core.Events().Subscribe(32)
+					h.handleStatus(h.core.GetStatus())
+					continue
+				}
+				if evt.Type == events.TypeStatus {
+					h.handleStatus(evt.Data.(map[string]any))
+				}
+			}
+		}
+	}()
+}
+
+func (h *History) Stop() {
+	close(h.stop)
+}
+
+// Records возвращает копию истории, новые печати первыми
+func (h *History) Records() []Record {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	list := make([]Record, 0, len(h.records))
+	for i := len(h.records) - 1; i >= 0; i-- {
+		list = append(list, h.records[i])
+	}
+	return list
+}
+
+func (h *History) handleStatus(delta map[string]any) {
+	state, ok := delta["gcode_state"].(string)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	prev := h.lastState
+	h.lastState = state
+	if prev == state {
+		return
+	}
+
+	active := isActive(state)
+	running := len(h.records) > 0 && h.records[len(h.records)-1].Result == ""
+
+	switch {
+	case active && !running:
+		status := h.core.GetStatus()
+		task, _ := status["subtask_name"].(string)
+		layers, _ := status["total_layer_num"].(float64)
+		h.records = append(h.records, Record{
+			Task:        task,
+			StartedAt:   time.Now(),
+			TotalLayers: int(layers),
+		})
+	case !active && running:
+		// Если приложение было выключено во время печати, запись закроется первым же отчетом
+		last := &h.records[len(h.records)-1]
+		last.FinishedAt = time.Now()
+		last.Result = state
+	default:
+		return
+	}
+
+	if len(h.records) > maxRecords {
+		h.records = h.records[len(h.records)-maxRecords:]
+	}
+	h.save()
+}
+
+func isActive(state string) bool {
+	switch state {
+	case "RUNNING", "PREPARE", "PAUSE", "SLICING":
+		return true
+	}
+	return false
+}
+
+func (h *History) load() {
+	data, err := os.ReadFile(config.Path("history.json"))
+	if err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := json.Unmarshal(data, &h.records); err != nil {
+		log.Println("[History] Ошибка чтения истории:", err)
+	}
+}
+
+func (h *History) save() {
+	data, err := json.MarshalIndent(h.records, "", " ")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(config.Path("history.json"), data, 0644); err != nil {
+		log.Println("[History] Ошибка сохранения истории:", err)
+	}
+}
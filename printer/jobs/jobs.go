@@ -0,0 +1,349 @@
+package jobs
+
+import (
+	"bambucam/config"
+	"bambucam/printer/events"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxHistory ограничивает число завершенных заданий в файле истории
+const maxHistory = 200
+
+type Status string
+
+const (
+	StatusQueued   Status = "queued"
+	StatusRunning  Status = "running"
+	StatusDone     Status = "done"
+	StatusFailed   Status = "failed"
+	StatusCanceled Status = "canceled"
+)
+
+// Источники заданий, для журнала и интерфейса
+const (
+	SourceAuto     = "auto"
+	SourceWeb      = "web"
+	SourceAPI      = "api"
+	SourceTelegram = "telegram"
+)
+
+var (
+	ErrDuplicate = errors.New("сборка этой папки уже в очереди")
+	ErrNotFound  = errors.New("задание не найдено")
+	ErrFinished  = errors.New("задание уже завершено")
+)
+
+// Job - задание на сборку видео и превью одной сессии таймлапса
+type Job struct {
+	ID      string `json:"id"`
+	Folder  string `json:"folder"`
+	Profile string `json:"profile"`
+	// Overlay - шаблон наложения, пусто - по настройкам, "none" - без наложения
+	Overlay string `json:"overlay,omitempty"`
+	// Stages - отбор кадров (config.StagesAll, config.StagesPrinting), пусто - по настройкам
+	Stages string `json:"stages,omitempty"`
+	Source string `json:"source"`
+	Status Status `json:"status" enum:"queued,running,done,failed,canceled"`
+	// Stage - текущий этап (frames, video, preview), Progress - общий прогресс в процентах
+	Stage      string    `json:"stage,omitempty"`
+	Progress   float64   `json:"progress"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+}
+
+func (j Job) Finished() bool {
+	return j.Status != StatusQueued && j.Status != StatusRunning
+}
+
+// Runner выполняет задание; report сообщает этап и прогресс в процентах.
+// При отмене ctx закрывается, и Runner должен вернуться как можно скорее.
+type Runner func(ctx context.Context, job Job, report func(stage string, progress float64)) error
+
+// Source - часть printer.Core, нужная очереди (сам Core импортировать нельзя из-за цикла)
+type Source interface {
+	Events() *events.Bus
+	GetConfig() *config.Config
+}
+
+// Queue выполняет задания по очереди, одновременно не больше timelapse.max_jobs.
+// История заданий сохраняется в jobs.json рядом с конфигом. Очередь живет все время
+// работы приложения и переживает перезапуск компонентов.
+type Queue struct {
+	core Source
+	run  Runner
+
+	mu      sync.Mutex
+	jobs    []*Job
+	cancels map[string]context.CancelFunc
+	done    map[string]chan struct{}
+	running int
+}
+
+func NewQueue(core Source, run Runner) *Queue {
+	return &Queue{
+		core:    core,
+		run:     run,
+		cancels: make(map[string]context.CancelFunc),
+		done:    make(map[string]chan struct{}),
+	}
+}
+
+// Load читает историю. Задания, не завершившиеся до перезапуска, помечаются ошибкой:
+// прерванные автосборки повторит восстановление сессий таймлапса.
+func (q *Queue) Load() {
+	data, err := os.ReadFile(config.Path("jobs.json"))
+	if err != nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := json.Unmarshal(data, &q.jobs); err != nil {
+		log.Println("[Jobs] Ошибка чтения истории заданий:", err)
+		return
+	}
+	for _, job := range q.jobs {
+		if !job.Finished() {
+			job.Status = StatusFailed
+			job.Error = "прервано перезапуском"
+			job.FinishedAt = time.Now()
+		}
+	}
+}
+
+// Enqueue ставит сборку в очередь; из req берутся папка, параметры сборки и источник.
+// Если папка уже в очереди, возвращает это задание и ErrDuplicate.
+func (q *Queue) Enqueue(req Job) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.jobs {
+		if job.Folder == req.Folder && !job.Finished() {
+			return *job, ErrDuplicate
+		}
+	}
+
+	job := &Job{
+		ID:        newID(),
+		Folder:    req.Folder,
+		Profile:   req.Profile,
+		Overlay:   req.Overlay,
+		Stages:    req.Stages,
+		Source:    req.Source,
+		Status:    StatusQueued,
+		CreatedAt: time.Now(),
+	}
+	q.jobs = append(q.jobs, job)
+	q.done[job.ID] = make(chan struct{})
+	log.Printf("[Jobs] Сборка %s поставлена в очередь (%s, профиль %q)", job.Folder, job.Source, job.Profile)
+
+	q.publish(job)
+	q.save()
+	q.dispatch()
+	return *job, nil
+}
+
+// Cancel снимает задание из очереди или останавливает запущенное
+func (q *Queue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := q.find(id)
+	switch {
+	case job == nil:
+		return Job{}, ErrNotFound
+	case job.Finished():
+		return *job, ErrFinished
+	case job.Status == StatusQueued:
+		q.finish(job, StatusCanceled, "")
+	default:
+		// Статус поменяется, когда Runner вернется
+		q.cancels[id]()
+	}
+	log.Printf("[Jobs] Сборка %s отменена", job.Folder)
+	return *job, nil
+}
+
+// Get возвращает копию задания
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if job := q.find(id); job != nil {
+		return *job, true
+	}
+	return Job{}, false
+}
+
+// List возвращает копию истории, новые задания первыми
+func (q *Queue) List() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	list := make([]Job, 0, len(q.jobs))
+	for i := len(q.jobs) - 1; i >= 0; i-- {
+		list = append(list, *q.jobs[i])
+	}
+	return list
+}
+
+// Active возвращает незавершенные задания по папкам
+func (q *Queue) Active() map[string]Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	active := make(map[string]Job)
+	for _, job := range q.jobs {
+		if !job.Finished() {
+			active[job.Folder] = *job
+		}
+	}
+	return active
+}
+
+// Wait ждет завершения задания и возвращает его итоговое состояние
+func (q *Queue) Wait(id string) (Job, error) {
+	q.mu.Lock()
+	done, ok := q.done[id]
+	q.mu.Unlock()
+
+	if ok {
+		<-done
+	}
+	job, found := q.Get(id)
+	if !found {
+		return job, ErrNotFound
+	}
+	return job, nil
+}
+
+// dispatch запускает задания из очереди, пока есть свободные места. Вызывается под q.mu.
+func (q *Queue) dispatch() {
+	limit := max(1, q.core.GetConfig().Timelapse.MaxJobs)
+	for _, job := range q.jobs {
+		if q.running >= limit {
+			return
+		}
+		if job.Status != StatusQueued {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		q.cancels[job.ID] = cancel
+		q.running++
+		job.Status = StatusRunning
+		job.StartedAt = time.Now()
+		q.publish(job)
+		q.save()
+		go q.execute(ctx, *job)
+	}
+}
+
+func (q *Queue) execute(ctx context.Context, job Job) {
+	// Прогресс рассылается только при изменении на целый процент, чтобы не засыпать SSE
+	report := func(stage string, progress float64) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		current := q.find(job.ID)
+		if current == nil || current.Finished() {
+			return
+		}
+		progress = min(max(progress, 0), 100)
+		if current.Stage == stage && int(current.Progress) == int(progress) {
+			return
+		}
+		current.Stage = stage
+		current.Progress = progress
+		q.publish(current)
+	}
+
+	err := q.run(ctx, job, report)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	canceled := ctx.Err() != nil
+	q.cancels[job.ID]()
+	delete(q.cancels, job.ID)
+	q.running--
+
+	current := q.find(job.ID)
+	switch {
+	case canceled:
+		q.finish(current, StatusCanceled, "")
+	case err != nil:
+		log.Printf("[Jobs] Ошибка сборки %s: %v", job.Folder, err)
+		q.finish(current, StatusFailed, err.Error())
+	default:
+		current.Progress = 100
+		q.finish(current, StatusDone, "")
+	}
+	q.dispatch()
+}
+
+// finish фиксирует итог задания и будит ожидающих. Вызывается под q.mu.
+func (q *Queue) finish(job *Job, status Status, errText string) {
+	job.Status = status
+	job.Error = errText
+	job.FinishedAt = time.Now()
+	if done, ok := q.done[job.ID]; ok {
+		close(done)
+		delete(q.done, job.ID)
+	}
+	q.trim()
+	q.publish(job)
+	q.save()
+}
+
+// trim удаляет самые старые завершенные задания сверх maxHistory
+func (q *Queue) trim() {
+	extra := len(q.jobs) - maxHistory
+	q.jobs = slices.DeleteFunc(q.jobs, func(job *Job) bool {
+		if extra > 0 && job.Finished() {
+			extra--
+			return true
+		}
+		return false
+	})
+}
+
+func (q *Queue) find(id string) *Job {
+	for _, job := range q.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+func (q *Queue) publish(job *Job) {
+	q.core.Events().Publish(events.TypeJob, *job)
+}
+
+func (q *Queue) save() {
+	data, err := json.MarshalIndent(q.jobs, "", " ")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(config.Path("jobs.json"), data, 0644); err != nil {
+		log.Println("[Jobs] Ошибка сохранения истории заданий:", err)
+	}
+}
+
+func newID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,218 @@
+package jobs
+
+import (
+	"bambucam/config"
+	"bambucam/printer/events"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	bus *events.Bus
+	cfg *config.Config
+}
+
+func (f fakeSource) Events() *events.Bus       { return f.bus }
+func (f fakeSource) GetConfig() *config.Config { return f.cfg }
+
+// testRunner запускает задания, которые висят, пока тест не ответит в release[folder]
+type testRunner struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+	started chan string
+	release map[string]chan error
+}
+
+func (r *testRunner) run(ctx context.Context, job Job, report func(string, float64)) error {
+	r.mu.Lock()
+	r.running++
+	r.peak = max(r.peak, r.running)
+	release := r.release[job.Folder]
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.running--
+		r.mu.Unlock()
+	}()
+
+	report("video", 50)
+	r.started <- job.Folder
+	select {
+	case err := <-release:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func newTestQueue(t *testing.T, maxJobs int, folders ...string) (*Queue, *testRunner) {
+	t.Helper()
+	if err := config.SetPaths(t.TempDir(), ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { config.SetPaths("", "") })
+
+	cfg := config.DefaultConfig()
+	cfg.Timelapse.MaxJobs = maxJobs
+	r := &testRunner{started: make(chan string, len(folders)), release: map[string]chan error{}}
+	for _, folder := range folders {
+		r.release[folder] = make(chan error, 1)
+	}
+	return NewQueue(fakeSource{bus: events.NewBus(), cfg: cfg}, r.run), r
+}
+
+func (r *testRunner) waitStarted(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-r.started:
+		if got != want {
+			t.Fatalf("started %s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not start", want)
+	}
+}
+
+func (r *testRunner) assertIdle(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-r.started:
+		t.Fatalf("%s started over the limit", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestQueueMaxJobs(t *testing.T) {
+	tests := []struct {
+		maxJobs int
+		peak    int
+	}{
+		{maxJobs: 0, peak: 1}, // 0 считается как 1
+		{maxJobs: 1, peak: 1},
+		{maxJobs: 2, peak: 2},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("max_jobs=%d", tt.maxJobs), func(t *testing.T) {
+			folders := []string{"a", "b", "c"}
+			q, r := newTestQueue(t, tt.maxJobs, folders...)
+			var ids []string
+			for _, folder := range folders {
+				job, err := q.Enqueue(Job{Folder: folder, Source: SourceWeb})
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, job.ID)
+			}
+			// Одновременно запущенные стартуют в любом порядке
+			started := map[string]bool{}
+			for range tt.peak {
+				select {
+				case folder := <-r.started:
+					started[folder] = true
+				case <-time.After(5 * time.Second):
+					t.Fatal("jobs did not start")
+				}
+			}
+			for _, folder := range folders[:tt.peak] {
+				if !started[folder] {
+					t.Fatalf("started %v, want %v", started, folders[:tt.peak])
+				}
+			}
+			r.assertIdle(t)
+
+			// Освободившееся место занимает следующее задание по порядку
+			for i, folder := range folders {
+				r.release[folder] <- nil
+				job, err := q.Wait(ids[i])
+				if err != nil || job.Status != StatusDone || job.Progress != 100 {
+					t.Fatalf("Wait(%s) = %+v, %v", folder, job, err)
+				}
+				if next := i + tt.peak; next < len(folders) {
+					r.waitStarted(t, folders[next])
+				}
+			}
+			if r.peak != tt.peak {
+				t.Errorf("peak concurrency = %d, want %d", r.peak, tt.peak)
+			}
+		})
+	}
+}
+
+func TestQueueEnqueueDuplicate(t *testing.T) {
+	q, r := newTestQueue(t, 1, "a")
+	first, _ := q.Enqueue(Job{Folder: "a", Source: SourceAuto})
+	r.waitStarted(t, "a")
+
+	dup, err := q.Enqueue(Job{Folder: "a", Source: SourceWeb})
+	if !errors.Is(err, ErrDuplicate) || dup.ID != first.ID {
+		t.Fatalf("duplicate Enqueue = %+v, %v", dup, err)
+	}
+	if active := q.Active(); len(active) != 1 || active["a"].Status != StatusRunning {
+		t.Errorf("Active() = %+v", active)
+	}
+
+	// После завершения ту же папку можно собрать снова
+	r.release["a"] <- nil
+	q.Wait(first.ID)
+	again, err := q.Enqueue(Job{Folder: "a"})
+	if err != nil || again.ID == first.ID {
+		t.Fatalf("Enqueue after finish = %+v, %v", again, err)
+	}
+	r.release["a"] <- nil
+	q.Wait(again.ID)
+}
+
+func TestQueueCancel(t *testing.T) {
+	q, r := newTestQueue(t, 1, "running", "queued")
+	running, _ := q.Enqueue(Job{Folder: "running"})
+	queued, _ := q.Enqueue(Job{Folder: "queued"})
+	r.waitStarted(t, "running")
+
+	tests := []struct {
+		name string
+		id   string
+		err  error
+	}{
+		{"queued", queued.ID, nil},
+		{"running", running.ID, nil},
+		{"finished", queued.ID, ErrFinished},
+		{"unknown", "missing", ErrNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := q.Cancel(tt.id); !errors.Is(err, tt.err) {
+			t.Fatalf("Cancel(%s) = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	for _, id := range []string{queued.ID, running.ID} {
+		job, err := q.Wait(id)
+		if err != nil || job.Status != StatusCanceled {
+			t.Errorf("Wait(%s) = %+v, %v", job.Folder, job, err)
+		}
+	}
+	// Снятое из очереди задание не запускалось
+	r.assertIdle(t)
+	if _, err := q.Wait("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Wait(missing) = %v", err)
+	}
+}
+
+func TestQueueFailed(t *testing.T) {
+	q, r := newTestQueue(t, 1, "a")
+	job, _ := q.Enqueue(Job{Folder: "a"})
+	r.waitStarted(t, "a")
+	r.release["a"] <- errors.New("ffmpeg crashed")
+
+	job, _ = q.Wait(job.ID)
+	if job.Status != StatusFailed || job.Error != "ffmpeg crashed" || job.FinishedAt.IsZero() {
+		t.Errorf("failed job = %+v", job)
+	}
+	if list := q.List(); len(list) != 1 || list[0].ID != job.ID {
+		t.Errorf("List() = %+v", list)
+	}
+}
@@ -1,6 +1,7 @@
 package mqtt
 
 import (
+	"bambucam/metrics"
 	"bambucam/printer"
 	"crypto/tls"
 	"encoding/json"
@@ -52,6 +53,7 @@ func (m *BambuManager) Start() {
 		m.RequestAllStatus()
 	}
 	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		metrics.MQTTDisconnects.Inc()
 		log.Printf("[MQTT] Связь потеряна: %v. Ожидание восстановления...", err)
 	})
 	opts.SetReconnectingHandler(func(c mqtt.Client, options *mqtt.ClientOptions) {
@@ -67,7 +69,10 @@ func (m *BambuManager) Start() {
 }
 
 func (m *BambuManager) Stop() {
-	m.client.Disconnect(1000)
+	// Клиент не создается, если не указан серийный номер
+	if m.client != nil {
+		m.client.Disconnect(1000)
+	}
 }
 
 func (m *BambuManager) handleMessageStatus(client mqtt.Client, msg mqtt.Message) {
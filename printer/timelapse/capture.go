@@ -1,6 +1,9 @@
 package timelapse
 
 import (
+	"bambucam/metrics"
+	"bambucam/printer/events"
+	"bambucam/printer/jobs"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -16,16 +19,30 @@ func (t *Timelapse) checkTimelapse() {
 	}
 
 	state, _ := t.core.GetStatus()["gcode_state"].(string)
+	if t.pending != nil {
+		// Пока принтер не прислал состояние, неясно, продолжается ли прерванная печать
+		if state == "" {
+			return
+		}
+		t.resumePending(state)
+	}
 	status := t.status
 
 	switch state {
 	case "RUNNING":
-		if status == TL_IDLE {
+		switch status {
+		case TL_IDLE:
+			if !t.enoughSpace() {
+				return
+			}
+			t.startCapture()
+		case TL_PAUSED:
+			// Печать продолжена после паузы - запись идет в ту же папку
 			t.startCapture()
 		}
 		t.captureIfNeeded()
 
-	case "PAUSED":
+	case "PAUSE":
 		if status == TL_RECORDING {
 			t.pause()
 		}
@@ -51,27 +68,26 @@ func (t *Timelapse) startCapture() {
 		now := time.Now()
 		baseName := fmt.Sprintf("%s_%02d_%02d", taskName, now.Month(), now.Day())
 
-		num := 1
-		for {
-			folderName := fmt.Sprintf("%s_%d", baseName, num)
-			fullPath := filepath.Join(savePath, folderName)
+		folder := ""
+		for num := 1; ; num++ {
+			fullPath := filepath.Join(savePath, fmt.Sprintf("%s_%d", baseName, num))
 			if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-				t.currentFolder = fullPath
+				folder = fullPath
 				break
 			}
-			num++
-		}
-
-		if t.currentFolder == "" {
-			log.Println("[Timelapse] Ошибка при создании директории для таймлапса")
-			return
 		}
 
-		os.MkdirAll(t.currentFolder, 0755)
-
+		// Папку записи читают правила хранения из других горутин: она должна стать
+		// текущей раньше, чем появится на диске
+		t.mu.Lock()
+		t.currentFolder = folder
 		t.status = TL_RECORDING
+		t.lastSample = nil
 		t.currentTask = taskName
 		t.startTime = time.Now()
+		t.mu.Unlock()
+
+		os.MkdirAll(folder, 0755)
 		t.saveStatus()
 		log.Printf("[Timelapse] Новая сессия: %s", t.currentFolder)
 	} else if t.status == TL_PAUSED {
@@ -90,6 +106,18 @@ func (t *Timelapse) captureIfNeeded() {
 			currentLayer = int(val)
 		}
 		cfg := t.core.GetConfig().Timelapse
+		now := time.Now()
+
+		// Плавный режим: после смены слоя ждем самый неподвижный кадр в пределах окна
+		if t.smooth != nil {
+			// Кадры следующего слоя в окно уже не берутся
+			if currentLayer == t.smooth.layer {
+				t.smooth.add(t.core.GetFrame(), newFrameMeta(t.core.GetStatus(), now, t.startTime))
+			}
+			if t.smooth.expired(now) || currentLayer != t.smooth.layer {
+				t.flushSmooth()
+			}
+		}
 
 		shouldCapture := false
 
@@ -109,30 +137,74 @@ func (t *Timelapse) captureIfNeeded() {
 		}
 
 		if shouldCapture {
-			t.lastTime = time.Now()
+			t.lastTime = now
 			t.lastLayer = currentLayer
-			captureSuffix := fmt.Sprintf("layer_%04d_%d", currentLayer, time.Now().Unix())
+
+			if cfg.Interval == 0 && cfg.Smooth {
+				t.smooth = newSmoothCapture(currentLayer, time.Duration(cfg.SmoothWindow)*time.Second)
+				t.smooth.add(t.core.GetFrame(), newFrameMeta(t.core.GetStatus(), now, t.startTime))
+				return
+			}
 
 			frame := t.core.GetFrame()
-			if len(frame) == 0 {
+			if cfg.Interval > 0 && cfg.DedupThreshold > 0 && t.isDuplicate(frame, now, cfg.DedupThreshold, cfg.DedupMaxGap) {
+				metrics.FramesSkipped.Inc()
 				return
 			}
+			t.saveFrame(currentLayer, frame, newFrameMeta(t.core.GetStatus(), now, t.startTime))
+		}
+	}
+}
 
-			fileName := fmt.Sprintf("%s.jpg", captureSuffix)
-			filePath := filepath.Join(t.currentFolder, fileName)
+// isDuplicate сравнивает кадр с последним сохраненным и запоминает его, если кадр будет сохранен.
+// Без изменений кадр все равно сохраняется раз в maxGap секунд, чтобы долгий простой не выпал из видео целиком.
+func (t *Timelapse) isDuplicate(frame []byte, now time.Time, threshold, maxGap int) bool {
+	sample, err := motionSample(frame)
+	if err != nil {
+		return false
+	}
+	overdue := maxGap > 0 && now.Sub(t.lastSaved) >= time.Duration(maxGap)*time.Second
+	if t.lastSample != nil && !overdue && changedPercent(t.lastSample, sample) < float64(threshold) {
+		return true
+	}
+	t.lastSample, t.lastSaved = sample, now
+	return false
+}
 
-			buf, err := AddTimestampWithRoundedBox(frame, time.Now())
-			if err == nil {
-				frame = buf
-			}
+// flushSmooth сохраняет лучший кадр окна плавного режима и закрывает окно
+func (t *Timelapse) flushSmooth() {
+	s := t.smooth
+	t.smooth = nil
+	if s == nil || s.best == nil {
+		return
+	}
+	t.saveFrame(s.layer, s.best, s.bestMeta)
+}
 
-			os.WriteFile(filePath, frame, 0644)
-		}
+// saveFrame сохраняет кадр слоя. Кадр пишется чистым, наложение рисуется при сборке по frames.jsonl
+func (t *Timelapse) saveFrame(layer int, frame []byte, meta FrameMeta) {
+	if len(frame) == 0 {
+		return
+	}
+
+	fileName := fmt.Sprintf("layer_%04d_%d.jpg", layer, meta.Time.Unix())
+	filePath := filepath.Join(t.currentFolder, fileName)
+
+	if err := os.WriteFile(filePath, frame, 0644); err != nil {
+		return
+	}
+	metrics.FramesCaptured.Inc()
+
+	if err := appendFrameRecord(t.currentFolder, FrameRecord{File: fileName, FrameMeta: meta}); err != nil {
+		log.Printf("[Timelapse] Ошибка записи %s: %v", FramesFile, err)
 	}
 }
 
 func (t *Timelapse) pause() {
 	if t.status == TL_RECORDING {
+		t.mu.Lock()
+		t.flushSmooth()
+		t.mu.Unlock()
 		t.status = TL_PAUSED
 		t.saveStatus()
 		log.Println("[Timelapse] Пауза записи")
@@ -140,39 +212,96 @@ func (t *Timelapse) pause() {
 }
 
 func (t *Timelapse) finalize() {
-	t.status = TL_CONVERT
-	t.saveStatus()
-	log.Printf("[Timelapse] Печать завершена, папка %s", t.currentFolder)
-	go func(folderName string) {
-		log.Println("[Timelapse] Авто-сборка видео после печати...")
-		err := t.AssembleVideo(folderName)
-		if err != nil {
-			t.status = TL_ERROR
-			t.saveStatus()
-			log.Println("[Timelapse] Ошибка сборки видео:", err)
-		} else {
-			err := t.AssemblePreview(folderName)
+	// Кадр последнего слоя не должен потеряться из-за недождавшегося окна
+	t.mu.Lock()
+	t.flushSmooth()
+	folder, info := t.currentFolder, t.info()
+	t.mu.Unlock()
+
+	log.Printf("[Timelapse] Печать завершена, папка %s", folder)
+	log.Println("[Timelapse] Авто-сборка видео после печати...")
+	id := t.queueAssembly(folder, info)
+
+	// Сборка может долго ждать своей очереди, а следующая печать должна записываться сразу
+	t.mu.Lock()
+	t.status = TL_IDLE
+	t.currentTask = ""
+	t.currentFolder = ""
+	t.mu.Unlock()
+	go t.finishAssembly(folder, info, id)
+}
+
+// assembleSession собирает видео и превью сессии через очередь и записывает итоговый статус в info.json
+func (t *Timelapse) assembleSession(folder string, info TimelapsInfo) {
+	t.finishAssembly(folder, info, t.queueAssembly(folder, info))
+}
+
+// queueAssembly помечает сессию как собираемую и ставит ее в очередь сборки.
+// Если папку уже собирают по запросу из веба (ErrDuplicate), возвращается то задание.
+func (t *Timelapse) queueAssembly(folder string, info TimelapsInfo) string {
+	info.Status = TL_CONVERT
+	t.writeInfo(folder, info)
+
+	job, _ := t.core.Jobs().Enqueue(jobs.Job{Folder: filepath.Base(folder), Source: jobs.SourceAuto})
+	return job.ID
+}
+
+// finishAssembly дожидается задания сборки, удаляет исходные кадры, ставит выгрузку и
+// применяет правила хранения. Состояние записи не трогает: к этому времени может идти
+// уже следующая печать.
+func (t *Timelapse) finishAssembly(folder string, info TimelapsInfo, id string) {
+	// Пока итог не записан, сессия остается в статусе сборки уже без задания в очереди
+	t.finishing.Store(filepath.Base(folder), true)
+	job, _ := t.core.Jobs().Wait(id)
+	job.Folder = filepath.Base(folder)
+
+	if job.Status == jobs.StatusDone {
+		info.Status = TL_FINISHED
+		if cfg := t.core.GetConfig().Timelapse; cfg.DeleteFrames {
+			deleted, err := pruneFrames(folder, cfg.KeepEveryFrame)
 			if err != nil {
-				t.status = TL_ERROR
-				t.saveStatus()
-				log.Println("[Timelapse] Ошибка сборки видео:", err)
-			} else {
-				t.status = TL_FINISHED
-				t.saveStatus()
+				log.Printf("[Timelapse] Ошибка удаления кадров %s: %v", job.Folder, err)
+			} else if deleted > 0 {
+				log.Printf("[Timelapse] Удалено исходных кадров %s: %d", job.Folder, deleted)
 			}
+			info.FramesDeleted = err == nil && cfg.KeepEveryFrame == 0
 		}
-		t.status = TL_IDLE
-		t.currentTask = ""
-		t.currentFolder = ""
-	}(filepath.Base(t.currentFolder))
+	} else {
+		info.Status = TL_ERROR
+		log.Printf("[Timelapse] Сборка видео %s не удалась: %s %s", job.Folder, job.Status, job.Error)
+	}
+	t.writeInfo(folder, info)
+
+	// Выгрузка ставится до правил хранения, чтобы они не удалили сессию, которую еще не скопировали
+	if cfg := t.core.GetConfig(); info.Status == TL_FINISHED && cfg.Upload.Auto && len(cfg.UploadTargets()) > 0 {
+		if _, err := t.core.Uploads().Enqueue(job.Folder); err != nil {
+			log.Printf("[Timelapse] Не удалось поставить %s в очередь выгрузки: %v", job.Folder, err)
+		}
+	}
+	t.finishing.Delete(job.Folder)
+	t.applyRetention()
 }
 
-func (t *Timelapse) saveStatus() {
-	info := TimelapsInfo{
+func (t *Timelapse) info() TimelapsInfo {
+	return TimelapsInfo{
 		Name:      t.currentTask,
 		Status:    t.status,
 		StartedAt: t.startTime,
 	}
+}
+
+func (t *Timelapse) saveStatus() {
+	t.writeInfo(t.currentFolder, t.info())
+}
+
+// writeInfo сохраняет info.json сессии и сообщает подписчикам о смене статуса
+func (t *Timelapse) writeInfo(folder string, info TimelapsInfo) {
 	infoData, _ := json.MarshalIndent(info, "", " ")
-	os.WriteFile(filepath.Join(t.currentFolder, "info.json"), infoData, 0644)
+	os.WriteFile(filepath.Join(folder, "info.json"), infoData, 0644)
+
+	t.core.Events().Publish(events.TypeTimelapse, map[string]any{
+		"folder": filepath.Base(folder),
+		"name":   info.Name,
+		"status": info.Status.String(),
+	})
 }
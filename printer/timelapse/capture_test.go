@@ -0,0 +1,70 @@
+package timelapse
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsDuplicate(t *testing.T) {
+	frames := map[string][]byte{
+		"head":    headFrame(t, 100, 90),
+		"noise":   headFrame(t, 100, 75),
+		"nudged":  headFrame(t, 104, 90),
+		"moved":   headFrame(t, 200, 90),
+		"moved2":  headFrame(t, 200, 80),
+		"broken":  []byte("jpeg"),
+		"returns": headFrame(t, 100, 90),
+	}
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	type step struct {
+		frame string
+		at    int // секунда записи
+		dup   bool
+	}
+
+	tests := []struct {
+		name   string
+		maxGap int
+		steps  []step
+	}{
+		{
+			name:   "threshold and max gap",
+			maxGap: 60,
+			steps: []step{
+				{"head", 0, false},
+				// Шум сжатия и сдвиг на пару пикселей ниже порога
+				{"noise", 10, true},
+				{"nudged", 20, true},
+				{"moved", 30, false},
+				{"moved2", 40, true},
+				// Без изменений кадр все равно сохраняется раз в maxGap от последнего сохраненного
+				{"moved2", 90, false},
+				{"moved", 100, true},
+				// Кадр, который не разобрать, не считается повтором
+				{"broken", 110, false},
+				{"returns", 120, false},
+			},
+		},
+		{
+			name: "no max gap",
+			steps: []step{
+				{"head", 0, false},
+				{"noise", 3600, true},
+				{"moved", 7200, false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tl := &Timelapse{}
+			for i, step := range tt.steps {
+				now := start.Add(time.Duration(step.at) * time.Second)
+				if got := tl.isDuplicate(frames[step.frame], now, 3, tt.maxGap); got != step.dup {
+					t.Errorf("step %d (%s at %ds): duplicate %v, want %v", i, step.frame, step.at, got, step.dup)
+				}
+			}
+		})
+	}
+}
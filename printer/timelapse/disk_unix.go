@@ -0,0 +1,14 @@
+//go:build !windows
+
+package timelapse
+
+import "syscall"
+
+// freeSpace - свободное место на диске с папкой path, доступное обычному пользователю
+func freeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
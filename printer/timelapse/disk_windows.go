@@ -0,0 +1,16 @@
+package timelapse
+
+import "golang.org/x/sys/windows"
+
+// freeSpace - свободное место на диске с папкой path, доступное текущему пользователю
+func freeSpace(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
@@ -0,0 +1,278 @@
+package timelapse
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/image/draw"
+)
+
+// Встроенный кодировщик нужен там, где ffmpeg не установлен (минимальные контейнеры,
+// роутеры). Кадры уже в JPEG, поэтому видео - это MJPEG в контейнере AVI без
+// перекодирования, а превью - анимированный GIF из каждого N-го кадра.
+
+// ffmpegAvailable проверяет, есть ли ffmpeg в PATH
+func ffmpegAvailable() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
+// Превью по умолчанию: ширина и шаг по кадрам; число кадров GIF ограничено, чтобы файл оставался небольшим
+const (
+	gifWidth     = 320
+	gifMaxFrames = 300
+	gifStep      = 5
+)
+
+type aviFrame struct {
+	path string
+	size int64
+}
+
+// listFrames возвращает кадры сессии по порядку
+func listFrames(fullPath string) ([]string, error) {
+	frames, err := filepath.Glob(filepath.Join(fullPath, "layer_*.jpg"))
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("в папке нет кадров")
+	}
+	return frames, nil
+}
+
+// encodeAVI собирает MJPEG AVI из JPEG кадров. Кадры не перекодируются; битые и кадры
+// другого размера (камеру перенастроили посреди печати) пропускаются.
+func encodeAVI(ctx context.Context, fullPath, outputFile string, fps int, progress func(float64)) error {
+	paths, err := listFrames(fullPath)
+	if err != nil {
+		return err
+	}
+
+	var frames []aviFrame
+	var width, height int
+	var maxSize, total int64
+	for _, path := range paths {
+		cfg, size, err := jpegInfo(path)
+		if err != nil {
+			continue
+		}
+		if width == 0 {
+			width, height = cfg.Width, cfg.Height
+		}
+		if cfg.Width != width || cfg.Height != height {
+			continue
+		}
+		frames = append(frames, aviFrame{path: path, size: size})
+		maxSize = max(maxSize, size)
+		total += 8 + size + size%2
+	}
+	if len(frames) == 0 {
+		return errors.New("нет читаемых кадров")
+	}
+
+	n := int64(len(frames))
+	moviSize := 4 + total
+	riffSize := 4 + 200 + 8 + moviSize + 8 + 16*n
+	if riffSize > math.MaxUint32 {
+		return errors.New("слишком много кадров для AVI")
+	}
+
+	tmpFile := outputFile + ".tmp"
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile)
+
+	w := bufio.NewWriter(f)
+	le := func(values ...any) {
+		for _, v := range values {
+			binary.Write(w, binary.LittleEndian, v)
+		}
+	}
+
+	// Заголовок: RIFF 'AVI ' / LIST hdrl (avih, LIST strl (strh, strf))
+	w.WriteString("RIFF")
+	le(uint32(riffSize))
+	w.WriteString("AVI LIST")
+	le(uint32(192))
+	w.WriteString("hdrlavih")
+	le(uint32(56),
+		uint32(1000000/fps),        // микросекунд на кадр
+		uint32(maxSize*int64(fps)), // максимальный поток байт в секунду
+		uint32(0),                  // выравнивание
+		uint32(0x10),               // AVIF_HASINDEX
+		uint32(n),                  // кадров всего
+		uint32(0),                  // начальных кадров
+		uint32(1),                  // потоков
+		uint32(maxSize),            // рекомендуемый буфер
+		uint32(width), uint32(height),
+		[4]uint32{},
+	)
+	w.WriteString("LIST")
+	le(uint32(116))
+	w.WriteString("strlstrh")
+	le(uint32(56))
+	w.WriteString("vidsMJPG")
+	le(uint32(0), uint16(0), uint16(0), uint32(0),
+		uint32(1), uint32(fps), // scale и rate: fps = rate/scale
+		uint32(0), uint32(n), uint32(maxSize),
+		uint32(0xFFFFFFFF), // качество по умолчанию
+		uint32(0),
+		[4]uint16{0, 0, uint16(width), uint16(height)},
+	)
+	w.WriteString("strf")
+	le(uint32(40),
+		uint32(40), int32(width), int32(height),
+		uint16(1), uint16(24),
+	)
+	w.WriteString("MJPG")
+	le(uint32(width*height*3), int32(0), int32(0), uint32(0), uint32(0))
+
+	// Кадры
+	w.WriteString("LIST")
+	le(uint32(moviSize))
+	w.WriteString("movi")
+	for i, frame := range frames {
+		if err := ctx.Err(); err != nil {
+			f.Close()
+			return err
+		}
+		progress(float64(i) * 100 / float64(n))
+		w.WriteString("00dc")
+		le(uint32(frame.size))
+		if err := copyFile(w, frame.path, frame.size); err != nil {
+			f.Close()
+			return err
+		}
+		if frame.size%2 == 1 {
+			w.WriteByte(0)
+		}
+	}
+
+	// Индекс: смещения считаются от начала слова 'movi'
+	w.WriteString("idx1")
+	le(uint32(16 * n))
+	offset := int64(4)
+	for _, frame := range frames {
+		w.WriteString("00dc")
+		le(uint32(0x10), uint32(offset), uint32(frame.size)) // AVIIF_KEYFRAME
+		offset += 8 + frame.size + frame.size%2
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, outputFile)
+}
+
+// encodeGIF собирает анимированное превью заданной ширины из каждого speed-го кадра
+func encodeGIF(ctx context.Context, fullPath, outputFile string, fps, speed, width int, progress func(float64)) error {
+	paths, err := listFrames(fullPath)
+	if err != nil {
+		return err
+	}
+
+	step := max(speed, (len(paths)+gifMaxFrames-1)/gifMaxFrames)
+	// Как и у превью ffmpeg, ускоряем видео пропорционально шагу
+	delay := max(2, 100/fps)
+
+	anim := &gif.GIF{}
+	for i := 0; i < len(paths); i += step {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		progress(float64(i) * 100 / float64(len(paths)))
+		img, err := readJPEG(paths[i])
+		if err != nil {
+			continue
+		}
+		bounds := img.Bounds()
+		height := bounds.Dy() * width / bounds.Dx()
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+
+		frame := image.NewPaletted(scaled.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(frame, frame.Bounds(), scaled, image.Point{})
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, delay)
+	}
+	if len(anim.Image) == 0 {
+		return errors.New("нет читаемых кадров")
+	}
+
+	tmpFile := outputFile + ".tmp"
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile)
+
+	if err := gif.EncodeAll(f, anim); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, outputFile)
+}
+
+func jpegInfo(path string) (image.Config, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, 0, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return image.Config{}, 0, err
+	}
+	cfg, err := jpeg.DecodeConfig(f)
+	return cfg, st.Size(), err
+}
+
+func readJPEG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return jpeg.Decode(f)
+}
+
+// copyFile дописывает файл целиком; размер должен совпасть с тем, что уже записан в заголовок
+func copyFile(w io.Writer, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	written, err := io.Copy(w, io.LimitReader(f, size))
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("кадр %s изменился во время сборки", filepath.Base(path))
+	}
+	return nil
+}
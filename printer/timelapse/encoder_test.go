@@ -0,0 +1,155 @@
+package timelapse
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeJPEG сохраняет кадр заданного размера; extra дописывается после JPEG, чтобы получить нечетный размер
+func writeJPEG(t *testing.T, path string, width, height, extra int) int64 {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(make([]byte, extra))
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return int64(buf.Len())
+}
+
+type riffChunk struct {
+	id   string
+	data []byte
+	// offset - начало заголовка чанка в родительских данных
+	offset int
+}
+
+// readChunks разбирает последовательность чанков RIFF, проверяя размеры и выравнивание
+func readChunks(t *testing.T, data []byte) []riffChunk {
+	t.Helper()
+	var chunks []riffChunk
+	for pos := 0; pos < len(data); {
+		if pos+8 > len(data) {
+			t.Fatalf("truncated chunk header at %d", pos)
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size
+		if end > len(data) {
+			t.Fatalf("chunk %q at %d: size %d overruns parent (%d bytes)", data[pos:pos+4], pos, size, len(data)-pos-8)
+		}
+		chunks = append(chunks, riffChunk{id: string(data[pos : pos+4]), data: data[pos+8 : end], offset: pos})
+		pos = end + size%2
+	}
+	return chunks
+}
+
+func chunkIDs(chunks []riffChunk) string {
+	var ids []string
+	for _, c := range chunks {
+		id := c.id
+		if id == "LIST" {
+			id += " " + string(c.data[:4])
+		}
+		ids = append(ids, id)
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestEncodeAVI(t *testing.T) {
+	dir := t.TempDir()
+	var sizes []int64
+	for i, extra := range []int{0, 1, 2} {
+		sizes = append(sizes, writeJPEG(t, filepath.Join(dir, fmt.Sprintf("layer_%04d_1.jpg", i+1)), 32, 16, extra))
+	}
+	// Кадр другого размера и битый файл пропускаются
+	writeJPEG(t, filepath.Join(dir, "layer_0004_1.jpg"), 64, 32, 0)
+	os.WriteFile(filepath.Join(dir, "layer_0005_1.jpg"), []byte("not a jpeg"), 0644)
+
+	var last float64
+	output := filepath.Join(dir, VideoAVI)
+	if err := encodeAVI(context.Background(), dir, output, 20, func(p float64) { last = p }); err != nil {
+		t.Fatal(err)
+	}
+	if last <= 0 || last > 100 {
+		t.Errorf("last progress = %v", last)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RIFF покрывает весь файл
+	root := readChunks(t, data)
+	if len(root) != 1 || root[0].id != "RIFF" || string(root[0].data[:4]) != "AVI " || len(root[0].data)+8 != len(data) {
+		t.Fatalf("bad RIFF header: %s, file %d bytes", chunkIDs(root), len(data))
+	}
+	top := readChunks(t, root[0].data[4:])
+	if got := chunkIDs(top); got != "[LIST hdrl LIST movi idx1]" {
+		t.Fatalf("top-level chunks = %s", got)
+	}
+
+	// Заголовок: число кадров и размер из avih
+	hdrl := readChunks(t, top[0].data[4:])
+	if got := chunkIDs(hdrl); got != "[avih LIST strl]" {
+		t.Fatalf("hdrl chunks = %s", got)
+	}
+	avih := hdrl[0].data
+	if len(avih) != 56 {
+		t.Fatalf("avih size = %d", len(avih))
+	}
+	if frames, w, h := binary.LittleEndian.Uint32(avih[16:]), binary.LittleEndian.Uint32(avih[32:]), binary.LittleEndian.Uint32(avih[36:]); frames != 3 || w != 32 || h != 16 {
+		t.Errorf("avih frames %d, size %dx%d", frames, w, h)
+	}
+	if got := chunkIDs(readChunks(t, hdrl[1].data[4:])); got != "[strh strf]" {
+		t.Errorf("strl chunks = %s", got)
+	}
+
+	// movi: кадры как есть, нечетные дополнены до четной длины
+	movi := top[1]
+	frames := readChunks(t, movi.data[4:])
+	if len(frames) != 3 {
+		t.Fatalf("movi has %d frames", len(frames))
+	}
+	for i, frame := range frames {
+		if frame.id != "00dc" || int64(len(frame.data)) != sizes[i] {
+			t.Errorf("frame %d: %s, %d bytes, want %d", i, frame.id, len(frame.data), sizes[i])
+		}
+	}
+
+	// idx1: смещения от слова 'movi' указывают на заголовки кадров
+	idx := top[2].data
+	if len(idx) != 16*3 {
+		t.Fatalf("idx1 size = %d", len(idx))
+	}
+	for i := range 3 {
+		entry := idx[i*16:]
+		offset, size := binary.LittleEndian.Uint32(entry[8:]), binary.LittleEndian.Uint32(entry[12:])
+		if string(entry[:4]) != "00dc" || int(offset) != 4+frames[i].offset || int64(size) != sizes[i] {
+			t.Errorf("idx1[%d] = %s offset %d size %d, want offset %d size %d", i, entry[:4], offset, size, 4+frames[i].offset, sizes[i])
+		}
+	}
+}
+
+func TestEncodeAVICanceled(t *testing.T) {
+	dir := t.TempDir()
+	writeJPEG(t, filepath.Join(dir, "layer_0001_1.jpg"), 8, 8, 0)
+	output := filepath.Join(dir, VideoAVI)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := encodeAVI(ctx, dir, output, 20, func(float64) {}); err == nil {
+		t.Fatal("encodeAVI with canceled context succeeded")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output exists after cancel: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package timelapse
+
+import (
+	"bambucam/config"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FramesFile - метаданные кадров сессии, по строке JSON на снимок. Кадры с ним хранятся
+// чистыми, а наложение рисуется при сборке; в старых сессиях его нет и наложение уже в кадрах.
+const FramesFile = "frames.jsonl"
+
+// FrameRecord - строка frames.jsonl: имя кадра и состояние принтера в момент снимка
+type FrameRecord struct {
+	File string `json:"file"`
+	FrameMeta
+}
+
+// appendFrameRecord дописывает запись о кадре в frames.jsonl сессии
+func appendFrameRecord(fullPath string, rec FrameRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(fullPath, FramesFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// HasFrameRecords сообщает, что кадры сессии чистые и к ним есть метаданные
+func HasFrameRecords(fullPath string) bool {
+	_, err := os.Stat(filepath.Join(fullPath, FramesFile))
+	return err == nil
+}
+
+// ReadFrameRecords читает метаданные кадров по порядку. Битые строки (запись оборвалась
+// при выключении) пропускаются.
+func ReadFrameRecords(fullPath string) ([]FrameRecord, error) {
+	f, err := os.Open(filepath.Join(fullPath, FramesFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []FrameRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec FrameRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.File == "" {
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// overlay выбирает шаблон наложения для сборки: пустое имя - по настройкам (если наложение
+// включено), config.NoOverlay - без наложения
+func (t *Timelapse) overlay(name string) (config.OverlayTemplate, bool, error) {
+	cfg := t.core.GetConfig()
+	switch name {
+	case config.NoOverlay:
+		return config.OverlayTemplate{}, false, nil
+	case "":
+		if !cfg.Timelapse.AddTime {
+			return config.OverlayTemplate{}, false, nil
+		}
+		tpl, ok := cfg.OverlayTemplate("")
+		if !ok {
+			tpl, _ = cfg.OverlayTemplate(config.DefaultOverlay)
+		}
+		return tpl, true, nil
+	}
+
+	tpl, ok := cfg.OverlayTemplate(name)
+	if !ok {
+		return tpl, false, fmt.Errorf("шаблон наложения %q не найден", name)
+	}
+	return tpl, true, nil
+}
+
+// stages выбирает отбор кадров для сборки: пустое значение - по настройкам
+func (t *Timelapse) stages(value string) (string, error) {
+	switch value {
+	case "":
+		return t.core.GetConfig().Timelapse.Stages, nil
+	case config.StagesAll, config.StagesPrinting:
+		return value, nil
+	}
+	return "", fmt.Errorf("неизвестный отбор кадров %q", value)
+}
+
+// prepareFrames готовит кадры к сборке во временной папке внутри сессии: отбрасывает кадры
+// не со стадии печати (printingOnly) и рисует наложение (tpl, nil - без наложения).
+// Кадры без записи в frames.jsonl берутся как есть. Папку удаляет вызывающий.
+func prepareFrames(ctx context.Context, fullPath string, tpl *config.OverlayTemplate, printingOnly bool, progress func(float64)) (string, error) {
+	paths, err := listFrames(fullPath)
+	if err != nil {
+		return "", err
+	}
+	records, err := ReadFrameRecords(fullPath)
+	if err != nil {
+		return "", err
+	}
+	// Ошибки шаблона (шрифт, цвета) лучше показать сразу, а не получить видео без наложения
+	if tpl != nil {
+		if err := checkOverlay(*tpl); err != nil {
+			return "", err
+		}
+	}
+	meta := make(map[string]FrameMeta, len(records))
+	for _, rec := range records {
+		meta[rec.File] = rec.FrameMeta
+	}
+
+	// Остатки прошлой сборки, прерванной выключением; одну папку собирает только одно задание
+	if stale, _ := filepath.Glob(filepath.Join(fullPath, ".render-*")); len(stale) > 0 {
+		for _, old := range stale {
+			os.RemoveAll(old)
+		}
+	}
+
+	dir, err := os.MkdirTemp(fullPath, ".render-")
+	if err != nil {
+		return "", err
+	}
+
+	kept := 0
+	for i, path := range paths {
+		if err := ctx.Err(); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+		progress(float64(i) * 100 / float64(len(paths)))
+
+		name := filepath.Base(path)
+		m, ok := meta[name]
+		if ok && printingOnly && !m.Printing() {
+			continue
+		}
+		target := filepath.Join(dir, name)
+
+		if !ok || tpl == nil {
+			// Кадр без изменений: жесткая ссылка вместо копии, если ФС позволяет
+			if err := os.Link(path, target); err == nil {
+				kept++
+				continue
+			}
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if ok && tpl != nil {
+			rendered, err := RenderOverlay(data, *tpl, m)
+			if err != nil {
+				// Битый кадр пропускается, как и при сборке без наложения
+				continue
+			}
+			data = rendered
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+		kept++
+	}
+
+	if kept == 0 {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("после отбора кадров не осталось ни одного")
+	}
+	return dir, nil
+}
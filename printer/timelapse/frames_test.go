@@ -0,0 +1,260 @@
+package timelapse
+
+import (
+	"bambucam/config"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFrameRecords(t *testing.T) {
+	dir := t.TempDir()
+	if HasFrameRecords(dir) {
+		t.Fatal("empty folder has frame records")
+	}
+
+	now := time.Date(2026, 5, 1, 14, 0, 0, 0, time.UTC)
+	want := []FrameRecord{
+		{File: "layer_0001_1.jpg", FrameMeta: FrameMeta{Time: now, Layer: 1, TotalLayers: 10, State: "RUNNING"}},
+		{File: "layer_0002_2.jpg", FrameMeta: FrameMeta{Time: now.Add(time.Minute), Elapsed: 60, Layer: 2, Filament: "PLA"}},
+	}
+	for _, rec := range want {
+		if err := appendFrameRecord(dir, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Запись оборвалась при выключении, а строка без имени кадра бесполезна
+	f, _ := os.OpenFile(filepath.Join(dir, FramesFile), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"file":"","layer":3}` + "\n" + `{"file":"layer_0003_3.jpg","lay`)
+	f.Close()
+
+	if !HasFrameRecords(dir) {
+		t.Fatal("HasFrameRecords = false")
+	}
+	got, err := ReadFrameRecords(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("read %d records, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].File != want[i].File || !got[i].Time.Equal(want[i].Time) || got[i].Layer != want[i].Layer ||
+			got[i].Elapsed != want[i].Elapsed || got[i].Filament != want[i].Filament || got[i].State != want[i].State {
+			t.Errorf("record %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNewFrameMeta(t *testing.T) {
+	started := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	now := started.Add(90*time.Minute + 5*time.Second)
+	status := map[string]any{
+		"layer_num":       float64(12),
+		"total_layer_num": float64(240),
+		"mc_percent":      float64(5),
+		"nozzle_temper":   219.5,
+		"bed_temper":      float64(55),
+		"subtask_name":    "cube",
+		"gcode_state":     "RUNNING",
+		"stg_cur":         float64(2),
+		"ams":             map[string]any{"tray_now": "254"},
+		"vt_tray":         map[string]any{"tray_type": "PETG"},
+	}
+	want := FrameMeta{Time: now, Elapsed: 5405, Layer: 12, TotalLayers: 240, Percent: 5, Nozzle: 219.5, Bed: 55,
+		Task: "cube", Filament: "PETG", State: "RUNNING", Stage: 2}
+	if got := newFrameMeta(status, now, started); got != want {
+		t.Errorf("newFrameMeta = %+v, want %+v", got, want)
+	}
+	// Поля, которых нет в отчете принтера, остаются нулевыми
+	if got := newFrameMeta(map[string]any{"layer_num": "12"}, now, now); got != (FrameMeta{Time: now}) {
+		t.Errorf("newFrameMeta(empty) = %+v", got)
+	}
+}
+
+// writeFrames сохраняет в папку сессии настоящие JPEG кадры и записи frames.jsonl к тем,
+// у кого указаны метаданные
+func writeFrames(t *testing.T, dir string, frame []byte, metas ...*FrameMeta) []string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i, meta := range metas {
+		name := fmt.Sprintf("layer_%04d_%d.jpg", i+1, 1000+i)
+		if err := os.WriteFile(filepath.Join(dir, name), frame, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if meta != nil {
+			if err := appendFrameRecord(dir, FrameRecord{File: name, FrameMeta: *meta}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestPrepareFrames(t *testing.T) {
+	frame := grayJPEG(t, 160, 120)
+	tpl := config.OverlayTemplate{Fields: []string{"layer"}, Position: "top-left", Background: "#FF0000"}
+
+	tests := []struct {
+		name    string
+		tpl     *config.OverlayTemplate
+		metas   []*FrameMeta
+		changed []bool // на каком кадре нарисовано наложение
+	}{
+		{
+			name:    "overlay",
+			tpl:     &tpl,
+			metas:   []*FrameMeta{{Layer: 1}, {Layer: 2}},
+			changed: []bool{true, true},
+		},
+		{
+			// Кадр без записи в frames.jsonl мог уже получить наложение при съемке
+			name:    "frame without record",
+			tpl:     &tpl,
+			metas:   []*FrameMeta{{Layer: 1}, nil},
+			changed: []bool{true, false},
+		},
+		{
+			name:    "no overlay",
+			metas:   []*FrameMeta{{Layer: 1}, {Layer: 2}},
+			changed: []bool{false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "cube")
+			names := writeFrames(t, dir, frame, tt.metas...)
+			// Остаток сборки, прерванной выключением
+			os.MkdirAll(filepath.Join(dir, ".render-old"), 0755)
+
+			var last float64
+			out, err := prepareFrames(context.Background(), dir, tt.tpl, false, func(p float64) { last = p })
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(out)
+			if filepath.Dir(out) != dir {
+				t.Errorf("frames prepared in %s, want inside the session", out)
+			}
+			if _, err := os.Stat(filepath.Join(dir, ".render-old")); !os.IsNotExist(err) {
+				t.Error("stale render folder is not removed")
+			}
+			if last <= 0 || last >= 100 {
+				t.Errorf("last progress %v", last)
+			}
+
+			entries, _ := os.ReadDir(out)
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Name())
+			}
+			if !slices.Equal(got, names) {
+				t.Fatalf("prepared %v, want %v", got, names)
+			}
+			for i, name := range names {
+				data, _ := os.ReadFile(filepath.Join(out, name))
+				if changed := !bytes.Equal(data, frame); changed != tt.changed[i] {
+					t.Errorf("%s: overlay drawn %v, want %v", name, changed, tt.changed[i])
+				}
+			}
+			// Исходные кадры остаются чистыми
+			for _, name := range names {
+				if data, _ := os.ReadFile(filepath.Join(dir, name)); !bytes.Equal(data, frame) {
+					t.Errorf("source frame %s changed", name)
+				}
+			}
+		})
+	}
+}
+
+func TestPrepareFramesErrors(t *testing.T) {
+	frame := grayJPEG(t, 160, 120)
+	dir := filepath.Join(t.TempDir(), "cube")
+	writeFrames(t, dir, frame, &FrameMeta{Layer: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := prepareFrames(ctx, dir, nil, false, func(float64) {}); err != context.Canceled {
+		t.Errorf("canceled: %v", err)
+	}
+	bad := config.OverlayTemplate{Fields: []string{"layer"}, Color: "red"}
+	if _, err := prepareFrames(context.Background(), dir, &bad, false, func(float64) {}); err == nil {
+		t.Error("bad template accepted")
+	}
+	// Ни прерванная, ни отклоненная подготовка не оставляет временных папок
+	if stale, _ := filepath.Glob(filepath.Join(dir, ".render-*")); len(stale) > 0 {
+		t.Errorf("left %v", stale)
+	}
+}
+
+func TestFrameMetaPrinting(t *testing.T) {
+	tests := []struct {
+		state string
+		stage int
+		want  bool
+	}{
+		{"RUNNING", 0, true},
+		{"RUNNING", 255, true},
+		{"RUNNING", -1, true},
+		// Старые записи без состояния принтера
+		{"", 0, true},
+		{"RUNNING", 2, false},  // нагрев стола
+		{"RUNNING", 14, false}, // очистка сопла
+		{"PAUSE", 0, false},
+		{"PREPARE", 0, false},
+		{"FINISH", 255, false},
+	}
+	for _, tt := range tests {
+		if got := (FrameMeta{State: tt.state, Stage: tt.stage}).Printing(); got != tt.want {
+			t.Errorf("Printing(%q, %d) = %v, want %v", tt.state, tt.stage, got, tt.want)
+		}
+	}
+}
+
+func TestPrepareFramesPrintingOnly(t *testing.T) {
+	frame := grayJPEG(t, 160, 120)
+	dir := filepath.Join(t.TempDir(), "cube")
+	names := writeFrames(t, dir, frame,
+		&FrameMeta{State: "PREPARE", Stage: 1},
+		&FrameMeta{State: "RUNNING", Stage: 2},
+		&FrameMeta{State: "RUNNING", Layer: 1},
+		// Кадр без записи оставляется: неизвестно, что на нем
+		nil,
+		&FrameMeta{State: "RUNNING", Stage: 255, Layer: 2},
+		&FrameMeta{State: "FINISH"},
+	)
+
+	out, err := prepareFrames(context.Background(), dir, nil, true, func(float64) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	entries, _ := os.ReadDir(out)
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+	if want := []string{names[2], names[3], names[4]}; !slices.Equal(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+
+	// Печать без единого кадра со стадии печати собрать нельзя
+	empty := filepath.Join(t.TempDir(), "calibration")
+	writeFrames(t, empty, frame, &FrameMeta{State: "RUNNING", Stage: 1}, &FrameMeta{State: "PREPARE"})
+	if _, err := prepareFrames(context.Background(), empty, nil, true, func(float64) {}); err == nil {
+		t.Error("no frames left but no error")
+	}
+	if stale, _ := filepath.Glob(filepath.Join(empty, ".render-*")); len(stale) > 0 {
+		t.Errorf("left %v", stale)
+	}
+}
@@ -6,6 +6,8 @@ type TimelapsInfo struct {
 	Name      string
 	StartedAt time.Time
 	Status    TLStatus `json:"status"`
+	// FramesDeleted - исходные кадры удалены после сборки, осталась только обложка
+	FramesDeleted bool `json:"frames_deleted,omitempty"`
 }
 
 type TLStatus int
@@ -0,0 +1,309 @@
+package timelapse
+
+import (
+	"bambucam/config"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// FrameMeta - состояние принтера в момент снимка, из него рисуются поля наложения
+type FrameMeta struct {
+	Time time.Time `json:"time"`
+	// Elapsed - секунд с начала записи
+	Elapsed     int64   `json:"elapsed"`
+	Layer       int     `json:"layer"`
+	TotalLayers int     `json:"total_layers"`
+	Percent     float64 `json:"percent"`
+	Nozzle      float64 `json:"nozzle"`
+	Bed         float64 `json:"bed"`
+	Task        string  `json:"task"`
+	Filament    string  `json:"filament,omitempty"`
+	State       string  `json:"gcode_state"`
+	// Stage - стадия принтера (stg_cur): 0 - печать, остальное - нагрев, калибровка, смена пластика и т.п.
+	Stage int `json:"stage"`
+}
+
+// Printing сообщает, что кадр снят во время самой печати, а не нагрева, калибровки или
+// смены пластика. Часть прошивок во время печати присылает stg_cur 255 или -1 вместо 0.
+func (m FrameMeta) Printing() bool {
+	if m.State != "" && m.State != "RUNNING" {
+		return false
+	}
+	return m.Stage == 0 || m.Stage == 255 || m.Stage == -1
+}
+
+// newFrameMeta снимает нужные наложению поля из статуса принтера
+func newFrameMeta(status map[string]any, now, started time.Time) FrameMeta {
+	num := func(key string) float64 {
+		v, _ := status[key].(float64)
+		return v
+	}
+	task, _ := status["subtask_name"].(string)
+	state, _ := status["gcode_state"].(string)
+	return FrameMeta{
+		Time:        now,
+		Elapsed:     int64(now.Sub(started).Seconds()),
+		Layer:       int(num("layer_num")),
+		TotalLayers: int(num("total_layer_num")),
+		Percent:     num("mc_percent"),
+		Nozzle:      num("nozzle_temper"),
+		Bed:         num("bed_temper"),
+		Task:        task,
+		Filament:    filamentName(status),
+		State:       state,
+		Stage:       int(num("stg_cur")),
+	}
+}
+
+// filamentName - тип пластика в текущем лотке AMS или на внешней катушке
+func filamentName(status map[string]any) string {
+	ams, _ := status["ams"].(map[string]any)
+	if ams == nil {
+		return ""
+	}
+	trayNow, _ := ams["tray_now"].(string)
+	n, err := strconv.Atoi(trayNow)
+	if err != nil {
+		return ""
+	}
+
+	var tray map[string]any
+	switch {
+	case n == 254:
+		// Внешняя катушка
+		tray, _ = status["vt_tray"].(map[string]any)
+	case n < 254:
+		units, _ := ams["ams"].([]any)
+		if n/4 < len(units) {
+			unit, _ := units[n/4].(map[string]any)
+			trays, _ := unit["tray"].([]any)
+			if n%4 < len(trays) {
+				tray, _ = trays[n%4].(map[string]any)
+			}
+		}
+	}
+
+	name, _ := tray["tray_type"].(string)
+	if brand, _ := tray["tray_sub_brands"].(string); brand != "" {
+		name = brand
+	}
+	return name
+}
+
+// overlayLines - строки наложения по полям шаблона; пустые поля пропускаются
+func overlayLines(fields []string, meta FrameMeta) []string {
+	var lines []string
+	for _, field := range fields {
+		var line string
+		switch field {
+		case "time":
+			line = meta.Time.Format("15:04:05")
+		case "elapsed":
+			line = fmt.Sprintf("%d:%02d:%02d", meta.Elapsed/3600, meta.Elapsed/60%60, meta.Elapsed%60)
+		case "layer":
+			line = fmt.Sprintf("Слой %d", meta.Layer)
+			if meta.TotalLayers > 0 {
+				line += fmt.Sprintf("/%d", meta.TotalLayers)
+			}
+		case "percent":
+			line = fmt.Sprintf("%.0f%%", meta.Percent)
+		case "nozzle":
+			line = fmt.Sprintf("Сопло %.0f°C", meta.Nozzle)
+		case "bed":
+			line = fmt.Sprintf("Стол %.0f°C", meta.Bed)
+		case "task":
+			line = meta.Task
+		case "filament":
+			line = meta.Filament
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// RenderOverlay рисует поля шаблона поверх JPEG кадра
+func RenderOverlay(data []byte, tpl config.OverlayTemplate, meta FrameMeta) ([]byte, error) {
+	lines := overlayLines(tpl.Fields, meta)
+	if len(lines) == 0 {
+		return data, nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, image.Point{}, draw.Src)
+
+	if err := drawOverlay(rgba, tpl, lines); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	err = jpeg.Encode(&out, rgba, &jpeg.Options{Quality: 90})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+func drawOverlay(rgba *image.RGBA, tpl config.OverlayTemplate, lines []string) error {
+	size := tpl.FontSize
+	if size <= 0 {
+		size = 20
+	}
+	face, err := loadFont(tpl.FontFile, float64(size))
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	fg, err := parseColorOr(tpl.Color, color.RGBA{255, 255, 255, 255})
+	if err != nil {
+		return err
+	}
+	bg, err := parseColorOr(tpl.Background, color.RGBA{0, 0, 0, 150})
+	if err != nil {
+		return err
+	}
+
+	drawer := &font.Drawer{
+		Dst:  rgba,
+		Src:  image.NewUniform(fg),
+		Face: face,
+	}
+
+	textWidth := 0
+	for _, line := range lines {
+		textWidth = max(textWidth, drawer.MeasureString(line).Round())
+	}
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Round()
+	textHeight := lineHeight*(len(lines)-1) + metrics.Ascent.Round() + metrics.Descent.Round()
+
+	padding := 8
+	margin := 20
+	radius := min(10, (textHeight+padding*2)/2)
+	bounds := rgba.Bounds()
+
+	// Позиция блока по углу кадра
+	boxWidth, boxHeight := textWidth+padding*2, textHeight+padding*2
+	x, y := bounds.Max.X-margin-boxWidth, bounds.Min.Y+margin
+	switch tpl.Position {
+	case "top-left":
+		x = bounds.Min.X + margin
+	case "bottom-left":
+		x, y = bounds.Min.X+margin, bounds.Max.Y-margin-boxHeight
+	case "bottom-right":
+		y = bounds.Max.Y - margin - boxHeight
+	}
+
+	// Подложка под текст
+	drawRoundedRect(rgba, image.Rect(x, y, x+boxWidth, y+boxHeight), radius, bg)
+
+	// Текст построчно
+	baseline := y + padding + metrics.Ascent.Round()
+	for _, line := range lines {
+		drawer.Dot = fixed.Point26_6{
+			X: fixed.I(x + padding),
+			Y: fixed.I(baseline),
+		}
+		drawer.DrawString(line)
+		baseline += lineHeight
+	}
+	return nil
+}
+
+// checkOverlay проверяет, что шрифт и цвета шаблона загружаются
+func checkOverlay(tpl config.OverlayTemplate) error {
+	face, err := loadFont(tpl.FontFile, 20)
+	if err != nil {
+		return err
+	}
+	face.Close()
+	for _, c := range []string{tpl.Color, tpl.Background} {
+		if _, err := parseColorOr(c, color.RGBA{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseColorOr(s string, fallback color.RGBA) (color.RGBA, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	return config.ParseColor(s)
+}
+
+// fonts - разобранные шрифты по пути файла ("" - встроенный). Face не потокобезопасен,
+// поэтому кешируется только сам шрифт, а Face создается на каждый кадр.
+var (
+	fonts      = map[string]*opentype.Font{}
+	fontsMutex sync.Mutex
+)
+
+func loadFont(path string, size float64) (font.Face, error) {
+	fontsMutex.Lock()
+	f, ok := fonts[path]
+	fontsMutex.Unlock()
+
+	if !ok {
+		data := goregular.TTF
+		if path != "" {
+			var err error
+			data, err = os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		f, err = opentype.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("шрифт %s: %w", path, err)
+		}
+		fontsMutex.Lock()
+		fonts[path] = f
+		fontsMutex.Unlock()
+	}
+
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// drawRoundedRect заливает скругленный прямоугольник с наложением по альфе через маску,
+// чтобы углы и стыки не получались темнее полупрозрачной подложки
+func drawRoundedRect(img *image.RGBA, rect image.Rectangle, r int, col color.Color) {
+	mask := image.NewAlpha(rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			// Расстояние до ближайшего центра скругления по каждой оси
+			dx := max(rect.Min.X+r-x, x-(rect.Max.X-r-1), 0)
+			dy := max(rect.Min.Y+r-y, y-(rect.Max.Y-r-1), 0)
+			if dx*dx+dy*dy <= r*r {
+				mask.SetAlpha(x, y, color.Alpha{A: 255})
+			}
+		}
+	}
+	draw.DrawMask(img, rect, &image.Uniform{col}, image.Point{}, mask, rect.Min, draw.Over)
+}
@@ -0,0 +1,163 @@
+package timelapse
+
+import (
+	"bambucam/config"
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOverlayLines(t *testing.T) {
+	meta := FrameMeta{
+		Time:        time.Date(2026, 5, 1, 14, 3, 9, 0, time.Local),
+		Elapsed:     2*3600 + 5*60 + 7,
+		Layer:       12,
+		TotalLayers: 240,
+		Percent:     42.6,
+		Nozzle:      219.6,
+		Bed:         55,
+		Task:        "cube",
+		Filament:    "PLA Matte",
+	}
+	tests := []struct {
+		name   string
+		fields []string
+		meta   FrameMeta
+		want   []string
+	}{
+		{"time", []string{"time"}, meta, []string{"14:03:09"}},
+		{"elapsed", []string{"elapsed"}, meta, []string{"2:05:07"}},
+		{"layer", []string{"layer"}, meta, []string{"Слой 12/240"}},
+		{"layer without total", []string{"layer"}, FrameMeta{Layer: 3}, []string{"Слой 3"}},
+		{"temperatures", []string{"nozzle", "bed"}, meta, []string{"Сопло 220°C", "Стол 55°C"}},
+		{"order follows template", []string{"percent", "task", "filament"}, meta, []string{"43%", "cube", "PLA Matte"}},
+		{"empty fields skipped", []string{"task", "filament", "percent"}, FrameMeta{}, []string{"0%"}},
+		{"unknown field", []string{"speed"}, meta, nil},
+	}
+	for _, tt := range tests {
+		if got := overlayLines(tt.fields, tt.meta); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilamentName(t *testing.T) {
+	ams := func(trayNow string) map[string]any {
+		return map[string]any{
+			"tray_now": trayNow,
+			"ams": []any{
+				map[string]any{"tray": []any{
+					map[string]any{"tray_type": "PLA"},
+					map[string]any{"tray_type": "PETG", "tray_sub_brands": "PETG HF"},
+				}},
+				map[string]any{"tray": []any{map[string]any{"tray_type": "ABS"}}},
+			},
+		}
+	}
+	tests := []struct {
+		name   string
+		status map[string]any
+		want   string
+	}{
+		{"no ams", map[string]any{}, ""},
+		{"first tray", map[string]any{"ams": ams("0")}, "PLA"},
+		{"brand over type", map[string]any{"ams": ams("1")}, "PETG HF"},
+		{"second unit", map[string]any{"ams": ams("4")}, "ABS"},
+		{"missing tray", map[string]any{"ams": ams("6")}, ""},
+		{"external spool", map[string]any{"ams": ams("254"), "vt_tray": map[string]any{"tray_type": "TPU"}}, "TPU"},
+		{"nothing loaded", map[string]any{"ams": ams("255")}, ""},
+	}
+	for _, tt := range tests {
+		if got := filamentName(tt.status); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// grayJPEG - однотонный серый кадр
+func grayJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{128}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRenderOverlay(t *testing.T) {
+	const width, height = 320, 240
+	frame := grayJPEG(t, width, height)
+	meta := FrameMeta{Time: time.Now(), Layer: 5}
+
+	// Точки внутри подложки у каждого угла: 5 px от края блока по горизонтали и 12 по вертикали
+	corners := map[string]image.Point{
+		"top-left":     {25, 32},
+		"top-right":    {width - 25, 32},
+		"bottom-left":  {25, height - 32},
+		"bottom-right": {width - 25, height - 32},
+	}
+	isRed := func(c color.Color) bool {
+		r, g, b, _ := c.RGBA()
+		return r>>8 > 200 && g>>8 < 80 && b>>8 < 80
+	}
+
+	for position := range corners {
+		t.Run(position, func(t *testing.T) {
+			tpl := config.OverlayTemplate{Fields: []string{"layer"}, Position: position, Background: "#FF0000"}
+			out, err := RenderOverlay(frame, tpl, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+				t.Fatalf("size changed to %v", img.Bounds())
+			}
+			for corner, p := range corners {
+				if got := isRed(img.At(p.X, p.Y)); got != (corner == position) {
+					t.Errorf("background at %s corner: %v", corner, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderOverlayErrors(t *testing.T) {
+	frame := grayJPEG(t, 64, 48)
+	meta := FrameMeta{Task: "cube"}
+
+	// Без строк кадр возвращается как есть, даже если он не JPEG
+	if out, err := RenderOverlay([]byte("raw"), config.OverlayTemplate{Fields: []string{"filament"}}, meta); err != nil || string(out) != "raw" {
+		t.Errorf("empty overlay: %q, %v", out, err)
+	}
+
+	tests := []struct {
+		name  string
+		frame []byte
+		tpl   config.OverlayTemplate
+		// badTemplate - ошибку находит и проверка шаблона перед сборкой
+		badTemplate bool
+	}{
+		{"broken frame", []byte("raw"), config.OverlayTemplate{Fields: []string{"task"}}, false},
+		{"bad color", frame, config.OverlayTemplate{Fields: []string{"task"}, Color: "white"}, true},
+		{"missing font", frame, config.OverlayTemplate{Fields: []string{"task"}, FontFile: filepath.Join(t.TempDir(), "none.ttf")}, true},
+	}
+	for _, tt := range tests {
+		if _, err := RenderOverlay(tt.frame, tt.tpl, meta); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+		if tt.badTemplate && checkOverlay(tt.tpl) == nil {
+			t.Errorf("%s: checkOverlay accepted the template", tt.name)
+		}
+	}
+}
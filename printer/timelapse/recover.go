@@ -0,0 +1,94 @@
+package timelapse
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// unfinished - сессия, которая не дошла до сборки: запись или сборка прервалась перезапуском
+func unfinished(s Session) bool {
+	switch s.Info.Status {
+	case TL_RECORDING, TL_PAUSED, TL_CONVERT:
+		return true
+	}
+	return false
+}
+
+// recoverSessions ищет незавершенные сессии после перезапуска. Последняя из записывавшихся
+// ждет первого отчета принтера: если идет та же печать, запись продолжится в ту же папку.
+// Остальные собираются в видео сразу.
+func (t *Timelapse) recoverSessions() {
+	savePath := t.core.GetConfig().Timelapse.SavePath
+	if savePath == "" {
+		return
+	}
+
+	var sessions []Session
+	for _, s := range ListSessions(savePath) {
+		if unfinished(s) {
+			sessions = append(sessions, s)
+		}
+	}
+	if len(sessions) == 0 {
+		return
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Info.StartedAt.Before(sessions[j].Info.StartedAt)
+	})
+
+	// Прерванную сборку продолжать нечего, ее просто повторяем
+	last := sessions[len(sessions)-1]
+	if last.Info.Status != TL_CONVERT {
+		t.pending = &last
+		sessions = sessions[:len(sessions)-1]
+		log.Printf("[Timelapse] Найдена незавершенная сессия %s (%s), жду состояния принтера", last.FolderName, last.Info.Name)
+	}
+
+	if len(sessions) > 0 {
+		go func() {
+			for _, s := range sessions {
+				log.Printf("[Timelapse] Сборка брошенной сессии %s", s.FolderName)
+				t.assembleSession(filepath.Join(savePath, s.FolderName), s.Info)
+			}
+		}()
+	}
+}
+
+// resumePending решает судьбу найденной при запуске сессии по первому отчету принтера
+func (t *Timelapse) resumePending(state string) {
+	s := t.pending
+	t.pending = nil
+	fullPath := filepath.Join(t.core.GetConfig().Timelapse.SavePath, s.FolderName)
+
+	taskName, _ := t.core.GetStatus()["subtask_name"].(string)
+	if taskName == "" {
+		taskName = "unknown"
+	}
+
+	if (state != "RUNNING" && state != "PAUSE") || taskName != s.Info.Name {
+		log.Printf("[Timelapse] Печать %s завершилась без нас, собираю видео из %s", s.Info.Name, s.FolderName)
+		go t.assembleSession(fullPath, s.Info)
+		return
+	}
+
+	t.mu.Lock()
+	t.currentFolder = fullPath
+	t.currentTask = s.Info.Name
+	t.startTime = s.Info.StartedAt
+	t.lastTime = time.Now()
+	t.lastLayer = 0
+	t.lastSample = nil
+	if s.LastFrame != "" {
+		fmt.Sscanf(s.LastFrame, "layer_%d_", &t.lastLayer)
+	}
+	t.status = TL_RECORDING
+	if state == "PAUSE" {
+		t.status = TL_PAUSED
+	}
+	t.mu.Unlock()
+	t.saveStatus()
+	log.Printf("[Timelapse] Запись продолжена в %s со слоя %d", s.FolderName, t.lastLayer)
+}
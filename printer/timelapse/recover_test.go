@@ -0,0 +1,211 @@
+package timelapse
+
+import (
+	"bambucam/config"
+	"bambucam/printer"
+	"bambucam/printer/events"
+	"bambucam/printer/jobs"
+	"bambucam/printer/upload"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeCore - Core с настройками, статусом принтера и настоящими очередями сборки и выгрузки
+type fakeCore struct {
+	printer.Core
+	cfg     *config.Config
+	status  map[string]any
+	bus     *events.Bus
+	jobs    *jobs.Queue
+	uploads *upload.Uploader
+	// assembled получает папки, которые очередь взяла в сборку; если задан hold,
+	// сборка не завершается, пока он не закрыт
+	assembled chan string
+	hold      chan struct{}
+}
+
+func (f *fakeCore) GetConfig() *config.Config { return f.cfg }
+func (f *fakeCore) GetStatus() map[string]any { return f.status }
+func (f *fakeCore) Events() *events.Bus       { return f.bus }
+func (f *fakeCore) Jobs() *jobs.Queue         { return f.jobs }
+func (f *fakeCore) Uploads() *upload.Uploader { return f.uploads }
+
+func newTestTimelapse(t *testing.T) (*Timelapse, *fakeCore) {
+	t.Helper()
+	if err := config.SetPaths(t.TempDir(), ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { config.SetPaths("", "") })
+
+	cfg := config.DefaultConfig()
+	cfg.Timelapse.SavePath = t.TempDir()
+	core := &fakeCore{cfg: cfg, status: map[string]any{}, bus: events.NewBus(), assembled: make(chan string, 4)}
+	core.jobs = jobs.NewQueue(core, func(ctx context.Context, job jobs.Job, report func(string, float64)) error {
+		core.assembled <- job.Folder
+		if core.hold != nil {
+			<-core.hold
+		}
+		return nil
+	})
+	core.uploads = upload.NewUploader(core)
+	return NewTimelapse(core), core
+}
+
+// writeSession создает папку сессии с info.json и кадрами указанных слоев
+func writeSession(t *testing.T, savePath, folder string, info TimelapsInfo, layers ...int) {
+	t.Helper()
+	dir := filepath.Join(savePath, folder)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(info)
+	if err := os.WriteFile(filepath.Join(dir, "info.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, layer := range layers {
+		name := fmt.Sprintf("layer_%04d_%d.jpg", layer, info.StartedAt.Unix()+int64(layer))
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("jpeg"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readInfo читает info.json сессии
+func readInfo(t *testing.T, dir string) TimelapsInfo {
+	t.Helper()
+	var info TimelapsInfo
+	data, err := os.ReadFile(filepath.Join(dir, "info.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// waitTimelapseStatus ждет события о смене статуса сессии folder на want
+func waitTimelapseStatus(t *testing.T, ch <-chan events.Event, folder string, want TLStatus) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case evt := <-ch:
+			data, ok := evt.Data.(map[string]any)
+			if evt.Type == events.TypeTimelapse && ok && data["folder"] == folder && data["status"] == want.String() {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%s did not become %s", folder, want)
+		}
+	}
+}
+
+func TestResumePending(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  string
+		task   string
+		resume bool
+		status TLStatus // статус продолженной записи
+	}{
+		{name: "running", state: "RUNNING", task: "cube", resume: true, status: TL_RECORDING},
+		{name: "paused", state: "PAUSE", task: "cube", resume: true, status: TL_PAUSED},
+		{name: "other task", state: "RUNNING", task: "benchy"},
+		{name: "finished", state: "FINISH", task: "cube"},
+		{name: "no task name", state: "RUNNING"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tl, core := newTestTimelapse(t)
+			savePath := core.cfg.Timelapse.SavePath
+			started := time.Now().Add(-time.Hour).Truncate(time.Second)
+			writeSession(t, savePath, "cube_1", TimelapsInfo{Name: "cube", StartedAt: started, Status: TL_RECORDING}, 1, 2, 7)
+			s, err := ReadSession(savePath, "cube_1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tl.pending = &s
+			core.status = map[string]any{"gcode_state": tt.state}
+			if tt.task != "" {
+				core.status["subtask_name"] = tt.task
+			}
+
+			ch, unsubscribe := core.bus.Subscribe(16)
+			defer unsubscribe()
+			tl.resumePending(tt.state)
+			if tl.pending != nil {
+				t.Error("pending session is not cleared")
+			}
+			dir := filepath.Join(savePath, "cube_1")
+
+			if !tt.resume {
+				// Сессия собирается в видео, запись не продолжается
+				select {
+				case folder := <-core.assembled:
+					if folder != "cube_1" {
+						t.Fatalf("assembled %s", folder)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("session was not assembled")
+				}
+				waitTimelapseStatus(t, ch, "cube_1", TL_FINISHED)
+				if tl.status != TL_IDLE || tl.currentFolder != "" {
+					t.Errorf("recording continued: status %s, folder %q", tl.status, tl.currentFolder)
+				}
+				return
+			}
+
+			if tl.status != tt.status || tl.currentFolder != dir || tl.currentTask != "cube" {
+				t.Errorf("resumed: status %s, folder %q, task %q", tl.status, tl.currentFolder, tl.currentTask)
+			}
+			if tl.lastLayer != 7 || !tl.startTime.Equal(started) {
+				t.Errorf("resumed from layer %d, started %v", tl.lastLayer, tl.startTime)
+			}
+			if info := readInfo(t, dir); info.Status != tt.status || info.Name != "cube" {
+				t.Errorf("info.json = %+v", info)
+			}
+			if list := core.jobs.List(); len(list) != 0 {
+				t.Errorf("resumed session queued for assembly: %+v", list)
+			}
+		})
+	}
+}
+
+func TestCheckTimelapsePause(t *testing.T) {
+	tl, core := newTestTimelapse(t)
+	core.cfg.Timelapse.MinFreeMB = 0
+	core.status = map[string]any{"gcode_state": "RUNNING", "subtask_name": "cube"}
+
+	// Пауза принтера приостанавливает запись, продолжение печати - возобновляет в той же папке
+	steps := []struct {
+		state string
+		want  TLStatus
+	}{
+		{"RUNNING", TL_RECORDING},
+		{"PAUSE", TL_PAUSED},
+		{"RUNNING", TL_RECORDING},
+	}
+	var folder string
+	for i, step := range steps {
+		core.status["gcode_state"] = step.state
+		tl.checkTimelapse()
+		if tl.status != step.want {
+			t.Fatalf("step %d (%s): status %s, want %s", i, step.state, tl.status, step.want)
+		}
+		if folder == "" {
+			folder = tl.currentFolder
+		} else if tl.currentFolder != folder {
+			t.Fatalf("step %d (%s): new folder %s, want %s", i, step.state, tl.currentFolder, folder)
+		}
+	}
+	if sessions := ListSessions(core.cfg.Timelapse.SavePath); len(sessions) != 1 {
+		t.Errorf("sessions = %d, want 1", len(sessions))
+	}
+}
@@ -0,0 +1,170 @@
+package timelapse
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const mb = 1 << 20
+
+// Usage - место, занятое таймлапсами, и свободное место на диске
+type Usage struct {
+	Sessions int
+	Total    int64
+	// Frames - исходные кадры, Videos - все остальное (видео, превью, служебные файлы)
+	Frames int64
+	Videos int64
+	// Free - свободно на диске, FreeKnown - удалось ли это узнать
+	Free      uint64
+	FreeKnown bool
+}
+
+// DiskUsage подсчитывает место по уже прочитанным сессиям и свободное место в savePath
+func DiskUsage(savePath string, sessions []Session) Usage {
+	u := Usage{Sessions: len(sessions)}
+	for _, s := range sessions {
+		u.Total += s.Size
+		u.Frames += s.FramesSize
+	}
+	u.Videos = u.Total - u.Frames
+	if free, err := freeSpace(savePath); err == nil {
+		u.Free, u.FreeKnown = free, true
+	}
+	return u
+}
+
+// enoughSpace проверяет перед началом записи, что на диске есть MinFreeMB. Если места мало,
+// сначала применяются правила хранения, а о нехватке пишется в лог один раз до ее устранения.
+func (t *Timelapse) enoughSpace() bool {
+	cfg := t.core.GetConfig().Timelapse
+	if cfg.MinFreeMB <= 0 {
+		return true
+	}
+	os.MkdirAll(cfg.SavePath, 0755)
+
+	need := uint64(cfg.MinFreeMB) * mb
+	free, err := freeSpace(cfg.SavePath)
+	if err != nil {
+		// Не удалось узнать - не мешаем записи
+		return true
+	}
+	if free < need {
+		t.applyRetention()
+		free, _ = freeSpace(cfg.SavePath)
+	}
+	if free < need {
+		if !t.lowSpace {
+			log.Printf("[Timelapse] Мало места на диске: свободно %s, нужно не меньше %s. Запись не начата",
+				humanize.Bytes(free), humanize.Bytes(need))
+		}
+		t.lowSpace = true
+		return false
+	}
+	t.lowSpace = false
+	return true
+}
+
+// pruneFrames удаляет исходные кадры собранной сессии, оставляя каждый keepEvery-й (0 - ни одного)
+// и последний как обложку. Записи удаленных кадров убираются и из frames.jsonl.
+func pruneFrames(fullPath string, keepEvery int) (int, error) {
+	paths, err := listFrames(fullPath)
+	if err != nil || len(paths) == 0 {
+		return 0, err
+	}
+
+	kept := map[string]bool{}
+	deleted := 0
+	for i, path := range paths {
+		if (keepEvery > 0 && i%keepEvery == 0) || i == len(paths)-1 {
+			kept[filepath.Base(path)] = true
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	if !HasFrameRecords(fullPath) {
+		return deleted, nil
+	}
+	records, err := ReadFrameRecords(fullPath)
+	if err != nil {
+		return deleted, err
+	}
+	var data []byte
+	for _, rec := range records {
+		if !kept[rec.File] {
+			continue
+		}
+		line, _ := json.Marshal(rec)
+		data = append(append(data, line...), '\n')
+	}
+	tmp := filepath.Join(fullPath, FramesFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return deleted, err
+	}
+	return deleted, os.Rename(tmp, filepath.Join(fullPath, FramesFile))
+}
+
+// applyRetention удаляет сессии старше MaxAgeDays, а затем самые старые, пока все вместе
+// занимают больше MaxSizeMB. Идущая и приостановленная запись, папки в очереди сборки или
+// выгрузки не трогаются. Сессия со статусом сборки, для которой в очереди нет задания
+// (сборку прервали или задание удалили), считается завершенной.
+func (t *Timelapse) applyRetention() {
+	cfg := t.core.GetConfig().Timelapse
+	if cfg.MaxAgeDays <= 0 && cfg.MaxSizeMB <= 0 {
+		return
+	}
+
+	// Вызывается из цикла записи, после сборок и по таймеру - проходы идут по очереди
+	t.retention.Lock()
+	defer t.retention.Unlock()
+
+	sessions := ListSessions(cfg.SavePath)
+	slices.SortFunc(sessions, func(a, b Session) int { return a.Started().Compare(b.Started()) })
+
+	var total int64
+	for _, s := range sessions {
+		total += s.Size
+	}
+
+	t.mu.Lock()
+	current := filepath.Base(t.currentFolder)
+	t.mu.Unlock()
+	deadline := time.Now().AddDate(0, 0, -cfg.MaxAgeDays)
+	limit := int64(cfg.MaxSizeMB) * mb
+
+	for _, s := range sessions {
+		expired := cfg.MaxAgeDays > 0 && s.Started().Before(deadline)
+		oversize := cfg.MaxSizeMB > 0 && total > limit
+		if !expired && !oversize {
+			continue
+		}
+		if _, finishing := t.finishing.Load(s.FolderName); finishing || s.FolderName == current || InUse(t.core, s) != nil {
+			continue
+		}
+
+		fullPath, err := FolderPath(cfg.SavePath, s.FolderName)
+		if err != nil {
+			continue
+		}
+		if err := os.RemoveAll(fullPath); err != nil {
+			log.Printf("[Timelapse] Не удалось удалить %s по правилам хранения: %v", s.FolderName, err)
+			continue
+		}
+		total -= s.Size
+		reason := "превышен общий размер"
+		if expired {
+			reason = fmt.Sprintf("старше %d дн.", cfg.MaxAgeDays)
+		}
+		log.Printf("[Timelapse] Удалена сессия %s (%s, %s)", s.FolderName, humanize.Bytes(uint64(s.Size)), reason)
+	}
+}
@@ -0,0 +1,137 @@
+package timelapse
+
+import (
+	"bambucam/config"
+	"bambucam/printer/jobs"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// frameLayers возвращает слои оставшихся в папке кадров
+func frameLayers(t *testing.T, dir string) []int {
+	t.Helper()
+	paths, _ := filepath.Glob(filepath.Join(dir, "layer_*.jpg"))
+	var layers []int
+	for _, path := range paths {
+		var layer, ts int
+		if _, err := fmt.Sscanf(filepath.Base(path), "layer_%04d_%d.jpg", &layer, &ts); err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, layer)
+	}
+	return layers
+}
+
+func TestPruneFrames(t *testing.T) {
+	tests := []struct {
+		keepEvery int
+		kept      []int // оставшиеся слои: каждый keepEvery-й и последний
+	}{
+		{keepEvery: 0, kept: []int{7}},
+		{keepEvery: 1, kept: []int{1, 2, 3, 4, 5, 6, 7}},
+		{keepEvery: 2, kept: []int{1, 3, 5, 7}},
+		{keepEvery: 3, kept: []int{1, 4, 7}},
+		{keepEvery: 4, kept: []int{1, 5, 7}},
+		{keepEvery: 10, kept: []int{1, 7}},
+	}
+	started := time.Now().Truncate(time.Second)
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.keepEvery), func(t *testing.T) {
+			savePath := t.TempDir()
+			writeSession(t, savePath, "cube", TimelapsInfo{StartedAt: started, Status: TL_FINISHED}, 1, 2, 3, 4, 5, 6, 7)
+			dir := filepath.Join(savePath, "cube")
+			paths, _ := listFrames(dir)
+			for i, path := range paths {
+				if err := appendFrameRecord(dir, FrameRecord{File: filepath.Base(path), FrameMeta: FrameMeta{Layer: i + 1}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deleted, err := pruneFrames(dir, tt.keepEvery)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != 7-len(tt.kept) {
+				t.Errorf("deleted %d frames, want %d", deleted, 7-len(tt.kept))
+			}
+			if got := frameLayers(t, dir); !slices.Equal(got, tt.kept) {
+				t.Errorf("frames %v, want %v", got, tt.kept)
+			}
+
+			// frames.jsonl описывает только оставшиеся кадры
+			records, err := ReadFrameRecords(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var layers []int
+			for _, rec := range records {
+				if _, err := os.Stat(filepath.Join(dir, rec.File)); err != nil {
+					t.Errorf("record for deleted frame %s", rec.File)
+				}
+				layers = append(layers, rec.Layer)
+			}
+			if !slices.Equal(layers, tt.kept) {
+				t.Errorf("records %v, want %v", layers, tt.kept)
+			}
+		})
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	tl, core := newTestTimelapse(t)
+	savePath := core.cfg.Timelapse.SavePath
+	core.cfg.Timelapse.MaxAgeDays = 7
+	core.cfg.Upload.Targets = []config.UploadTarget{{Name: "disk", Type: config.UploadLocal, Path: t.TempDir()}}
+	core.hold = make(chan struct{})
+
+	old := time.Now().AddDate(0, 0, -30)
+	tests := []struct {
+		folder  string
+		status  TLStatus
+		started time.Time
+		kept    bool
+	}{
+		{folder: "recording", status: TL_RECORDING, started: old, kept: true},
+		{folder: "paused", status: TL_PAUSED, started: old, kept: true},
+		{folder: "current", status: TL_FINISHED, started: old, kept: true},
+		{folder: "assembly", status: TL_CONVERT, started: old, kept: true},
+		{folder: "finishing", status: TL_CONVERT, started: old, kept: true},
+		{folder: "upload", status: TL_FINISHED, started: old, kept: true},
+		{folder: "fresh", status: TL_FINISHED, started: time.Now(), kept: true},
+		{folder: "done", status: TL_FINISHED, started: old},
+		{folder: "failed", status: TL_ERROR, started: old},
+		// Сборка прервалась, а задания в очереди нет - сессия не должна висеть вечно
+		{folder: "stuck", status: TL_CONVERT, started: old},
+	}
+	for _, tt := range tests {
+		writeSession(t, savePath, tt.folder, TimelapsInfo{Name: tt.folder, StartedAt: tt.started, Status: tt.status}, 1)
+	}
+
+	tl.currentFolder = filepath.Join(savePath, "current")
+	tl.finishing.Store("finishing", true)
+	// Очередь выгрузки не запущена, поэтому задача так и остается ждать
+	if _, err := core.uploads.Enqueue("upload"); err != nil {
+		t.Fatal(err)
+	}
+	job, err := core.jobs.Enqueue(jobs.Job{Folder: "assembly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-core.assembled
+	defer core.jobs.Wait(job.ID)
+	defer close(core.hold)
+
+	tl.applyRetention()
+
+	for _, tt := range tests {
+		_, err := os.Stat(filepath.Join(savePath, tt.folder))
+		if kept := err == nil; kept != tt.kept {
+			t.Errorf("%s: kept %v, want %v", tt.folder, kept, tt.kept)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package timelapse
+
+import (
+	"bambucam/printer"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrBadFolder = errors.New("invalid timelapse folder name")
+
+// Причины, по которым папку сессии сейчас нельзя удалять
+var (
+	ErrRecording  = errors.New("в эту папку идет запись")
+	ErrAssembling = errors.New("папка в очереди сборки")
+	ErrUploading  = errors.New("папка в очереди выгрузки")
+)
+
+// Файлы результата сборки: ffmpeg собирает mp4, встроенный кодировщик - avi и gif
+const (
+	VideoMP4   = "timelapse.mp4"
+	VideoAVI   = "timelapse.avi"
+	PreviewMP4 = "preview.mp4"
+	PreviewGIF = "preview.gif"
+)
+
+// VideoFile возвращает имя собранного видео в папке сессии или "", если его нет
+func VideoFile(fullPath string) string {
+	return firstExisting(fullPath, VideoMP4, VideoAVI)
+}
+
+// PreviewFile возвращает имя превью в папке сессии или "", если его нет
+func PreviewFile(fullPath string) string {
+	return firstExisting(fullPath, PreviewMP4, PreviewGIF)
+}
+
+func firstExisting(dir string, names ...string) string {
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// Session описывает папку таймлапса на диске
+type Session struct {
+	FolderName string
+	Info       TimelapsInfo
+	HasVideo   bool
+	HasPreview bool
+	// VideoFile и PreviewFile - имена файлов в папке (mp4 от ffmpeg или avi/gif встроенного кодировщика)
+	VideoFile   string
+	PreviewFile string
+	VideoSize   int64
+	FrameCount  int
+	// LastFrame - имя последнего кадра, используется как обложка
+	LastFrame string
+	// RawFrames - кадры без наложения, к ним есть frames.jsonl
+	RawFrames bool
+	// Size - место, занятое папкой, FramesSize - из него под исходные кадры
+	Size       int64
+	FramesSize int64
+	// ModTime - время изменения папки, для старых сессий без info.json вместо StartedAt
+	ModTime time.Time
+}
+
+// FolderPath возвращает полный путь к папке сессии, не давая выйти за пределы savePath
+func FolderPath(savePath, folder string) (string, error) {
+	if folder == "" || folder == "." || folder == ".." || strings.ContainsAny(folder, `/\`) {
+		return "", ErrBadFolder
+	}
+	return filepath.Join(savePath, folder), nil
+}
+
+// ReadSession собирает сведения о сессии по содержимому папки
+func ReadSession(savePath, folder string) (Session, error) {
+	fullPath, err := FolderPath(savePath, folder)
+	if err != nil {
+		return Session{}, err
+	}
+
+	st, err := os.Stat(fullPath)
+	if err != nil {
+		return Session{}, err
+	}
+	if !st.IsDir() {
+		return Session{}, os.ErrNotExist
+	}
+
+	s := Session{FolderName: folder, ModTime: st.ModTime()}
+
+	if data, err := os.ReadFile(filepath.Join(fullPath, "info.json")); err == nil {
+		json.Unmarshal(data, &s.Info)
+	}
+
+	frames, _ := filepath.Glob(filepath.Join(fullPath, "layer_*.jpg"))
+	s.FrameCount = len(frames)
+	if len(frames) > 0 {
+		s.LastFrame = filepath.Base(frames[len(frames)-1])
+	}
+
+	if s.VideoFile = VideoFile(fullPath); s.VideoFile != "" {
+		s.HasVideo = true
+		if st, err := os.Stat(filepath.Join(fullPath, s.VideoFile)); err == nil {
+			s.VideoSize = st.Size()
+		}
+	}
+	s.PreviewFile = PreviewFile(fullPath)
+	s.HasPreview = s.PreviewFile != ""
+	s.RawFrames = HasFrameRecords(fullPath)
+
+	entries, _ := os.ReadDir(fullPath)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		s.Size += info.Size()
+		if ok, _ := filepath.Match("layer_*.jpg", entry.Name()); ok {
+			s.FramesSize += info.Size()
+		}
+	}
+
+	return s, nil
+}
+
+// InUse сообщает, почему папку сессии сейчас нельзя удалять: в нее идет или может
+// продолжиться запись, она ждет сборки или выгрузки. nil - папка свободна.
+func InUse(core printer.Core, s Session) error {
+	if st := s.Info.Status; st == TL_RECORDING || st == TL_PAUSED {
+		return ErrRecording
+	}
+	if _, ok := core.Jobs().Active()[s.FolderName]; ok {
+		return ErrAssembling
+	}
+	if core.Uploads().Busy(s.FolderName) {
+		return ErrUploading
+	}
+	return nil
+}
+
+// Started - время начала сессии, для старых сессий без info.json - время изменения папки
+func (s Session) Started() time.Time {
+	if s.Info.StartedAt.IsZero() {
+		return s.ModTime
+	}
+	return s.Info.StartedAt
+}
+
+// ListSessions возвращает все сессии в каталоге таймлапсов
+func ListSessions(savePath string) []Session {
+	var list []Session
+
+	entries, _ := os.ReadDir(savePath)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if s, err := ReadSession(savePath, entry.Name()); err == nil {
+			list = append(list, s)
+		}
+	}
+	return list
+}
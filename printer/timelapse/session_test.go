@@ -0,0 +1,61 @@
+package timelapse
+
+import (
+	"bambucam/config"
+	"bambucam/printer/jobs"
+	"testing"
+	"time"
+)
+
+func TestInUse(t *testing.T) {
+	_, core := newTestTimelapse(t)
+	savePath := core.cfg.Timelapse.SavePath
+	core.cfg.Upload.Targets = []config.UploadTarget{{Name: "disk", Type: config.UploadLocal, Path: t.TempDir()}}
+	core.hold = make(chan struct{})
+
+	started := time.Now().Add(-time.Hour)
+	sessions := map[string]TLStatus{
+		"recording": TL_RECORDING,
+		"paused":    TL_PAUSED,
+		"assembly":  TL_CONVERT,
+		"upload":    TL_FINISHED,
+		"done":      TL_FINISHED,
+		// Сборка прервалась без задания в очереди - папка свободна
+		"stuck": TL_CONVERT,
+	}
+	for folder, status := range sessions {
+		writeSession(t, savePath, folder, TimelapsInfo{Name: folder, StartedAt: started, Status: status}, 1)
+	}
+	// Очередь выгрузки не запущена, поэтому задача так и остается ждать
+	if _, err := core.uploads.Enqueue("upload"); err != nil {
+		t.Fatal(err)
+	}
+	job, err := core.jobs.Enqueue(jobs.Job{Folder: "assembly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-core.assembled
+	defer core.jobs.Wait(job.ID)
+	defer close(core.hold)
+
+	tests := []struct {
+		folder string
+		want   error
+	}{
+		{"recording", ErrRecording},
+		{"paused", ErrRecording},
+		{"assembly", ErrAssembling},
+		{"upload", ErrUploading},
+		{"done", nil},
+		{"stuck", nil},
+	}
+	for _, tt := range tests {
+		s, err := ReadSession(savePath, tt.folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := InUse(core, s); got != tt.want {
+			t.Errorf("InUse(%s) = %v, want %v", tt.folder, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package timelapse
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"time"
+)
+
+// Сетка, по которой сравниваются кадры: движение ищется по яркости уменьшенного кадра
+const (
+	motionGridW = 64
+	motionGridH = 36
+)
+
+// smoothCapture - окно плавного режима: после смены слоя кадры с камеры сравниваются
+// между собой, и в таймлапс попадает самый неподвижный (голова припаркована или стоит)
+type smoothCapture struct {
+	layer    int
+	deadline time.Time
+
+	prevFrame  []byte
+	prevSample []uint8
+
+	best      []byte
+	bestMeta  FrameMeta
+	bestScore float64
+	scored    bool
+}
+
+func newSmoothCapture(layer int, window time.Duration) *smoothCapture {
+	return &smoothCapture{layer: layer, deadline: time.Now().Add(window)}
+}
+
+// add учитывает очередной кадр окна. Повтор того же кадра (камера отдает реже, чем
+// опрашивает воркер) пропускается, иначе он выглядел бы идеально неподвижным.
+func (s *smoothCapture) add(frame []byte, meta FrameMeta) {
+	if len(frame) == 0 || bytes.Equal(frame, s.prevFrame) {
+		return
+	}
+	sample, err := motionSample(frame)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case s.prevSample == nil:
+		// Первый кадр окна сравнить не с чем, он остается запасным
+		s.best, s.bestMeta = frame, meta
+	default:
+		score := motionScore(s.prevSample, sample)
+		if !s.scored || score < s.bestScore {
+			s.best, s.bestMeta, s.bestScore, s.scored = frame, meta, score, true
+		}
+	}
+	s.prevFrame, s.prevSample = frame, sample
+}
+
+func (s *smoothCapture) expired(now time.Time) bool {
+	return !now.Before(s.deadline)
+}
+
+// motionSample - яркость кадра в узлах сетки motionGridW x motionGridH
+func motionSample(frame []byte) ([]uint8, error) {
+	img, err := jpeg.Decode(bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	sample := make([]uint8, 0, motionGridW*motionGridH)
+	ycc, _ := img.(*image.YCbCr)
+	for gy := 0; gy < motionGridH; gy++ {
+		y := b.Min.Y + (gy*2+1)*b.Dy()/(motionGridH*2)
+		for gx := 0; gx < motionGridW; gx++ {
+			x := b.Min.X + (gx*2+1)*b.Dx()/(motionGridW*2)
+			if ycc != nil {
+				sample = append(sample, ycc.Y[ycc.YOffset(x, y)])
+				continue
+			}
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sample = append(sample, uint8((299*r+587*g+114*bl)/1000>>8))
+		}
+	}
+	return sample, nil
+}
+
+// changedPercent - доля узлов сетки в процентах, где яркость заметно изменилась. Мелкий шум
+// сжатия и мерцание подсветки ниже порога changedDelta не учитываются.
+func changedPercent(a, b []uint8) float64 {
+	const changedDelta = 12
+	if len(a) != len(b) || len(a) == 0 {
+		return 100
+	}
+	changed := 0
+	for i := range a {
+		if d := int(a[i]) - int(b[i]); d > changedDelta || d < -changedDelta {
+			changed++
+		}
+	}
+	return float64(changed) * 100 / float64(len(a))
+}
+
+// motionScore - средняя разница яркости двух сеток, 0 - кадры не отличаются
+func motionScore(a, b []uint8) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 255
+	}
+	var sum int
+	for i := range a {
+		d := int(a[i]) - int(b[i])
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return float64(sum) / float64(len(a))
+}
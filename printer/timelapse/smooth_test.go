@@ -0,0 +1,149 @@
+package timelapse
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+// headFrame - кадр 320x180 с ярким квадратом ("головой") на позиции x. Разное качество
+// сжатия дает разные байты одной и той же картинки.
+func headFrame(t *testing.T, x, quality int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 320, 180))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{40}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(x, 60, x+60, 120), image.NewUniform(color.Gray{230}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMotionScore(t *testing.T) {
+	still, moved := headFrame(t, 100, 90), headFrame(t, 200, 90)
+	a, err := motionSample(still)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != motionGridW*motionGridH {
+		t.Fatalf("sample of %d points", len(a))
+	}
+	same, _ := motionSample(headFrame(t, 100, 80))
+	other, _ := motionSample(moved)
+
+	if score := motionScore(a, same); score > 2 {
+		t.Errorf("same picture scored %.2f", score)
+	}
+	if score := motionScore(a, other); score < 10 {
+		t.Errorf("moved head scored %.2f", score)
+	}
+	if score := motionScore(a, a[:10]); score != 255 {
+		t.Errorf("different grids scored %.2f, want 255", score)
+	}
+	if _, err := motionSample([]byte("jpeg")); err == nil {
+		t.Error("broken frame sampled")
+	}
+}
+
+func TestSmoothCapture(t *testing.T) {
+	frames := map[string][]byte{
+		"left":       headFrame(t, 0, 90),
+		"middle":     headFrame(t, 130, 90),
+		"middle2":    headFrame(t, 130, 85),
+		"right":      headFrame(t, 260, 90),
+		"right2":     headFrame(t, 260, 85),
+		"nudged":     headFrame(t, 30, 90),
+		"broken":     []byte("jpeg"),
+		"empty":      nil,
+		"left again": headFrame(t, 0, 90),
+	}
+	tests := []struct {
+		name   string
+		frames []string
+		best   string
+	}{
+		// Голова остановилась: кадр, почти не отличающийся от предыдущего, лучший
+		{"stillest wins", []string{"left", "middle", "middle2", "right"}, "middle2"},
+		{"later still frame", []string{"left", "middle", "right", "right2"}, "right2"},
+		// Единственный кадр сравнить не с чем, он остается запасным
+		{"single frame", []string{"left"}, "left"},
+		// Без остановки выбирается кадр с наименьшим сдвигом
+		{"smallest move", []string{"left", "nudged", "right"}, "nudged"},
+		// Тот же кадр повторно (камера отдает реже опроса) не считается неподвижным
+		{"repeated bytes", []string{"left", "left again", "middle"}, "middle"},
+		{"broken and empty skipped", []string{"left", "broken", "empty", "middle", "middle2"}, "middle2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSmoothCapture(7, time.Minute)
+			for i, name := range tt.frames {
+				s.add(frames[name], FrameMeta{Layer: 7, Elapsed: int64(i)})
+			}
+			if !bytes.Equal(s.best, frames[tt.best]) {
+				t.Errorf("best frame is not %s (elapsed %d)", tt.best, s.bestMeta.Elapsed)
+			}
+		})
+	}
+}
+
+func TestSmoothCaptureExpired(t *testing.T) {
+	s := newSmoothCapture(3, 10*time.Second)
+	if s.layer != 3 {
+		t.Errorf("layer %d", s.layer)
+	}
+	tests := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{0, false},
+		{9 * time.Second, false},
+		{10 * time.Second, true},
+		{time.Minute, true},
+	}
+	start := s.deadline.Add(-10 * time.Second)
+	for _, tt := range tests {
+		if got := s.expired(start.Add(tt.at)); got != tt.want {
+			t.Errorf("expired after %v = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestChangedPercent(t *testing.T) {
+	base := make([]uint8, 100)
+	for i := range base {
+		base[i] = 100
+	}
+	shifted := func(n int, delta int) []uint8 {
+		b := append([]uint8(nil), base...)
+		for i := range n {
+			b[i] = uint8(int(b[i]) + delta)
+		}
+		return b
+	}
+	tests := []struct {
+		name string
+		b    []uint8
+		want float64
+	}{
+		{"same", base, 0},
+		// Шум не выше порога яркости не считается изменением
+		{"noise", shifted(100, 12), 0},
+		{"darker noise", shifted(100, -12), 0},
+		{"part changed", shifted(25, 13), 25},
+		{"darker part", shifted(40, -60), 40},
+		{"all changed", shifted(100, 100), 100},
+		{"different grids", base[:50], 100},
+		{"empty", nil, 100},
+	}
+	for _, tt := range tests {
+		if got := changedPercent(base, tt.b); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package timelapse
 
 import (
+	"bambucam/config"
 	"bambucam/printer"
+	"bambucam/printer/jobs"
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -16,12 +20,25 @@ type Timelapse struct {
 	stop       chan struct{}
 	mu         sync.Mutex
 	assembling sync.Map
+	// finishing - папки, сборка которых закончилась, но finishAssembly еще не записал итог
+	finishing sync.Map
+	// retention не дает двум проходам правил хранения удалять сессии одновременно
+	retention sync.Mutex
 
 	lastLayer     int
 	lastTime      time.Time
 	startTime     time.Time
 	currentFolder string
 	currentTask   string
+	// smooth - открытое окно плавного режима, nil если кадр слоя уже снят
+	smooth *smoothCapture
+	// lastSample и lastSaved - последний сохраненный кадр для пропуска повторов в режиме по времени
+	lastSample []uint8
+	lastSaved  time.Time
+	// lowSpace - о нехватке места уже сообщено
+	lowSpace bool
+	// pending - сессия, прерванная перезапуском, ждет первого отчета принтера
+	pending *Session
 }
 
 func NewTimelapse(core printer.Core) *Timelapse {
@@ -33,6 +50,8 @@ func NewTimelapse(core printer.Core) *Timelapse {
 
 func (t *Timelapse) Start() {
 	log.Println("[Timelapse] Мониторинг запущен")
+	t.recoverSessions()
+	go t.applyRetention()
 	go t.worker()
 	go t.generateMissingPreviews()
 }
@@ -43,14 +62,65 @@ func (t *Timelapse) Stop() {
 	}
 }
 
+// RunJob выполняет задание очереди сборки. Прогресс: подготовка кадров - первые 30% (если
+// рисуется наложение или кадры отбираются), видео - до 90%, превью - остальные 10%.
+func (t *Timelapse) RunJob(ctx context.Context, job jobs.Job, report func(stage string, progress float64)) error {
+	fullPath := filepath.Join(t.core.GetConfig().Timelapse.SavePath, job.Folder)
+	if s, err := ReadSession(t.core.GetConfig().Timelapse.SavePath, job.Folder); err == nil && s.Info.FramesDeleted {
+		return fmt.Errorf("исходные кадры удалены после сборки, пересобрать видео нельзя")
+	}
+	tpl, enabled, err := t.overlay(job.Overlay)
+	if err != nil {
+		return err
+	}
+	stages, err := t.stages(job.Stages)
+	if err != nil {
+		return err
+	}
+
+	// В старых сессиях нет frames.jsonl: наложение в них уже нарисовано при съемке, а стадий не знаем
+	framesDir, videoStart := "", 0.0
+	printingOnly := stages == config.StagesPrinting
+	if (enabled || printingOnly) && HasFrameRecords(fullPath) {
+		var overlay *config.OverlayTemplate
+		if enabled {
+			overlay = &tpl
+		}
+		framesDir, err = prepareFrames(ctx, fullPath, overlay, printingOnly, func(p float64) { report("frames", p*0.3) })
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(framesDir)
+		videoStart = 30
+	}
+
+	err = t.AssembleVideo(ctx, job.Folder, framesDir, job.Profile, func(p float64) {
+		report("video", videoStart+p*(90-videoStart)/100)
+	})
+	if err != nil {
+		return err
+	}
+	return t.AssemblePreview(ctx, job.Folder, framesDir, job.Profile, func(p float64) { report("preview", 90+p*0.1) })
+}
+
 func (t *Timelapse) worker() {
-	ticker := time.NewTicker(time.Millisecond * time.Duration(t.core.GetConfig().Printer.EncodeWait))
+	wait := t.core.GetConfig().Printer.EncodeWait
+	ticker := time.NewTicker(time.Millisecond * time.Duration(wait))
+	// Сессии стареют и без новых печатей, срок хранения проверяется раз в час
+	retention := time.NewTicker(time.Hour)
 	for {
 		select {
 		case <-t.stop:
 			return
+		case <-retention.C:
+			t.applyRetention()
 		case <-ticker.C:
 			t.checkTimelapse()
+			// Интервал мог измениться в настройках, перезапуск ради этого не нужен
+			if current := t.core.GetConfig().Printer.EncodeWait; current != wait && current > 0 {
+				wait = current
+				ticker.Reset(time.Millisecond * time.Duration(wait))
+			}
 		}
 	}
 }
@@ -76,14 +146,11 @@ func (t *Timelapse) generateMissingPreviews() {
 			folderName := entry.Name()
 			fullPath := filepath.Join(savePath, folderName)
 
-			mp4Path := filepath.Join(fullPath, "timelapse.mp4")
-			previewPath := filepath.Join(fullPath, "preview.mp4")
-
-			if _, err := os.Stat(mp4Path); err == nil {
-				if _, err := os.Stat(previewPath); os.IsNotExist(err) {
+			if VideoFile(fullPath) != "" {
+				if PreviewFile(fullPath) == "" {
 					log.Printf("[Timelapse] Найдено видео без превью в папке: %s. Начинаю сборку...", folderName)
 
-					if err := t.AssemblePreview(folderName); err != nil {
+					if err := t.AssemblePreview(context.Background(), folderName, "", "", nil); err != nil {
 						log.Printf("[Timelapse] Не удалось сгенерировать превью для %s: %v", folderName, err)
 					} else {
 						count++
@@ -1,17 +1,161 @@
 package timelapse
 
 import (
+	"bambucam/config"
+	"bambucam/metrics"
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-func (t *Timelapse) AssembleVideo(folderName string) error {
+// profile возвращает профиль сборки по имени; пустое имя - профиль из настроек
+func (t *Timelapse) profile(name string) (config.EncodingProfile, error) {
+	cfg := t.core.GetConfig()
+	if profile, ok := cfg.EncodingProfile(name); ok {
+		return profile, nil
+	}
+	if name != "" {
+		return config.EncodingProfile{}, fmt.Errorf("профиль сборки %q не найден", name)
+	}
+	log.Printf("[Timelapse] Профиль %q не найден, используется %s", cfg.Timelapse.Profile, config.DefaultProfile)
+	profile, _ := cfg.EncodingProfile(config.DefaultProfile)
+	return profile, nil
+}
+
+// videoArgs - аргументы кодека ffmpeg для профиля
+func videoArgs(profile config.EncodingProfile) []string {
+	crf := strconv.Itoa(profile.CRF)
+	var args []string
+	switch profile.Codec {
+	case "h265":
+		// hvc1 нужен, чтобы видео открывалось на устройствах Apple
+		args = []string{"-c:v", "libx265", "-pix_fmt", "yuv420p", "-tag:v", "hvc1", "-crf", crf}
+	case "vp9":
+		args = []string{"-c:v", "libvpx-vp9", "-pix_fmt", "yuv420p", "-b:v", "0", "-crf", crf}
+	case "av1":
+		args = []string{"-c:v", "libsvtav1", "-pix_fmt", "yuv420p", "-crf", crf}
+	default:
+		args = []string{"-c:v", "libx264", "-pix_fmt", "yuv420p", "-profile:v", "high", "-level", "4.1", "-crf", crf}
+	}
+
+	if profile.Preset != "" {
+		if profile.Codec == "vp9" {
+			args = append(args, "-deadline", profile.Preset)
+		} else {
+			args = append(args, "-preset", profile.Preset)
+		}
+	}
+	if profile.Width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", profile.Width))
+	}
+	// Настройки проверяются при загрузке и сохранении, но непроверенные аргументы не должны
+	// попасть в командную строку ни при каких условиях
+	if config.CheckExtraArgs(profile.ExtraArgs) != nil {
+		return args
+	}
+	return append(args, profile.ExtraArgs...)
+}
+
+// previewParams - ускорение и ширина превью с учетом значений по умолчанию
+func previewParams(profile config.EncodingProfile) (speed, width int) {
+	speed, width = profile.PreviewSpeed, profile.PreviewWidth
+	if speed <= 0 {
+		speed = gifStep
+	}
+	if width <= 0 {
+		width = gifWidth
+	}
+	return speed, width
+}
+
+// runFFmpeg запускает ffmpeg и сообщает прогресс по числу готовых кадров из вывода -progress.
+// Результат пишется во временный файл и заменяет outputFile только при успехе, поэтому
+// отмена пересборки не портит прежнее видео.
+func runFFmpeg(ctx context.Context, args []string, outputFile string, totalFrames int, progress func(float64)) error {
+	tmpFile := strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + ".part" + filepath.Ext(outputFile)
+	defer os.Remove(tmpFile)
+
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(args, tmpFile)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		if key != "frame" || totalFrames <= 0 {
+			continue
+		}
+		if frame, err := strconv.Atoi(value); err == nil {
+			progress(min(100, float64(frame)*100/float64(totalFrames)))
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("FFmpeg error: %v\noutput:\n%s", err, stderr.String())
+	}
+	return os.Rename(tmpFile, outputFile)
+}
+
+// videoFrames возвращает число кадров видео или 0, если посчитать не удалось.
+// Поток копируется в никуда без декодирования, поэтому это быстро даже для длинного видео.
+func videoFrames(ctx context.Context, videoFile string) int {
+	out, err := exec.CommandContext(ctx, "ffmpeg", "-nostats", "-progress", "pipe:1",
+		"-i", videoFile, "-map", "0:v:0", "-c", "copy", "-f", "null", "-").Output()
+	if err != nil {
+		return 0
+	}
+	frames := 0
+	for line := range strings.Lines(string(out)) {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "frame="); ok {
+			frames, _ = strconv.Atoi(value)
+		}
+	}
+	return frames
+}
+
+// removeOther удаляет файл другого формата из names, оставшийся от прошлой сборки
+// (например, mp4 от ffmpeg после пересборки встроенным кодировщиком), чтобы отдавалось
+// и выгружалось только что собранное
+func removeOther(outputFile string, names ...string) {
+	dir, built := filepath.Split(outputFile)
+	for _, name := range names {
+		if name == built {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("[Timelapse] Не удалось удалить старый %s: %v", name, err)
+		}
+	}
+}
+
+// AssembleVideo собирает видео из кадров по профилю (пустое имя - профиль из настроек).
+// framesDir - папка с кадрами, если они не в папке сессии (кадры с наложением).
+// progress получает проценты готовности, может быть nil.
+func (t *Timelapse) AssembleVideo(ctx context.Context, folderName, framesDir, profileName string, progress func(float64)) error {
 	savePath := t.core.GetConfig().Timelapse.SavePath
 	fullPath := filepath.Join(savePath, folderName)
-	outputFile := filepath.Join(fullPath, "timelapse.mp4")
+	outputFile := filepath.Join(fullPath, VideoMP4)
+	if framesDir == "" {
+		framesDir = fullPath
+	}
 
 	if _, loading := t.assembling.LoadOrStore(folderName, true); loading {
 		return fmt.Errorf("сборка этого видео уже запущена")
@@ -26,36 +170,58 @@ func (t *Timelapse) AssembleVideo(folderName string) error {
 	if fps <= 0 {
 		fps = 20
 	}
+	profile, err := t.profile(profileName)
+	if err != nil {
+		return err
+	}
+	if progress == nil {
+		progress = func(float64) {}
+	}
 
-	cmd := exec.Command("ffmpeg",
+	if !ffmpegAvailable() {
+		outputFile = filepath.Join(fullPath, VideoAVI)
+		log.Printf("[Timelapse] ffmpeg не найден, сборка встроенным кодировщиком: %s (FPS: %d)", folderName, fps)
+		if err := encodeAVI(ctx, framesDir, outputFile, fps, progress); err != nil {
+			metrics.TimelapseFailures.Inc()
+			return fmt.Errorf("ошибка встроенного кодировщика: %w", err)
+		}
+		removeOther(outputFile, VideoMP4, VideoAVI)
+		metrics.TimelapseAssemblies.Inc()
+		log.Printf("[Timelapse] Сборка завершена: %s", outputFile)
+		return nil
+	}
+
+	args := []string{
 		"-y",
 		"-framerate", fmt.Sprintf("%d", fps),
 		"-pattern_type", "glob",
-		"-i", filepath.Join(fullPath, "layer_*.jpg"),
-		"-c:v", "libx264",
-		"-pix_fmt", "yuv420p",
-		"-profile:v", "high",
-		"-level", "4.1",
-		"-crf", "23",
-		outputFile,
-	)
+		"-i", filepath.Join(framesDir, "layer_*.jpg"),
+	}
+	args = append(args, videoArgs(profile)...)
+	frames, _ := listFrames(framesDir)
 
-	log.Printf("[Timelapse] Старт сборки: %s (FPS: %d)", folderName, fps)
+	log.Printf("[Timelapse] Старт сборки: %s (FPS: %d, профиль %s)", folderName, fps, profile.Name)
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("FFmpeg error: %v\noutput:\n%s", err, string(output))
+	if err := runFFmpeg(ctx, args, outputFile, len(frames), progress); err != nil {
+		metrics.TimelapseFailures.Inc()
+		return err
 	}
+	removeOther(outputFile, VideoMP4, VideoAVI)
 
+	metrics.TimelapseAssemblies.Inc()
 	log.Printf("[Timelapse] Сборка завершена: %s", outputFile)
 	return nil
 }
 
-func (t *Timelapse) AssemblePreview(folderName string) error {
+// AssemblePreview собирает короткое превью; скорость и ширину берет из профиля.
+// Встроенный кодировщик собирает превью из кадров framesDir, ffmpeg - из видео.
+func (t *Timelapse) AssemblePreview(ctx context.Context, folderName, framesDir, profileName string, progress func(float64)) error {
 	savePath := t.core.GetConfig().Timelapse.SavePath
 	fullPath := filepath.Join(savePath, folderName)
-	inputFile := filepath.Join(fullPath, "timelapse.mp4")
-	outputFile := filepath.Join(fullPath, "preview.mp4")
+	outputFile := filepath.Join(fullPath, PreviewMP4)
+	if framesDir == "" {
+		framesDir = fullPath
+	}
 
 	// Используем уникальный ключ блокировки для превью, чтобы не мешать сборке основного видео
 	lockKey := folderName + "_preview"
@@ -68,29 +234,62 @@ func (t *Timelapse) AssemblePreview(folderName string) error {
 		return fmt.Errorf("папка не найдена")
 	}
 
-	// Проверяем, что исходный timelapse.mp4 на месте
-	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
+	profile, err := t.profile(profileName)
+	if err != nil {
+		return err
+	}
+	speed, width := previewParams(profile)
+	if progress == nil {
+		progress = func(float64) {}
+	}
+
+	// Без ffmpeg превью собирается прямо из кадров
+	if !ffmpegAvailable() {
+		fps := t.core.GetConfig().Timelapse.Fps
+		if fps <= 0 {
+			fps = 20
+		}
+		outputFile = filepath.Join(fullPath, PreviewGIF)
+		log.Printf("[Timelapse] Старт сборки превью встроенным кодировщиком: %s", folderName)
+		if err := encodeGIF(ctx, framesDir, outputFile, fps, speed, width, progress); err != nil {
+			return fmt.Errorf("ошибка встроенного кодировщика превью: %w", err)
+		}
+		removeOther(outputFile, PreviewMP4, PreviewGIF)
+		log.Printf("[Timelapse] Сборка превью завершена: %s", outputFile)
+		return nil
+	}
+
+	// Проверяем, что исходное видео на месте
+	video := VideoFile(fullPath)
+	if video == "" {
 		return fmt.Errorf("исходное видео timelapse.mp4 не найдено")
 	}
+	inputFile := filepath.Join(fullPath, video)
 
 	// Команда сборки оптимизированного превью из оригинального видео
-	cmd := exec.Command("ffmpeg",
+	args := []string{
 		"-y",
 		"-i", inputFile,
-		"-vf", "select='not(mod(n,5))',setpts=0.2*PTS,scale=320:-2",
+		"-vf", fmt.Sprintf("select='not(mod(n,%d))',setpts=PTS/%d,scale=%d:-2", speed, speed, width),
 		"-c:v", "libx264",
 		"-pix_fmt", "yuv420p",
 		"-preset", "ultrafast",
 		"-crf", "32",
-		outputFile,
-	)
+	}
+	// В превью попадает каждый speed-й кадр видео. Кадры считаются в самом видео: в папке
+	// их может быть больше (отбор стадий) или меньше (удаление после сборки)
+	total := videoFrames(ctx, inputFile)
+	if total == 0 {
+		frames, _ := listFrames(framesDir)
+		total = len(frames)
+	}
 
 	log.Printf("[Timelapse] Старт сборки превью: %s", folderName)
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("FFmpeg preview error: %v\noutput:\n%s", err, string(output))
+	if err := runFFmpeg(ctx, args, outputFile, (total+speed-1)/speed, progress); err != nil {
+		return fmt.Errorf("FFmpeg preview: %w", err)
 	}
+	removeOther(outputFile, PreviewMP4, PreviewGIF)
 
 	log.Printf("[Timelapse] Сборка превью завершена: %s", outputFile)
 	return nil
@@ -0,0 +1,79 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// localBackend копирует файлы в папку на этом же устройстве: подключенный диск, NAS по SMB/NFS
+type localBackend struct{}
+
+func (localBackend) Put(ctx context.Context, dir, name, localPath string, size int64, sum string) error {
+	dir = filepath.FromSlash(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Пишем во временный файл и переименовываем только после сверки суммы,
+	// чтобы в папке назначения не оставалось недописанных файлов
+	dst := filepath.Join(dir, name)
+	tmp := dst + ".part"
+	if err := copyFile(ctx, localPath, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := verifyFile(ctx, tmp, sum); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func (localBackend) Close() error { return nil }
+
+func verifyFile(ctx context.Context, name, sum string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return verifySum(ctx, f, sum)
+}
+
+func copyFile(ctx context.Context, src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, contextReader{ctx, in}); err != nil {
+		out.Close()
+		return err
+	}
+	// Без Sync данные на сетевом или USB-диске могут потеряться при отключении питания
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// contextReader прерывает чтение, когда ctx отменен, - так долгая передача не держит отмену
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
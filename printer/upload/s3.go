@@ -0,0 +1,190 @@
+package upload
+
+import (
+	"bambucam/config"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultS3Region = "us-east-1"
+
+// s3Backend выгружает файлы в S3-совместимое хранилище (AWS S3, MinIO, Backblaze B2, Yandex
+// Object Storage). Адресация path-style (url/bucket/key) - ее поддерживают все, включая MinIO.
+// Подпись AWS Signature V4 считается здесь же, чтобы не тянуть SDK ради одного PUT.
+type s3Backend struct {
+	base   *url.URL
+	target config.UploadTarget
+	region string
+	client *http.Client
+}
+
+func newS3(target config.UploadTarget) (*s3Backend, error) {
+	base, err := url.Parse(strings.TrimRight(target.URL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	region := target.Region
+	if region == "" {
+		region = defaultS3Region
+	}
+	return &s3Backend{
+		base:   base,
+		target: target,
+		region: region,
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 2 * time.Minute,
+		}},
+	}, nil
+}
+
+func (s *s3Backend) Put(ctx context.Context, dir, name, localPath string, size int64, sum string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	key := strings.TrimPrefix(path.Join(dir, name), "/")
+	u := *s.base
+	// RawPath задает точное кодирование пути: оно должно совпасть с тем, что вошло в подпись
+	u.RawPath = s.base.EscapedPath() + "/" + s3Escape(s.target.Bucket) + "/" + s3Escape(key)
+	u.Path = s.base.Path + "/" + s.target.Bucket + "/" + key
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), contextReader{ctx, f})
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType(name))
+	// Хранилище само сверяет содержимое с этой суммой и отклоняет запрос при расхождении,
+	// поэтому отдельная проверка после выгрузки не нужна
+	s.sign(req, sum, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("PUT: %s %s", resp.Status, s3ErrorCode(body))
+	}
+	return nil
+}
+
+func (s *s3Backend) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// sign подписывает запрос по AWS Signature V4: в подпись входят host и все заголовки запроса
+func (s *s3Backend) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(req.URL.EscapedPath() + "\n")
+	canonical.WriteString(s3Query(req.URL.Query()) + "\n")
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical.WriteString("\n" + signed + "\n" + payloadHash)
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.target.SecretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.target.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape кодирует путь так, как его кодирует подпись V4: без изменений остаются только
+// латиница, цифры, "-._~" и разделитель "/"
+func s3Escape(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query - параметры запроса в каноническом виде, отсортированные по имени
+func s3Query(query url.Values) string {
+	var parts []string
+	for name, values := range query {
+		for _, value := range values {
+			parts = append(parts, s3Escape(name)+"="+strings.ReplaceAll(s3Escape(value), "/", "%2F"))
+		}
+	}
+	slices.Sort(parts)
+	return strings.Join(parts, "&")
+}
+
+// s3ErrorCode достает код ошибки из XML-ответа S3, чтобы не выводить его целиком
+func s3ErrorCode(body []byte) string {
+	s := string(body)
+	start := strings.Index(s, "<Code>")
+	end := strings.Index(s, "</Code>")
+	if start < 0 || end < start {
+		return ""
+	}
+	return s[start+len("<Code>") : end]
+}
+
+func contentType(name string) string {
+	switch path.Ext(name) {
+	case ".mp4":
+		return "video/mp4"
+	case ".avi":
+		return "video/x-msvideo"
+	case ".gif":
+		return "image/gif"
+	case ".json":
+		return "application/json"
+	}
+	return "text/plain; charset=utf-8"
+}
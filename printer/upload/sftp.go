@@ -0,0 +1,157 @@
+package upload
+
+import (
+	"bambucam/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const posixRename = "posix-rename@openssh.com"
+
+// sftpBackend выгружает файлы по SFTP. Сервер проверяется по отпечатку ключа из host_key.
+type sftpBackend struct {
+	client *ssh.Client
+	sftp   *sftp.Client
+	stop   func() bool
+}
+
+func dialSFTP(ctx context.Context, target config.UploadTarget) (*sftpBackend, error) {
+	// Неизвестному серверу учетные данные не отправляются никогда
+	if target.HostKey == "" {
+		return nil, errors.New("не задан host_key: подключение к серверу без проверки ключа запрещено")
+	}
+
+	var auth []ssh.AuthMethod
+	if target.KeyFile != "" {
+		key, err := os.ReadFile(target.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) && target.Password != "" {
+			// Ключ зашифрован: пароль места назначения считается парольной фразой ключа
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(target.Password))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ключ %s: %w", target.KeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if target.Password != "" {
+		auth = append(auth, ssh.Password(target.Password))
+	}
+
+	hostKey := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if got := ssh.FingerprintSHA256(key); got != target.HostKey {
+			return fmt.Errorf("ключ сервера %s не совпадает с host_key", got)
+		}
+		return nil
+	}
+
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", target.URL)
+	if err != nil {
+		return nil, err
+	}
+	// Отмена ctx закрывает соединение и прерывает зависшую передачу
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, target.URL, &ssh.ClientConfig{
+		User:            target.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+
+	// Запись и чтение несколькими запросами сразу: иначе скорость упирается в задержку сети
+	sc, err := sftp.NewClient(client, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		stop()
+		client.Close()
+		return nil, fmt.Errorf("подсистема sftp: %w", err)
+	}
+	return &sftpBackend{client: client, sftp: sc, stop: stop}, nil
+}
+
+func (b *sftpBackend) Put(ctx context.Context, dir, name, localPath string, size int64, sum string) error {
+	// Ошибку создания папки вернет и открытие файла, а папка может уже существовать
+	b.sftp.MkdirAll(dir)
+
+	dst := path.Join(dir, name)
+	tmp := dst + ".part"
+	if err := b.write(ctx, localPath, tmp); err != nil {
+		b.sftp.Remove(tmp)
+		return err
+	}
+	if err := b.verify(ctx, tmp, size, sum); err != nil {
+		b.sftp.Remove(tmp)
+		return err
+	}
+
+	if _, ok := b.sftp.HasExtension(posixRename); ok {
+		return b.sftp.PosixRename(tmp, dst)
+	}
+	// Обычный RENAME не заменяет существующий файл, поэтому старую копию удаляем заранее
+	b.sftp.Remove(dst)
+	return b.sftp.Rename(tmp, dst)
+}
+
+func (b *sftpBackend) write(ctx context.Context, localPath, remotePath string) error {
+	in, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := b.sftp.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, contextReader{ctx, in}); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// verify читает записанный файл обратно и сверяет его размер и сумму с оригиналом
+func (b *sftpBackend) verify(ctx context.Context, remotePath string, size int64, sum string) error {
+	f, err := b.sftp.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Size() != size {
+		return fmt.Errorf("размер на сервере %d вместо %d", st.Size(), size)
+	}
+	return verifySum(ctx, f, sum)
+}
+
+func (b *sftpBackend) Close() error {
+	if b.stop != nil {
+		b.stop()
+	}
+	b.sftp.Close()
+	return b.client.Close()
+}
@@ -0,0 +1,165 @@
+package upload
+
+import (
+	"bambucam/config"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSFTPServer запускает SSH-сервер с подсистемой sftp, пускающий pi/secret.
+// Возвращает адрес и отпечаток ключа сервера.
+func startSFTPServer(t *testing.T) (string, string) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "pi" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, cfg)
+		}
+	}()
+	return ln.Addr().String(), ssh.FingerprintSHA256(signer.PublicKey())
+}
+
+func serveSFTP(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					return
+				}
+				server.Serve()
+				server.Close()
+				return
+			}
+		}()
+	}
+}
+
+func TestSFTPPut(t *testing.T) {
+	addr, fingerprint := startSFTPServer(t)
+	local := t.TempDir()
+	remote := filepath.ToSlash(filepath.Join(t.TempDir(), "timelapse"))
+
+	// Больше одного блока записи, чтобы запросы шли параллельно
+	video := strings.Repeat("frame data ", 20000)
+	src := filepath.Join(local, "timelapse.mp4")
+	os.WriteFile(src, []byte(video), 0644)
+	sum, _ := fileSum(src)
+
+	target := config.UploadTarget{Type: config.UploadSFTP, URL: addr, Username: "pi", Password: "secret", HostKey: fingerprint}
+	b, err := dialSFTP(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	dir := remote + "/cube_1"
+	// Вторая выгрузка заменяет уже лежащую копию
+	for range 2 {
+		if err := b.Put(context.Background(), dir, "timelapse.mp4", src, int64(len(video)), sum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := os.ReadFile(filepath.Join(filepath.FromSlash(dir), "timelapse.mp4"))
+	if err != nil || string(got) != video {
+		t.Fatalf("remote copy: %d bytes, %v", len(got), err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.FromSlash(dir), "timelapse.mp4.part")); !os.IsNotExist(err) {
+		t.Errorf("temporary file left: %v", err)
+	}
+
+	// Несовпадение суммы - ошибка, недописанный файл удаляется, прежняя копия не трогается
+	err = b.Put(context.Background(), dir, "timelapse.mp4", src, int64(len(video)), strings.Repeat("0", 64))
+	if err == nil || !strings.Contains(err.Error(), "контрольная сумма") {
+		t.Fatalf("Put with wrong sum = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.FromSlash(dir), "timelapse.mp4.part")); !os.IsNotExist(err) {
+		t.Errorf("temporary file left after mismatch: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(filepath.FromSlash(dir), "timelapse.mp4")); string(got) != video {
+		t.Error("previous copy changed after failed upload")
+	}
+}
+
+func TestDialSFTPRejects(t *testing.T) {
+	addr, fingerprint := startSFTPServer(t)
+	tests := []struct {
+		name    string
+		change  func(target *config.UploadTarget)
+		message string
+	}{
+		{"no host key", func(target *config.UploadTarget) { target.HostKey = "" }, "host_key"},
+		{"other host key", func(target *config.UploadTarget) { target.HostKey = "SHA256:AAAA" }, "не совпадает с host_key"},
+		{"wrong password", func(target *config.UploadTarget) { target.Password = "guess" }, "unable to authenticate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := config.UploadTarget{Type: config.UploadSFTP, URL: addr, Username: "pi", Password: "secret", HostKey: fingerprint}
+			tt.change(&target)
+			b, err := dialSFTP(context.Background(), target)
+			if err == nil {
+				b.Close()
+				t.Fatal("connection succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("error = %v, want %q", err, tt.message)
+			}
+		})
+	}
+}
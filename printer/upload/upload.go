@@ -0,0 +1,422 @@
+package upload
+
+import (
+	"bambucam/config"
+	"bambucam/printer/events"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatusFile - состояние выгрузок в папке сессии, SumsFile - контрольные суммы выгруженных файлов
+// в формате sha256sum, он выгружается последним и по нему можно проверить копию
+const (
+	StatusFile = "upload.json"
+	SumsFile   = "SHA256SUMS"
+)
+
+// sessionFiles - что выгружается из папки сессии, если есть: видео, превью и описание.
+// Исходные кадры остаются на устройстве.
+var sessionFiles = []string{"timelapse.mp4", "timelapse.avi", "preview.mp4", "preview.gif", "info.json"}
+
+// uploadTimeout ограничивает одну попытку выгрузки сессии в одно место
+const uploadTimeout = 30 * time.Minute
+
+// maxRetryDelay - предел паузы между повторами, как бы ни росла она удвоением
+const maxRetryDelay = time.Hour
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusUploading Status = "uploading"
+	StatusDone      Status = "done"
+	StatusFailed    Status = "failed"
+)
+
+var (
+	ErrNoTargets     = errors.New("места выгрузки не настроены")
+	ErrUnknownTarget = errors.New("неизвестное место выгрузки")
+)
+
+// TargetStatus - состояние выгрузки сессии в одно место назначения
+type TargetStatus struct {
+	Status   Status `json:"status" enum:"pending,uploading,done,failed"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+	// Files - выгруженные файлы и их SHA-256: при повторе совпадающие не выгружаются заново
+	Files     map[string]string `json:"files,omitempty"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// ReadStatus читает состояние выгрузок сессии по именам мест назначения
+func ReadStatus(fullPath string) map[string]TargetStatus {
+	statuses := map[string]TargetStatus{}
+	data, err := os.ReadFile(filepath.Join(fullPath, StatusFile))
+	if err != nil {
+		return statuses
+	}
+	json.Unmarshal(data, &statuses)
+	return statuses
+}
+
+// Source - часть printer.Core, нужная выгрузке (сам Core импортировать нельзя из-за цикла)
+type Source interface {
+	Events() *events.Bus
+	GetConfig() *config.Config
+}
+
+type task struct {
+	folder    string
+	target    string
+	notBefore time.Time
+}
+
+// Uploader копирует готовые таймлапсы в места назначения из настроек upload.targets по
+// одному, с повторами при ошибках. Состояние хранится в upload.json каждой сессии, поэтому
+// незаконченные выгрузки продолжаются после перезапуска. Живет все время работы приложения.
+type Uploader struct {
+	core Source
+
+	mu      sync.Mutex
+	tasks   []task
+	current string
+	wake    chan struct{}
+	started bool
+}
+
+func NewUploader(core Source) *Uploader {
+	return &Uploader{core: core, wake: make(chan struct{}, 1)}
+}
+
+// Start запускает выгрузку и возвращает в очередь то, что не успело выгрузиться до перезапуска
+func (u *Uploader) Start() {
+	u.mu.Lock()
+	if u.started {
+		u.mu.Unlock()
+		return
+	}
+	u.started = true
+	u.mu.Unlock()
+
+	savePath := u.core.GetConfig().Timelapse.SavePath
+	entries, _ := os.ReadDir(savePath)
+	resumed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		for name, st := range ReadStatus(filepath.Join(savePath, entry.Name())) {
+			if st.Status == StatusPending || st.Status == StatusUploading {
+				u.push(task{folder: entry.Name(), target: name})
+				resumed++
+			}
+		}
+	}
+	if resumed > 0 {
+		log.Printf("[Upload] Продолжаю незавершенные выгрузки: %d", resumed)
+	}
+
+	go u.worker()
+}
+
+// Enqueue ставит сессию в очередь на выгрузку в указанные места, без имен - во все включенные.
+// Возвращает имена мест, куда она будет выгружена.
+func (u *Uploader) Enqueue(folder string, targets ...string) ([]string, error) {
+	cfg := u.core.GetConfig()
+	if len(targets) == 0 {
+		for _, target := range cfg.UploadTargets() {
+			targets = append(targets, target.Name)
+		}
+		if len(targets) == 0 {
+			return nil, ErrNoTargets
+		}
+	}
+	for _, name := range targets {
+		if target, ok := cfg.UploadTarget(name); !ok || target.Disabled {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTarget, name)
+		}
+	}
+
+	for _, name := range targets {
+		u.setStatus(folder, name, func(st *TargetStatus) {
+			st.Status = StatusPending
+			st.Attempts = 0
+			st.Error = ""
+		})
+		u.push(task{folder: folder, target: name})
+	}
+	log.Printf("[Upload] %s поставлен в очередь выгрузки: %s", folder, strings.Join(targets, ", "))
+	return targets, nil
+}
+
+// Busy сообщает, что сессия ждет выгрузки или выгружается: удалять ее папку сейчас нельзя
+func (u *Uploader) Busy(folder string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.current == folder || slices.ContainsFunc(u.tasks, func(t task) bool { return t.folder == folder })
+}
+
+// push добавляет задачу, заменяя уже ожидающую для той же пары сессия-место
+func (u *Uploader) push(t task) {
+	u.mu.Lock()
+	u.tasks = slices.DeleteFunc(u.tasks, func(old task) bool { return old.folder == t.folder && old.target == t.target })
+	u.tasks = append(u.tasks, t)
+	u.mu.Unlock()
+
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next забирает первую задачу, время которой пришло, или сообщает, сколько ждать следующую
+func (u *Uploader) next() (task, time.Duration, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	wait := time.Duration(-1)
+	for i, t := range u.tasks {
+		if !t.notBefore.After(now) {
+			u.tasks = slices.Delete(u.tasks, i, i+1)
+			u.current = t.folder
+			return t, 0, true
+		}
+		if d := t.notBefore.Sub(now); wait < 0 || d < wait {
+			wait = d
+		}
+	}
+	return task{}, wait, false
+}
+
+func (u *Uploader) worker() {
+	for {
+		t, wait, ok := u.next()
+		if ok {
+			u.process(t)
+			u.mu.Lock()
+			u.current = ""
+			u.mu.Unlock()
+			continue
+		}
+
+		if wait < 0 {
+			<-u.wake
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-u.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// process выполняет одну попытку выгрузки и при ошибке планирует повтор
+func (u *Uploader) process(t task) {
+	cfg := u.core.GetConfig()
+	target, ok := cfg.UploadTarget(t.target)
+	if !ok || target.Disabled {
+		u.setStatus(t.folder, t.target, func(st *TargetStatus) {
+			st.Status = StatusFailed
+			st.Error = "место выгрузки убрано из настроек"
+		})
+		return
+	}
+	fullPath := filepath.Join(cfg.Timelapse.SavePath, t.folder)
+	if _, err := os.Stat(fullPath); err != nil {
+		// Папку удалили, пока выгрузка ждала очереди: записать состояние уже некуда
+		log.Printf("[Upload] %s: папка сессии удалена, выгрузка в %s отменена", t.folder, t.target)
+		return
+	}
+
+	var attempt int
+	var uploaded map[string]string
+	u.setStatus(t.folder, t.target, func(st *TargetStatus) {
+		st.Status = StatusUploading
+		st.Attempts++
+		attempt = st.Attempts
+		uploaded = st.Files
+	})
+	log.Printf("[Upload] Выгрузка %s в %s (%s), попытка %d", t.folder, target.Name, target.Destination(), attempt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	err := u.upload(ctx, target, t.folder, fullPath, uploaded)
+
+	if err == nil {
+		u.setStatus(t.folder, t.target, func(st *TargetStatus) {
+			st.Status = StatusDone
+			st.Error = ""
+		})
+		log.Printf("[Upload] %s выгружен в %s", t.folder, target.Name)
+		return
+	}
+
+	if attempt > cfg.Upload.Retries {
+		u.setStatus(t.folder, t.target, func(st *TargetStatus) {
+			st.Status = StatusFailed
+			st.Error = err.Error()
+		})
+		log.Printf("[Upload] Не удалось выгрузить %s в %s: %v", t.folder, target.Name, err)
+		return
+	}
+
+	delay := min(time.Duration(max(1, cfg.Upload.RetryDelay))*time.Second<<(attempt-1), maxRetryDelay)
+	u.setStatus(t.folder, t.target, func(st *TargetStatus) {
+		st.Status = StatusPending
+		st.Error = err.Error()
+	})
+	log.Printf("[Upload] Ошибка выгрузки %s в %s: %v. Повтор через %s", t.folder, target.Name, err, delay)
+	t.notBefore = time.Now().Add(delay)
+	u.push(t)
+}
+
+// upload выгружает файлы сессии и SHA256SUMS. Файлы, уже выгруженные с той же суммой, пропускаются.
+func (u *Uploader) upload(ctx context.Context, target config.UploadTarget, folder, fullPath string, uploaded map[string]string) error {
+	type file struct {
+		name string
+		size int64
+		sum  string
+	}
+	var files []file
+	var sums strings.Builder
+	for _, name := range sessionFiles {
+		st, err := os.Stat(filepath.Join(fullPath, name))
+		if err != nil {
+			continue
+		}
+		sum, err := fileSum(filepath.Join(fullPath, name))
+		if err != nil {
+			return err
+		}
+		files = append(files, file{name, st.Size(), sum})
+		fmt.Fprintf(&sums, "%s  %s\n", sum, name)
+	}
+	if len(files) == 0 {
+		return errors.New("в папке нет видео для выгрузки")
+	}
+
+	// Список сумм лежит и рядом с оригиналом, чтобы копию можно было сверить в любой момент
+	sumsPath := filepath.Join(fullPath, SumsFile)
+	if err := os.WriteFile(sumsPath, []byte(sums.String()), 0644); err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(sums.String()))
+	files = append(files, file{SumsFile, int64(sums.Len()), hex.EncodeToString(digest[:])})
+
+	b, err := openBackend(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	dir := path.Join(filepath.ToSlash(target.Path), folder)
+	for _, f := range files {
+		if uploaded[f.name] == f.sum {
+			continue
+		}
+		if err := b.Put(ctx, dir, f.name, filepath.Join(fullPath, f.name), f.size, f.sum); err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		u.setStatus(folder, target.Name, func(st *TargetStatus) {
+			if st.Files == nil {
+				st.Files = map[string]string{}
+			}
+			st.Files[f.name] = f.sum
+		})
+	}
+	return nil
+}
+
+// statusMutex защищает upload.json всех сессий от одновременной записи
+var statusMutex sync.Mutex
+
+// setStatus меняет состояние выгрузки сессии в upload.json и рассылает событие
+func (u *Uploader) setStatus(folder, target string, change func(st *TargetStatus)) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	fullPath := filepath.Join(u.core.GetConfig().Timelapse.SavePath, folder)
+	statuses := ReadStatus(fullPath)
+	st := statuses[target]
+	change(&st)
+	st.UpdatedAt = time.Now()
+	statuses[target] = st
+
+	data, _ := json.MarshalIndent(statuses, "", " ")
+	if err := os.WriteFile(filepath.Join(fullPath, StatusFile), data, 0644); err != nil {
+		log.Printf("[Upload] Ошибка записи %s в %s: %v", StatusFile, folder, err)
+	}
+
+	u.core.Events().Publish(events.TypeUpload, map[string]any{
+		"folder":   folder,
+		"target":   target,
+		"status":   st.Status,
+		"attempts": st.Attempts,
+		"error":    st.Error,
+	})
+}
+
+func fileSum(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifySum сверяет SHA-256 копии, прочитанной обратно из хранилища, с суммой оригинала
+func verifySum(ctx context.Context, r io.Reader, sum string) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, contextReader{ctx, r}); err != nil {
+		return fmt.Errorf("чтение копии: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("контрольная сумма копии не совпадает: %s", got)
+	}
+	return nil
+}
+
+// backend - хранилище, куда выгружаются файлы
+type backend interface {
+	// Put выгружает локальный файл под именем name в папку dir (через "/", относительно корня
+	// хранилища) и сверяет копию с SHA-256 оригинала sum
+	Put(ctx context.Context, dir, name, localPath string, size int64, sum string) error
+	Close() error
+}
+
+func openBackend(ctx context.Context, target config.UploadTarget) (backend, error) {
+	switch target.Type {
+	case config.UploadLocal:
+		return localBackend{}, nil
+	case config.UploadWebDAV:
+		return newWebDAV(target)
+	case config.UploadSFTP:
+		return dialSFTP(ctx, target)
+	case config.UploadS3:
+		return newS3(target)
+	}
+	return nil, fmt.Errorf("неизвестный тип хранилища %q", target.Type)
+}
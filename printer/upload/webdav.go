@@ -0,0 +1,150 @@
+package upload
+
+import (
+	"bambucam/config"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// webdavBackend выгружает файлы на WebDAV (Nextcloud, ownCloud, Synology, Apache mod_dav)
+type webdavBackend struct {
+	base   *url.URL
+	target config.UploadTarget
+	client *http.Client
+	// made - папки, уже созданные за эту выгрузку
+	made map[string]bool
+}
+
+func newWebDAV(target config.UploadTarget) (*webdavBackend, error) {
+	base, err := url.Parse(strings.TrimRight(target.URL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return &webdavBackend{
+		base:   base,
+		target: target,
+		// Таймаут только на ответ сервера: сама передача большого видео может идти долго
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 2 * time.Minute,
+		}},
+		made: map[string]bool{},
+	}, nil
+}
+
+// url строит адрес ресурса, экранируя каждую часть пути
+func (w *webdavBackend) url(parts ...string) string {
+	u := *w.base
+	for _, part := range parts {
+		for _, segment := range strings.Split(part, "/") {
+			if segment != "" {
+				u.Path += "/" + segment
+			}
+		}
+	}
+	u.RawPath = ""
+	return u.String()
+}
+
+// do выполняет запрос, тело ответа читается и отбрасывается
+func (w *webdavBackend) do(ctx context.Context, method, target string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	resp, err := w.send(ctx, method, target, body, size, header)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp, nil
+}
+
+// send выполняет запрос и возвращает ответ с открытым телом
+func (w *webdavBackend) send(ctx context.Context, method, target string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if w.target.Username != "" {
+		req.SetBasicAuth(w.target.Username, w.target.Password)
+	}
+	return w.client.Do(req)
+}
+
+// mkdirs создает папку dir по одному уровню: MKCOL не создает промежуточные папки
+func (w *webdavBackend) mkdirs(ctx context.Context, dir string) error {
+	var current string
+	for _, segment := range strings.Split(dir, "/") {
+		if segment == "" {
+			continue
+		}
+		current += "/" + segment
+		if w.made[current] {
+			continue
+		}
+		resp, err := w.do(ctx, "MKCOL", w.url(current)+"/", nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		// 405 - папка уже существует
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("создание папки %s: %s", current, resp.Status)
+		}
+		w.made[current] = true
+	}
+	return nil
+}
+
+func (w *webdavBackend) Put(ctx context.Context, dir, name, localPath string, size int64, sum string) error {
+	if err := w.mkdirs(ctx, dir); err != nil {
+		return err
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	target := w.url(dir, name)
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	// Nextcloud и ownCloud сохраняют и проверяют эту сумму, остальные серверы ее игнорируют
+	header.Set("OC-Checksum", "SHA256:"+sum)
+	resp, err := w.do(ctx, http.MethodPut, target, contextReader{ctx, f}, size, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("PUT: %s", resp.Status)
+	}
+
+	// WebDAV не сообщает сумму файла на сервере, поэтому копия скачивается обратно и сверяется
+	resp, err = w.send(ctx, http.MethodGet, target, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("проверка файла: %s", resp.Status)
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength != size {
+		return fmt.Errorf("размер на сервере %d вместо %d", resp.ContentLength, size)
+	}
+	return verifySum(ctx, resp.Body, sum)
+}
+
+func (w *webdavBackend) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,112 @@
+package upload
+
+import (
+	"bambucam/config"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// davServer - WebDAV в памяти: MKCOL, PUT и GET. corrupt портит сохраняемые файлы.
+type davServer struct {
+	mu      sync.Mutex
+	dirs    map[string]bool
+	files   map[string][]byte
+	corrupt bool
+}
+
+func (d *davServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	user, password, _ := r.BasicAuth()
+	if user != "pi" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimSuffix(r.URL.Path, "/")
+	parent := name[:strings.LastIndex(name, "/")]
+	switch r.Method {
+	case "MKCOL":
+		if d.dirs[name] {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		d.dirs[name] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		if parent != "" && !d.dirs[parent] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if d.corrupt {
+			data[0] ^= 0xff
+		}
+		d.files[name] = data
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		data, ok := d.files[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestWebDAVPut(t *testing.T) {
+	dav := &davServer{dirs: map[string]bool{}, files: map[string][]byte{}}
+	server := httptest.NewServer(dav)
+	defer server.Close()
+
+	src := filepath.Join(t.TempDir(), "timelapse.mp4")
+	os.WriteFile(src, []byte("video"), 0644)
+	sum, _ := fileSum(src)
+
+	tests := []struct {
+		name     string
+		password string
+		corrupt  bool
+		err      string
+	}{
+		{name: "uploaded", password: "secret"},
+		{name: "copy differs", password: "secret", corrupt: true, err: "контрольная сумма"},
+		{name: "wrong password", password: "guess", err: "401"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dav.mu.Lock()
+			dav.corrupt = tt.corrupt
+			dav.mu.Unlock()
+
+			w, err := newWebDAV(config.UploadTarget{Type: config.UploadWebDAV, URL: server.URL + "/dav/", Username: "pi", Password: tt.password})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			err = w.Put(context.Background(), "timelapse/cube 1", "timelapse.mp4", src, 5, sum)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := string(dav.files["/dav/timelapse/cube 1/timelapse.mp4"]); got != "video" {
+					t.Errorf("stored %q", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Put = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
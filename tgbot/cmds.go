@@ -1,6 +1,7 @@
 package tgbot
 
 import (
+	"bambucam/auth"
 	"bytes"
 	"fmt"
 	"strings"
@@ -16,6 +17,8 @@ func (t *Telegram) setupCommands(bot *tele.Bot) {
 	t.bot.Handle("/light", t.toggleLight)
 	t.bot.Handle("/timelapse", t.sendTimelapse)
 	t.bot.Handle(&tele.InlineButton{Unique: "show_tl"}, t.handleTimelapseCallback)
+	t.bot.Handle("/assemble", t.assembleTimelapse)
+	t.bot.Handle(&tele.InlineButton{Unique: "assemble_tl"}, t.handleAssembleCallback)
 }
 
 func (t *Telegram) startBot(c tele.Context) error {
@@ -50,6 +53,7 @@ func (t *Telegram) toggleLight(c tele.Context) error {
 	}
 
 	t.core.ToggleLight()
+	t.audit(c, auth.ActionCommand, "light")
 
 	if currentMode == "on" {
 		currentMode = "Выкл"
@@ -1,7 +1,10 @@
 package tgbot
 
 import (
+	"bambucam/auth"
 	"bambucam/printer"
+	"bambucam/printer/events"
+	"fmt"
 	"log"
 	"time"
 
@@ -12,6 +15,8 @@ import (
 type Telegram struct {
 	core printer.Core
 	bot  *tele.Bot
+	// stopAudit останавливает пересылку аудита
+	stopAudit chan struct{}
 }
 
 func NewTelegram(core printer.Core) *Telegram {
@@ -51,11 +56,21 @@ func (t *Telegram) Start() {
 
 	go bot.Start()
 
+	if cfg.AuditNotify {
+		t.forwardAudit()
+	}
+
 	t.SendMessageAll("Bambu Monitor стартовал")
 }
 
 func (t *Telegram) Stop() {
-	t.bot.Stop()
+	if t.stopAudit != nil {
+		close(t.stopAudit)
+	}
+	// Бот не создается, если не указан токен или админы
+	if t.bot != nil {
+		t.bot.Stop()
+	}
 }
 
 func (t *Telegram) SendMessageAll(message string) {
@@ -68,3 +83,81 @@ func (t *Telegram) SendMessageAll(message string) {
 		}
 	}
 }
+
+// forwardAudit пересылает админам записи журнала аудита. Действия, выполненные
+// через самого бота, не пересылаются - админ и так о них знает.
+// Записи копятся в очереди и отправляются отдельно: медленная отправка в Telegram не
+// задерживает чтение подписки, поэтому шина ее не закрывает и записи не теряются.
+func (t *Telegram) forwardAudit() {
+	stop := make(chan struct{})
+	t.stopAudit = stop
+
+	send := make(chan auth.AuditEntry)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case entry := <-send:
+				t.SendMessageAll(formatAudit(entry))
+			}
+		}
+	}()
+
+	ch, unsubscribe := t.core.Events().Subscribe(32, events.TypeAudit)
+	go func() {
+		defer func() { unsubscribe() }()
+		var queue []auth.AuditEntry
+		for {
+			// Пока очередь пуста, next равен nil и отправка в select не выбирается
+			var next chan auth.AuditEntry
+			var head auth.AuditEntry
+			if len(queue) > 0 {
+				next, head = send, queue[0]
+			}
+
+			select {
+			case <-stop:
+				return
+			case next <- head:
+				queue = queue[1:]
+			case evt, ok := <-ch:
+				if !ok {
+					log.Println("[Telegram] Пропущены записи аудита, переподписка")
+					ch, unsubscribe = t.core.Events().Subscribe(32, events.TypeAudit)
+					continue
+				}
+				if entry, ok := evt.Data.(auth.AuditEntry); ok && entry.Source != auth.SourceTelegram {
+					queue = append(queue, entry)
+				}
+			}
+		}
+	}()
+}
+
+func formatAudit(entry auth.AuditEntry) string {
+	icon := "🔐"
+	if !entry.Success {
+		icon = "⚠️"
+	}
+	msg := fmt.Sprintf("%s Аудит: %s\nПользователь: %s\nИсточник: %s, IP: %s", icon, entry.Action, entry.User, entry.Source, entry.IP)
+	if entry.Details != "" {
+		msg += "\n" + entry.Details
+	}
+	return msg
+}
+
+// audit записывает команду, выполненную через бота
+func (t *Telegram) audit(c tele.Context, action, details string) {
+	user := fmt.Sprint(c.Sender().ID)
+	if c.Sender().Username != "" {
+		user = "@" + c.Sender().Username
+	}
+	t.core.Audit().Record(auth.AuditEntry{
+		Action:  action,
+		Source:  auth.SourceTelegram,
+		User:    user,
+		Details: details,
+		Success: true,
+	})
+}
@@ -1,8 +1,14 @@
 package tgbot
 
 import (
+	"bambucam/auth"
+	"bambucam/config"
+	"bambucam/printer/jobs"
+	"bambucam/printer/timelapse"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"sort"
@@ -80,14 +86,17 @@ func (t *Telegram) sendTimelapseByFolder(c tele.Context, folderName string) erro
 	savePath := cfg.SavePath
 
 	fullPath := filepath.Join(savePath, folderName)
-	mp4Path := filepath.Join(fullPath, "timelapse.mp4")
-	previewPath := filepath.Join(fullPath, "preview.mp4")
+	videoFile := timelapse.VideoFile(fullPath)
+	if videoFile == "" {
+		return c.Send(fmt.Sprintf("❌ Видеофайл в папке %s отсутствует или еще не собран.", folderName))
+	}
+	mp4Path := filepath.Join(fullPath, videoFile)
+	previewFile := timelapse.PreviewFile(fullPath)
+	previewPath := filepath.Join(fullPath, previewFile)
 	infoPath := filepath.Join(fullPath, "info.json")
 
 	mp4St, err := os.Stat(mp4Path)
-	if os.IsNotExist(err) {
-		return c.Send(fmt.Sprintf("❌ Видеофайл в папке %s отсутствует или еще не собран.", folderName))
-	} else if err != nil {
+	if err != nil {
 		return c.Send("❌ Ошибка при чтении файла: " + err.Error())
 	}
 
@@ -104,10 +113,15 @@ func (t *Telegram) sendTimelapseByFolder(c tele.Context, folderName string) erro
 	serverHost := t.core.GetConfig().Web.Hostname
 
 	if !strings.HasPrefix(serverHost, "http://") && !strings.HasPrefix(serverHost, "https://") {
-		serverHost = "http://" + serverHost
+		if t.core.GetConfig().Web.TLSMode != "" {
+			serverHost = "https://" + serverHost
+		} else {
+			serverHost = "http://" + serverHost
+		}
 	}
 
-	downloadURL := fmt.Sprintf("%s/tl/file/%s/timelapse.mp4", serverHost, folderName)
+	serverHost = strings.TrimSuffix(serverHost, "/") + config.NormalizeBasePath(t.core.GetConfig().Web.BasePath)
+	downloadURL := fmt.Sprintf("%s/tl/file/%s/%s", serverHost, folderName, videoFile)
 
 	const maxTelegramSize = 50 * 1024 * 1024 // 50 MB
 	mp4Size := mp4St.Size()
@@ -120,6 +134,14 @@ func (t *Telegram) sendTimelapseByFolder(c tele.Context, folderName string) erro
 		)
 
 		_ = c.Send("⏳ Отправляю оригинальный видеофайл...")
+		// AVI встроенного кодировщика Telegram не проигрывает, отправляем файлом
+		if videoFile != timelapse.VideoMP4 {
+			return c.Send(&tele.Document{
+				File:     tele.FromDisk(mp4Path),
+				FileName: info.Name + filepath.Ext(videoFile),
+				Caption:  caption,
+			}, tele.ModeHTML)
+		}
 		video := &tele.Video{
 			File:    tele.FromDisk(mp4Path),
 			Caption: caption,
@@ -127,7 +149,7 @@ func (t *Telegram) sendTimelapseByFolder(c tele.Context, folderName string) erro
 		return c.Send(video, tele.ModeHTML)
 	}
 
-	if previewSt, err := os.Stat(previewPath); err == nil {
+	if previewSt, err := os.Stat(previewPath); previewFile != "" && err == nil {
 		previewSize := previewSt.Size()
 		if previewSize <= maxTelegramSize {
 			previewCaption := fmt.Sprintf("🎬 <b>Таймлапс:</b> %s (Превью)\n⚠️ <i>Оригинал слишком большой (%s) для отправки напрямую.</i>\n\n🔗 <a href=\"%s\">Скачать оригинал в полном качестве</a>",
@@ -137,6 +159,12 @@ func (t *Telegram) sendTimelapseByFolder(c tele.Context, folderName string) erro
 			)
 
 			_ = c.Send("⏳ Отправляю сжатое превью...")
+			if previewFile == timelapse.PreviewGIF {
+				return c.Send(&tele.Animation{
+					File:    tele.FromDisk(previewPath),
+					Caption: previewCaption,
+				}, tele.ModeHTML)
+			}
 			video := &tele.Video{
 				File:    tele.FromDisk(previewPath),
 				Caption: previewCaption,
@@ -164,10 +192,9 @@ func (t *Telegram) getTimelapseList(savePath string) ([]TLMenuItem, error) {
 		if entry.IsDir() {
 			folderName := entry.Name()
 			fullPath := filepath.Join(savePath, folderName)
-			mp4Path := filepath.Join(fullPath, "timelapse.mp4")
 			infoPath := filepath.Join(fullPath, "info.json")
 
-			if _, err := os.Stat(mp4Path); err != nil {
+			if timelapse.VideoFile(fullPath) == "" {
 				continue
 			}
 
@@ -213,3 +240,91 @@ func chunkTimelapseList(slice []TLMenuItem, chunkSize int) [][]TLMenuItem {
 	}
 	return chunks
 }
+
+// assembleTimelapse пересобирает видео: /assemble <папка> [профиль] [наложение]. Без профиля
+// предлагает выбрать его кнопками, наложение тогда берется из настроек.
+func (t *Telegram) assembleTimelapse(c tele.Context) error {
+	args := c.Args()
+	cfg := t.core.GetConfig()
+
+	if len(args) == 0 {
+		var names, overlays []string
+		for _, profile := range cfg.EncodingProfiles() {
+			names = append(names, profile.Name)
+		}
+		for _, tpl := range cfg.OverlayTemplates() {
+			overlays = append(overlays, tpl.Name)
+		}
+		overlays = append(overlays, config.NoOverlay)
+		return c.Send("Использование: /assemble <папка> [профиль] [наложение]\nПрофили: " + strings.Join(names, ", ") +
+			"\nНаложения: " + strings.Join(overlays, ", "))
+	}
+
+	if len(args) > 1 {
+		overlay := ""
+		if len(args) > 2 {
+			overlay = args[2]
+		}
+		return t.startAssembly(c, args[0], args[1], overlay)
+	}
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for _, profile := range cfg.EncodingProfiles() {
+		text := fmt.Sprintf("%s (%s, CRF %d)", profile.Name, profile.Codec, profile.CRF)
+		if profile.Name == cfg.Timelapse.Profile {
+			text = "⭐ " + text
+		}
+		rows = append(rows, menu.Row(menu.Data(text, "assemble_tl", args[0], profile.Name)))
+	}
+	menu.Inline(rows...)
+	return c.Send("🎞 <b>Выберите профиль сборки:</b>", menu, tele.ModeHTML)
+}
+
+func (t *Telegram) handleAssembleCallback(c tele.Context) error {
+	defer c.Respond()
+	folder, profile, _ := strings.Cut(c.Data(), "|")
+	return t.startAssembly(c, folder, profile, "")
+}
+
+func (t *Telegram) startAssembly(c tele.Context, folder, profile, overlay string) error {
+	cfg := t.core.GetConfig()
+	if _, ok := cfg.EncodingProfile(profile); !ok {
+		return c.Send("❌ Неизвестный профиль сборки: " + profile)
+	}
+	if _, ok := cfg.OverlayTemplate(overlay); overlay != "" && overlay != config.NoOverlay && !ok {
+		return c.Send("❌ Неизвестный шаблон наложения: " + overlay)
+	}
+	session, err := timelapse.ReadSession(cfg.Timelapse.SavePath, folder)
+	if err != nil {
+		return c.Send("❌ Таймлапс " + folder + " не найден")
+	}
+	if session.FrameCount == 0 {
+		return c.Send("❌ В папке " + folder + " нет кадров")
+	}
+
+	queue := t.core.Jobs()
+	job, err := queue.Enqueue(jobs.Job{Folder: folder, Profile: profile, Overlay: overlay, Source: jobs.SourceTelegram})
+	if errors.Is(err, jobs.ErrDuplicate) {
+		return c.Send(fmt.Sprintf("⏳ %s уже собирается: %.0f%%", folder, job.Progress))
+	}
+	t.audit(c, auth.ActionCommand, "assemble "+folder+" "+profile)
+
+	go func() {
+		job, _ := queue.Wait(job.ID)
+		switch job.Status {
+		case jobs.StatusDone:
+			c.Send(fmt.Sprintf("✅ Видео %s собрано (профиль %s). Отправить: /timelapse %s", folder, profile, folder))
+		case jobs.StatusCanceled:
+			c.Send("⛔ Сборка " + folder + " отменена")
+		default:
+			log.Printf("[Telegram] Ошибка сборки %s: %s", folder, job.Error)
+			c.Send("❌ Ошибка сборки " + folder)
+		}
+	}()
+
+	if job.Status == jobs.StatusQueued {
+		return c.Send(fmt.Sprintf("🕒 Сборка %s поставлена в очередь, профиль %s", folder, profile))
+	}
+	return c.Send(fmt.Sprintf("⏳ Сборка %s запущена, профиль %s", folder, profile))
+}
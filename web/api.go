@@ -0,0 +1,542 @@
+package web
+
+import (
+	"bambucam/auth"
+	"bambucam/config"
+	"bambucam/printer/history"
+	"bambucam/printer/jobs"
+	"bambucam/printer/timelapse"
+	"bambucam/printer/upload"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const apiPrefix = "/api/v1"
+
+// redactedSecret подставляется вместо секретов в ответах API.
+// Если клиент присылает его обратно, сохраняется прежнее значение.
+const redactedSecret = "********"
+
+// apiRoute описывает эндпоинт API. Из этой же таблицы строится OpenAPI документ.
+type apiRoute struct {
+	method  string
+	path    string // в формате gin, параметры через ":"
+	scope   auth.Scope
+	tag     string
+	summary string
+	// request и response - образцы значений, по типам которых генерируется схема
+	request     any
+	response    any
+	contentType string // тип ответа, если не JSON
+	status      int
+	handler     gin.HandlerFunc
+}
+
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields - ошибки проверки по полям, ключ - путь поля как в yaml
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+type apiPrinterState struct {
+	Online           bool           `json:"online"`
+	FPS              float64        `json:"fps"`
+	State            string         `json:"gcode_state"`
+	Task             string         `json:"task"`
+	Progress         float64        `json:"progress_percent"`
+	Layer            int            `json:"layer"`
+	TotalLayers      int            `json:"total_layers"`
+	RemainingMinutes float64        `json:"remaining_minutes"`
+	NozzleTemp       float64        `json:"nozzle_temperature"`
+	NozzleTarget     float64        `json:"nozzle_target_temperature"`
+	BedTemp          float64        `json:"bed_temperature"`
+	BedTarget        float64        `json:"bed_target_temperature"`
+	ChamberLight     string         `json:"chamber_light"`
+	Raw              map[string]any `json:"raw"`
+}
+
+type apiCommand struct {
+	Command string `json:"command" enum:"light,pause,stop"`
+}
+
+type apiCommandResult struct {
+	Command  string `json:"command"`
+	Accepted bool   `json:"accepted"`
+}
+
+type apiTimelapse struct {
+	Folder       string    `json:"folder"`
+	Name         string    `json:"name"`
+	Status       string    `json:"status"`
+	StartedAt    time.Time `json:"started_at"`
+	Frames       int       `json:"frames"`
+	HasVideo     bool      `json:"has_video"`
+	HasPreview   bool      `json:"has_preview"`
+	RawFrames    bool      `json:"raw_frames"`
+	VideoSize    int64     `json:"video_size"`
+	VideoURL     string    `json:"video_url,omitempty"`
+	PreviewURL   string    `json:"preview_url,omitempty"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	// DiskSize - место, занятое папкой целиком
+	DiskSize int64 `json:"disk_size"`
+	// FramesDeleted - исходные кадры удалены после сборки, пересобрать нельзя
+	FramesDeleted bool `json:"frames_deleted,omitempty"`
+	// Uploads - состояние выгрузок по именам мест назначения. Изменения приходят в /events событием upload
+	Uploads map[string]upload.TargetStatus `json:"uploads,omitempty"`
+}
+
+type apiMessage struct {
+	Message string `json:"message"`
+}
+
+type apiAssembleRequest struct {
+	// Profile - профиль сборки, пусто - профиль из настроек
+	Profile string `json:"profile,omitempty"`
+	// Overlay - шаблон наложения, пусто - по настройкам, "none" - без наложения
+	Overlay string `json:"overlay,omitempty"`
+	// Stages - отбор кадров: "all" или "printing" (без нагрева и калибровки), пусто - по настройкам
+	Stages string `json:"stages,omitempty" enum:"all,printing"`
+}
+
+type apiUploadRequest struct {
+	// Targets - имена мест назначения, пусто - все включенные
+	Targets []string `json:"targets,omitempty"`
+}
+
+type apiUploadResult struct {
+	Targets []string `json:"targets"`
+}
+
+func (s *Server) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{method: "GET", path: "/printer", scope: auth.ScopeRead, tag: "printer", summary: "Текущее состояние принтера",
+			response: apiPrinterState{}, handler: s.apiGetPrinter},
+		{method: "GET", path: "/printer/snapshot", scope: auth.ScopeRead, tag: "printer", summary: "Последний кадр камеры",
+			contentType: "image/jpeg", handler: s.apiGetSnapshot},
+		{method: "POST", path: "/printer/commands", scope: auth.ScopeControl, tag: "printer", summary: "Отправить команду принтеру",
+			request: apiCommand{}, response: apiCommandResult{}, status: http.StatusAccepted, handler: s.apiPostCommand},
+
+		{method: "GET", path: "/timelapses", scope: auth.ScopeRead, tag: "timelapses", summary: "Список таймлапсов",
+			response: []apiTimelapse{}, handler: s.apiListTimelapses},
+		{method: "GET", path: "/timelapses/:folder", scope: auth.ScopeRead, tag: "timelapses", summary: "Сведения о таймлапсе",
+			response: apiTimelapse{}, handler: s.apiGetTimelapse},
+		{method: "POST", path: "/timelapses/:folder/assemble", scope: auth.ScopeControl, tag: "timelapses", summary: "Запустить сборку видео, тело запроса необязательно",
+			request: apiAssembleRequest{}, response: jobs.Job{}, status: http.StatusAccepted, handler: s.apiAssembleTimelapse},
+		{method: "POST", path: "/timelapses/:folder/upload", scope: auth.ScopeControl, tag: "timelapses", summary: "Выгрузить видео в места из настроек upload.targets, тело запроса необязательно",
+			request: apiUploadRequest{}, response: apiUploadResult{}, status: http.StatusAccepted, handler: s.apiUploadTimelapse},
+		{method: "GET", path: "/jobs", scope: auth.ScopeRead, tag: "jobs", summary: "Задания сборки, новые первыми. Изменения приходят в /events событием job",
+			response: []jobs.Job{}, handler: s.apiListJobs},
+		{method: "GET", path: "/jobs/:id", scope: auth.ScopeRead, tag: "jobs", summary: "Состояние задания сборки",
+			response: jobs.Job{}, handler: s.apiGetJob},
+		{method: "DELETE", path: "/jobs/:id", scope: auth.ScopeControl, tag: "jobs", summary: "Отменить задание сборки",
+			response: jobs.Job{}, handler: s.apiCancelJob},
+		{method: "GET", path: "/profiles", scope: auth.ScopeRead, tag: "timelapses", summary: "Профили сборки видео",
+			response: []config.EncodingProfile{}, handler: s.apiListProfiles},
+		{method: "GET", path: "/overlays", scope: auth.ScopeRead, tag: "timelapses", summary: "Шаблоны наложения на кадры",
+			response: []config.OverlayTemplate{}, handler: s.apiListOverlays},
+		{method: "DELETE", path: "/timelapses/:folder", scope: auth.ScopeControl, tag: "timelapses", summary: "Удалить таймлапс",
+			status: http.StatusNoContent, handler: s.apiDeleteTimelapse},
+
+		{method: "GET", path: "/config", scope: auth.ScopeAdmin, tag: "config", summary: "Настройки (секреты скрыты)",
+			response: config.Config{}, handler: s.apiGetConfig},
+		{method: "PUT", path: "/config", scope: auth.ScopeAdmin, tag: "config", summary: "Заменить настройки, затронутые компоненты перезапускаются",
+			request: config.Config{}, response: config.Config{}, handler: s.apiPutConfig},
+
+		{method: "GET", path: "/history", scope: auth.ScopeRead, tag: "history", summary: "История печати, новые первыми",
+			response: []history.Record{}, handler: s.apiGetHistory},
+
+		{method: "GET", path: "/audit", scope: auth.ScopeAdmin, tag: "audit", summary: "Журнал аудита, новые записи первыми",
+			response: []auth.AuditEntry{}, handler: s.apiGetAudit},
+	}
+}
+
+func (s *Server) setupAPI(group *gin.RouterGroup) {
+	api := group.Group(apiPrefix)
+	for _, r := range s.apiRoutes() {
+		api.Handle(r.method, r.path, s.requireScope(r.scope), r.handler)
+	}
+	api.GET("/openapi.json", s.requireScope(auth.ScopeRead), s.apiOpenAPI)
+}
+
+func (s *Server) apiOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, buildOpenAPI(s.apiRoutes(), s.core.GetAppVersion(), s.url(apiPrefix)))
+}
+
+// apiFail отвечает единым объектом ошибки
+func apiFail(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, apiError{Error: apiErrorBody{Code: code, Message: message}})
+}
+
+func (s *Server) apiNoRoute(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, apiPrefix+"/") {
+		apiFail(c, http.StatusNotFound, "not_found", "unknown API endpoint")
+		return
+	}
+	c.Status(http.StatusNotFound)
+}
+
+func (s *Server) apiGetPrinter(c *gin.Context) {
+	status := s.core.GetStatus()
+	num := func(key string) float64 {
+		val, _ := status[key].(float64)
+		return val
+	}
+
+	state := apiPrinterState{
+		Online:           s.core.IsOnline(),
+		FPS:              num("fps"),
+		Progress:         num("mc_percent"),
+		Layer:            int(num("layer_num")),
+		TotalLayers:      int(num("total_layer_num")),
+		RemainingMinutes: num("mc_remaining_time"),
+		NozzleTemp:       num("nozzle_temper"),
+		NozzleTarget:     num("nozzle_target_temper"),
+		BedTemp:          num("bed_temper"),
+		BedTarget:        num("bed_target_temper"),
+		ChamberLight:     "off",
+		Raw:              status,
+	}
+	state.State, _ = status["gcode_state"].(string)
+	state.Task, _ = status["subtask_name"].(string)
+
+	if lights, ok := status["lights_report"].([]any); ok {
+		for _, l := range lights {
+			if m, ok := l.(map[string]any); ok && m["node"] == "chamber_light" {
+				state.ChamberLight, _ = m["mode"].(string)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+func (s *Server) apiGetSnapshot(c *gin.Context) {
+	frame := s.core.GetFrame()
+	if frame == nil {
+		apiFail(c, http.StatusNotFound, "no_frame", "camera frame is not available")
+		return
+	}
+	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
+	c.Data(http.StatusOK, "image/jpeg", frame)
+}
+
+func (s *Server) apiPostCommand(c *gin.Context) {
+	var req apiCommand
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiFail(c, http.StatusBadRequest, "bad_request", "invalid JSON body")
+		return
+	}
+
+	switch req.Command {
+	case "light":
+		s.core.ToggleLight()
+	case "pause":
+		s.core.TogglePause()
+	case "stop":
+		s.core.StopPrinting()
+	default:
+		apiFail(c, http.StatusUnprocessableEntity, "unknown_command", "command must be one of: light, pause, stop")
+		return
+	}
+	s.audit(c, auth.ActionCommand, req.Command, true)
+
+	c.JSON(http.StatusAccepted, apiCommandResult{Command: req.Command, Accepted: true})
+}
+
+func (s *Server) timelapseToAPI(session timelapse.Session) apiTimelapse {
+	fileBase := s.url("/tl/file/" + session.FolderName + "/")
+
+	tl := apiTimelapse{
+		Folder:     session.FolderName,
+		Name:       session.Info.Name,
+		Status:     session.Info.Status.String(),
+		StartedAt:  session.Info.StartedAt,
+		Frames:     session.FrameCount,
+		HasVideo:   session.HasVideo,
+		HasPreview: session.HasPreview,
+		RawFrames:  session.RawFrames,
+		VideoSize:  session.VideoSize,
+
+		FramesDeleted: session.Info.FramesDeleted,
+		DiskSize:      session.Size,
+	}
+	if statuses := upload.ReadStatus(filepath.Join(s.core.GetConfig().Timelapse.SavePath, session.FolderName)); len(statuses) > 0 {
+		tl.Uploads = statuses
+	}
+	if session.HasVideo {
+		tl.VideoURL = fileBase + session.VideoFile
+	}
+	if session.HasPreview {
+		tl.PreviewURL = fileBase + session.PreviewFile
+	}
+	if session.LastFrame != "" {
+		tl.ThumbnailURL = fileBase + session.LastFrame
+	}
+	return tl
+}
+
+func (s *Server) apiListTimelapses(c *gin.Context) {
+	sessions := timelapse.ListSessions(s.core.GetConfig().Timelapse.SavePath)
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Info.StartedAt.After(sessions[j].Info.StartedAt)
+	})
+
+	list := make([]apiTimelapse, 0, len(sessions))
+	for _, session := range sessions {
+		list = append(list, s.timelapseToAPI(session))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// apiSession загружает сессию из параметра пути, отвечая ошибкой при неудаче
+func (s *Server) apiSession(c *gin.Context) (timelapse.Session, bool) {
+	session, err := timelapse.ReadSession(s.core.GetConfig().Timelapse.SavePath, c.Param("folder"))
+	switch {
+	case errors.Is(err, timelapse.ErrBadFolder):
+		apiFail(c, http.StatusBadRequest, "bad_request", "invalid timelapse folder name")
+		return session, false
+	case errors.Is(err, os.ErrNotExist):
+		apiFail(c, http.StatusNotFound, "not_found", "timelapse not found")
+		return session, false
+	case err != nil:
+		apiFail(c, http.StatusInternalServerError, "internal", err.Error())
+		return session, false
+	}
+	return session, true
+}
+
+func (s *Server) apiGetTimelapse(c *gin.Context) {
+	session, ok := s.apiSession(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, s.timelapseToAPI(session))
+}
+
+func (s *Server) apiAssembleTimelapse(c *gin.Context) {
+	session, ok := s.apiSession(c)
+	if !ok {
+		return
+	}
+	if session.FrameCount == 0 {
+		apiFail(c, http.StatusConflict, "no_frames", "timelapse has no frames to assemble")
+		return
+	}
+
+	var req apiAssembleRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apiFail(c, http.StatusBadRequest, "bad_request", "invalid JSON body: "+err.Error())
+			return
+		}
+	}
+	if _, ok := s.core.GetConfig().EncodingProfile(req.Profile); req.Profile != "" && !ok {
+		apiFail(c, http.StatusBadRequest, "unknown_profile", "unknown encoding profile: "+req.Profile)
+		return
+	}
+
+	if _, ok := s.core.GetConfig().OverlayTemplate(req.Overlay); req.Overlay != "" && req.Overlay != config.NoOverlay && !ok {
+		apiFail(c, http.StatusBadRequest, "unknown_overlay", "unknown overlay template: "+req.Overlay)
+		return
+	}
+	if req.Stages != "" && req.Stages != config.StagesAll && req.Stages != config.StagesPrinting {
+		apiFail(c, http.StatusBadRequest, "unknown_stages", "stages must be all or printing")
+		return
+	}
+
+	job, err := s.core.Jobs().Enqueue(jobs.Job{Folder: session.FolderName, Profile: req.Profile, Overlay: req.Overlay, Stages: req.Stages, Source: jobs.SourceAPI})
+	if errors.Is(err, jobs.ErrDuplicate) {
+		apiFail(c, http.StatusConflict, "already_queued", "timelapse is already being assembled by job "+job.ID)
+		return
+	}
+	s.audit(c, auth.ActionCommand, "assemble "+session.FolderName, true)
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (s *Server) apiUploadTimelapse(c *gin.Context) {
+	session, ok := s.apiSession(c)
+	if !ok {
+		return
+	}
+	if !session.HasVideo {
+		apiFail(c, http.StatusConflict, "no_video", "timelapse has no video to upload")
+		return
+	}
+
+	var req apiUploadRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apiFail(c, http.StatusBadRequest, "bad_request", "invalid JSON body: "+err.Error())
+			return
+		}
+	}
+
+	targets, err := s.core.Uploads().Enqueue(session.FolderName, req.Targets...)
+	switch {
+	case errors.Is(err, upload.ErrNoTargets):
+		apiFail(c, http.StatusBadRequest, "no_targets", "no upload targets are configured")
+		return
+	case errors.Is(err, upload.ErrUnknownTarget):
+		apiFail(c, http.StatusBadRequest, "unknown_target", err.Error())
+		return
+	case err != nil:
+		apiFail(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	s.audit(c, auth.ActionCommand, "upload "+session.FolderName+" to "+strings.Join(targets, ","), true)
+
+	c.JSON(http.StatusAccepted, apiUploadResult{Targets: targets})
+}
+
+func (s *Server) apiListJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, s.core.Jobs().List())
+}
+
+func (s *Server) apiGetJob(c *gin.Context) {
+	job, ok := s.core.Jobs().Get(c.Param("id"))
+	if !ok {
+		apiFail(c, http.StatusNotFound, "not_found", "job not found")
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func (s *Server) apiCancelJob(c *gin.Context) {
+	job, err := s.core.Jobs().Cancel(c.Param("id"))
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		apiFail(c, http.StatusNotFound, "not_found", "job not found")
+		return
+	case errors.Is(err, jobs.ErrFinished):
+		apiFail(c, http.StatusConflict, "finished", "job is already finished")
+		return
+	}
+	s.audit(c, auth.ActionCommand, "cancel assemble "+job.Folder, true)
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (s *Server) apiListProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, s.core.GetConfig().EncodingProfiles())
+}
+
+func (s *Server) apiListOverlays(c *gin.Context) {
+	c.JSON(http.StatusOK, s.core.GetConfig().OverlayTemplates())
+}
+
+func (s *Server) apiDeleteTimelapse(c *gin.Context) {
+	session, ok := s.apiSession(c)
+	if !ok {
+		return
+	}
+
+	switch err := timelapse.InUse(s.core, session); {
+	case errors.Is(err, timelapse.ErrRecording):
+		apiFail(c, http.StatusConflict, "recording", "timelapse is being recorded")
+		return
+	case errors.Is(err, timelapse.ErrAssembling):
+		apiFail(c, http.StatusConflict, "assembling", "timelapse is queued for assembly")
+		return
+	case errors.Is(err, timelapse.ErrUploading):
+		apiFail(c, http.StatusConflict, "uploading", "timelapse is queued for upload")
+		return
+	}
+
+	fullPath, _ := timelapse.FolderPath(s.core.GetConfig().Timelapse.SavePath, session.FolderName)
+	if err := os.RemoveAll(fullPath); err != nil {
+		apiFail(c, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	s.audit(c, auth.ActionCommand, "delete "+session.FolderName, true)
+	c.Status(http.StatusNoContent)
+}
+
+func redactConfig(cfg *config.Config) *config.Config {
+	out := cfg.Clone()
+	secrets := []*string{&out.Printer.Password, &out.Web.Password, &out.Telegram.Token}
+	for i := range out.Upload.Targets {
+		secrets = append(secrets, &out.Upload.Targets[i].Password, &out.Upload.Targets[i].SecretKey)
+	}
+	for _, secret := range secrets {
+		if *secret != "" {
+			*secret = redactedSecret
+		}
+	}
+	return out
+}
+
+func (s *Server) apiGetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, redactConfig(s.core.GetConfig()))
+}
+
+func (s *Server) apiPutConfig(c *gin.Context) {
+	current := s.core.GetConfig()
+	cfg := current.Clone()
+	if err := c.ShouldBindJSON(cfg); err != nil {
+		apiFail(c, http.StatusBadRequest, "bad_request", "invalid JSON body: "+err.Error())
+		return
+	}
+
+	// Секреты, пришедшие в скрытом виде, не меняем
+	keep := func(val *string, old string) {
+		if *val == redactedSecret {
+			*val = old
+		}
+	}
+	keep(&cfg.Printer.Password, current.Printer.Password)
+	keep(&cfg.Web.Password, current.Web.Password)
+	keep(&cfg.Telegram.Token, current.Telegram.Token)
+	// Секреты мест выгрузки сопоставляются по имени: список мог поменять порядок
+	for i := range cfg.Upload.Targets {
+		target := &cfg.Upload.Targets[i]
+		old, _ := current.UploadTarget(target.Name)
+		keep(&target.Password, old.Password)
+		keep(&target.SecretKey, old.SecretKey)
+	}
+
+	if errs := cfg.Validate(); len(errs) > 0 {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, apiError{Error: apiErrorBody{
+			Code:    "validation_failed",
+			Message: "config is invalid",
+			Fields:  errs,
+		}})
+		return
+	}
+
+	s.applyConfig(c, cfg)
+	c.JSON(http.StatusOK, redactConfig(cfg))
+}
+
+func (s *Server) apiGetHistory(c *gin.Context) {
+	records := s.core.GetHistory()
+	if records == nil {
+		records = []history.Record{}
+	}
+	c.JSON(http.StatusOK, records)
+}
+
+func (s *Server) apiGetAudit(c *gin.Context) {
+	limit := auditPageLimit
+	if val, err := strconv.Atoi(c.Query("limit")); err == nil && val > 0 {
+		limit = val
+	}
+	entries := s.core.Audit().List(limit)
+	if entries == nil {
+		entries = []auth.AuditEntry{}
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,144 @@
+package web
+
+import (
+	"bambucam/config"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CSRF защита устроена по схеме double submit: в cookie лежит случайное значение,
+// а в формы и fetch запросы подставляется его HMAC подпись. Чужой сайт не может
+// прочитать ни то, ни другое, поэтому не подделает запрос.
+const (
+	csrfCookie = "bambu_csrf"
+	csrfHeader = "X-CSRF-Token"
+	csrfField  = "_csrf"
+)
+
+// sameSite возвращает режим SameSite для cookie из настроек
+func (s *Server) sameSite(c *gin.Context) http.SameSite {
+	switch strings.ToLower(s.core.GetConfig().Web.CookieSameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		// Браузеры принимают SameSite=None только вместе с Secure
+		if s.isHTTPS(c) {
+			return http.SameSiteNoneMode
+		}
+		return http.SameSiteLaxMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+func (s *Server) signCSRF(value string) string {
+	mac := hmac.New(sha256.New, s.jwtKey)
+	mac.Write([]byte("csrf:" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// csrfToken возвращает токен для форм текущего запроса, при необходимости выдавая cookie
+func (s *Server) csrfToken(c *gin.Context) string {
+	value, err := c.Cookie(csrfCookie)
+	if err != nil || value == "" {
+		buf := make([]byte, 32)
+		rand.Read(buf)
+		value = base64.RawURLEncoding.EncodeToString(buf)
+		s.setCookie(c, csrfCookie, value, 0)
+	}
+	return s.signCSRF(value)
+}
+
+// sameOrigin сверяет Origin (или Referer) с адресом, по которому открыт интерфейс
+func (s *Server) sameOrigin(c *gin.Context) bool {
+	origin := c.GetHeader("Origin")
+	if origin == "" || origin == "null" {
+		origin = c.GetHeader("Referer")
+	}
+	if origin == "" {
+		// Старые браузеры и curl не присылают заголовков, тогда решает токен
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	hosts := []string{c.Request.Host}
+	if s.fromTrustedProxy(c) {
+		if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" {
+			hosts = append(hosts, strings.TrimSpace(strings.Split(forwarded, ",")[0]))
+		}
+	}
+	if configured := config.HostOnly(s.core.GetConfig().Web.Hostname); configured != "" {
+		hosts = append(hosts, configured)
+	}
+
+	for _, host := range hosts {
+		if strings.EqualFold(u.Host, host) || strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// CSRFMiddleware проверяет запросы, меняющие состояние. Запросы с API токеном
+// не проверяются: токен передается в заголовке, а не в cookie, и подделать его нельзя.
+func (s *Server) CSRFMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if c.GetString(ctxToken) != "" {
+			c.Next()
+			return
+		}
+
+		if !s.sameOrigin(c) {
+			s.csrfFailed(c, "origin mismatch")
+			return
+		}
+
+		value, err := c.Cookie(csrfCookie)
+		token := c.GetHeader(csrfHeader)
+		if token == "" {
+			token = c.PostForm(csrfField)
+		}
+		if err != nil || token == "" || !hmac.Equal([]byte(token), []byte(s.signCSRF(value))) {
+			s.csrfFailed(c, "invalid csrf token")
+			return
+		}
+		c.Next()
+	}
+}
+
+func (s *Server) csrfFailed(c *gin.Context, reason string) {
+	log.Printf("[WEB] Отклонен запрос %s %s от %s: %s", c.Request.Method, c.Request.URL.Path, c.ClientIP(), reason)
+	if strings.HasPrefix(c.Request.URL.Path, apiPrefix+"/") {
+		apiFail(c, http.StatusForbidden, "csrf_failed", reason)
+		return
+	}
+	if c.FullPath() == "/login" {
+		// Например, cookie истекла, пока была открыта страница входа
+		s.html(c, http.StatusForbidden, "login.go.html", gin.H{"Error": "Форма устарела, попробуйте войти еще раз"})
+		c.Abort()
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "CSRF: " + reason})
+}
+
+// html рендерит шаблон, добавляя данные, нужные всем страницам
+func (s *Server) html(c *gin.Context, status int, name string, data gin.H) {
+	data["CSRF"] = s.csrfToken(c)
+	c.HTML(status, name, data)
+}
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"bambucam/config"
+	"bambucam/printer"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeCore - Core, у которого есть только настройки: остальное проверкам CSRF не нужно
+type fakeCore struct {
+	printer.Core
+	cfg *config.Config
+}
+
+func (f fakeCore) GetConfig() *config.Config { return f.cfg }
+
+func TestCSRFMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	cfg.Web.Hostname = "https://printer.example.com/monitor"
+	s := &Server{core: fakeCore{cfg: cfg}, jwtKey: []byte("test-key")}
+
+	const cookie = "cookie-value"
+	valid := s.signCSRF(cookie)
+
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		form   string
+		origin string
+		api    bool
+		want   int
+	}{
+		{name: "GET without token", method: http.MethodGet, want: http.StatusOK},
+		{name: "header token", method: http.MethodPost, cookie: cookie, header: valid, want: http.StatusOK},
+		{name: "form token", method: http.MethodPost, cookie: cookie, form: valid, want: http.StatusOK},
+		{name: "same origin", method: http.MethodPost, cookie: cookie, header: valid, origin: "http://monitor.local:8080", want: http.StatusOK},
+		{name: "configured hostname", method: http.MethodPost, cookie: cookie, header: valid, origin: "https://printer.example.com", want: http.StatusOK},
+		{name: "API token skips check", method: http.MethodPost, api: true, want: http.StatusOK},
+		{name: "no token", method: http.MethodPost, cookie: cookie, want: http.StatusForbidden},
+		{name: "no cookie", method: http.MethodPost, header: valid, want: http.StatusForbidden},
+		{name: "token for other cookie", method: http.MethodPost, cookie: "other", header: valid, want: http.StatusForbidden},
+		{name: "raw cookie as token", method: http.MethodPost, cookie: cookie, header: cookie, want: http.StatusForbidden},
+		{name: "foreign origin", method: http.MethodPost, cookie: cookie, header: valid, origin: "https://evil.example", want: http.StatusForbidden},
+		{name: "DELETE checked too", method: http.MethodDelete, cookie: cookie, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(func(c *gin.Context) {
+				if tt.api {
+					c.Set(ctxToken, "token-id")
+				}
+			}, s.CSRFMiddleware())
+			r.Handle(tt.method, "/action", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			body := ""
+			if tt.form != "" {
+				body = url.Values{csrfField: {tt.form}}.Encode()
+			}
+			req := httptest.NewRequest(tt.method, "http://monitor.local:8080/action", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeader, tt.header)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package web
+
+import (
+	"bambucam/printer/events"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EventsHandler отдает поток Server-Sent Events: сначала полный статус, затем только изменения
+func (s *Server) EventsHandler(c *gin.Context) {
+	ch, unsubscribe := s.core.Events().Subscribe(64)
+	defer unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Отключаем буферизацию в nginx, иначе события приходят пачками
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent(events.TypeStatus, s.core.GetStatus())
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(20 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-s.stop:
+			return false
+		case evt, ok := <-ch:
+			if !ok {
+				// Подписка закрыта, если мы не успевали за событиями: браузер переподключится
+				// и получит полный статус вместо пропущенных изменений
+				return false
+			}
+			// Журнал аудита доступен только администраторам на отдельной странице
+			if evt.Type == events.TypeAudit {
+				return true
+			}
+			c.SSEvent(evt.Type, evt.Data)
+			return true
+		case <-heartbeat.C:
+			// Комментарий не вызывает обработчиков в браузере, но держит соединение живым
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		}
+	})
+}
@@ -0,0 +1,207 @@
+package web
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildOpenAPI строит OpenAPI 3 документ по таблице маршрутов API.
+// Схемы генерируются рефлексией по типам образцов request/response.
+func buildOpenAPI(routes []apiRoute, version, serverURL string) map[string]any {
+	gen := &schemaGen{schemas: map[string]any{}}
+	errorRef := gen.schema(reflect.TypeOf(apiError{}))
+
+	paths := map[string]map[string]any{}
+	for _, r := range routes {
+		path, params := openAPIPath(r.path)
+
+		status := r.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		success := map[string]any{"description": http.StatusText(status)}
+		switch {
+		case r.contentType != "":
+			success["content"] = map[string]any{
+				r.contentType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			}
+		case r.response != nil:
+			success["content"] = map[string]any{
+				"application/json": map[string]any{"schema": gen.schema(reflect.TypeOf(r.response))},
+			}
+		}
+
+		errorResponse := map[string]any{
+			"description": "Ошибка",
+			"content":     map[string]any{"application/json": map[string]any{"schema": errorRef}},
+		}
+
+		op := map[string]any{
+			"summary":     r.summary,
+			"description": "Требуемое право: `" + string(r.scope) + "`",
+			"x-scope":     r.scope,
+			"tags":        []string{r.tag},
+			"operationId": operationID(r.method, r.path),
+			"responses": map[string]any{
+				strconv.Itoa(status): success,
+				"default":            errorResponse,
+			},
+		}
+
+		if len(params) > 0 {
+			var list []map[string]any
+			for _, p := range params {
+				list = append(list, map[string]any{
+					"name":     p,
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]any{"type": "string"},
+				})
+			}
+			op["parameters"] = list
+		}
+
+		if r.request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": gen.schema(reflect.TypeOf(r.request))},
+				},
+			}
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(r.method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Bambu Monitor API",
+			"version": version,
+		},
+		"servers":  []map[string]any{{"url": serverURL}},
+		"paths":    paths,
+		"security": []map[string]any{{"bearerAuth": []string{}}, {"cookieAuth": []string{}}},
+		"components": map[string]any{
+			"schemas": gen.schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "description": "API токен со страницы /tokens"},
+				"cookieAuth": map[string]any{"type": "apiKey", "in": "cookie", "name": "bambu_token",
+					"description": "Сессия браузера. Изменяющие запросы требуют заголовок X-CSRF-Token"},
+			},
+		},
+	}
+}
+
+// openAPIPath переводит ":param" из формата gin в "{param}"
+func openAPIPath(ginPath string) (string, []string) {
+	var params []string
+	parts := strings.Split(ginPath, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			params = append(params, part[1:])
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.Split(path, "/") {
+		part = strings.TrimPrefix(part, ":")
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+type schemaGen struct {
+	schemas map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		// Именованные структуры выносим в components, анонимные описываем на месте
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = map[string]any{} // защита от рекурсии
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case t.Kind() == reflect.Interface:
+		return map[string]any{}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{}
+}
+
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := g.schema(f.Type)
+		if enum := f.Tag.Get("enum"); enum != "" {
+			prop["enum"] = strings.Split(enum, ",")
+		}
+		props[name] = prop
+	}
+	return map[string]any{"type": "object", "properties": props}
+}
+
+// schemaName дает короткое имя схемы: apiTimelapse -> Timelapse, history.Record -> HistoryRecord
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "api")
+	pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+	if pkg == "web" {
+		return name
+	}
+	if strings.EqualFold(pkg, name) {
+		return name
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + name
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"bambucam/auth"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Сколько последних записей журнала показывать на странице
+const auditPageLimit = 300
+
+// audit записывает действие текущего запроса в журнал: кто, откуда и когда
+func (s *Server) audit(c *gin.Context, action, details string, success bool) {
+	source := auth.SourceWeb
+	if strings.HasPrefix(c.Request.URL.Path, apiPrefix+"/") {
+		source = auth.SourceAPI
+	}
+
+	user := c.GetString(ctxUser)
+	if token := c.GetString(ctxToken); token != "" {
+		user = "token:" + token
+	}
+
+	s.core.Audit().Record(auth.AuditEntry{
+		Action:  action,
+		Source:  source,
+		User:    user,
+		IP:      c.ClientIP(),
+		Details: details,
+		Success: success,
+	})
+}
+
+func (s *Server) AuditHandler(c *gin.Context) {
+	s.html(c, http.StatusOK, "audit.go.html", gin.H{
+		"Entries": s.core.Audit().List(auditPageLimit),
+		"Limit":   auditPageLimit,
+	})
+}
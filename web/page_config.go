@@ -1,76 +1,143 @@
 package web
 
 import (
+	"bambucam/auth"
+	"bambucam/config"
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func (s *Server) ConfigHandler(c *gin.Context) {
-	c.HTML(http.StatusOK, "config.go.html", gin.H{
-		"Config": s.core.GetConfig(),
+	s.renderConfig(c, http.StatusOK, s.core.GetConfig(), config.FieldErrors{})
+}
+
+// renderConfig показывает форму настроек. При ошибках в форме остаются введенные значения.
+func (s *Server) renderConfig(c *gin.Context, status int, cfg *config.Config, errs config.FieldErrors) {
+	s.html(c, status, "config.go.html", gin.H{
+		"Config":    cfg,
+		"Errors":    errs,
+		"Overrides": config.Overrides(),
+		"Profiles":  cfg.EncodingProfiles(),
+		"Overlays":  cfg.OverlayTemplates(),
 	})
 }
 
 func (s *Server) ConfigSetter(c *gin.Context) {
-	cfg := s.core.GetConfig()
+	cfg := s.core.GetConfig().Clone()
+	errs := config.FieldErrors{}
 
-	// Принтер
-	cfg.Printer.Hostname = c.PostForm("printer_hostname")
-	cfg.Printer.Password = c.PostForm("printer_password")
-	cfg.Printer.Serial = c.PostForm("printer_serial")
-	if val, err := strconv.Atoi(c.PostForm("printer_encode_wait")); err == nil {
-		cfg.Printer.EncodeWait = val
+	// intField разбирает число из формы. Ошибка разбора показывается у поля, как и ошибки проверки.
+	intField := func(name, field string, dst *int) {
+		raw := strings.TrimSpace(c.PostForm(name))
+		val, err := strconv.Atoi(raw)
+		if err != nil {
+			errs.Add(field, "Нужно целое число, введено: \""+raw+"\"")
+			return
+		}
+		*dst = val
+	}
+	// secretField не трогает секрет, заданный окружением: в форме вместо него маска
+	overrides := config.Overrides()
+	secretField := func(name, field string, dst *string) {
+		if _, env := overrides[field]; !env {
+			*dst = strings.TrimSpace(c.PostForm(name))
+		}
 	}
 
+	// Принтер
+	cfg.Printer.Hostname = strings.TrimSpace(c.PostForm("printer_hostname"))
+	secretField("printer_password", "printer.password", &cfg.Printer.Password)
+	cfg.Printer.Serial = strings.TrimSpace(c.PostForm("printer_serial"))
+	intField("printer_encode_wait", "printer.encode_wait", &cfg.Printer.EncodeWait)
+
 	// Веб
-	cfg.Web.BindAddress = c.PostForm("web_address")
-	if val, err := strconv.Atoi(c.PostForm("web_port")); err == nil {
-		cfg.Web.Port = val
+	cfg.Web.BindAddress = strings.TrimSpace(c.PostForm("web_address"))
+	intField("web_port", "web.port", &cfg.Web.Port)
+	cfg.Web.Hostname = strings.TrimSpace(c.PostForm("web_hostname"))
+	intField("web_session_hours", "web.session_hours", &cfg.Web.SessionHours)
+	cfg.Web.BasePath = config.NormalizeBasePath(c.PostForm("web_base_path"))
+	cfg.Web.TrustedProxies = nil
+	for _, proxy := range strings.Split(c.PostForm("web_trusted_proxies"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			cfg.Web.TrustedProxies = append(cfg.Web.TrustedProxies, proxy)
+		}
 	}
-	cfg.Web.Username = c.PostForm("web_username")
-	cfg.Web.Password = c.PostForm("web_password")
-	cfg.Web.Hostname = c.PostForm("web_hostname")
+	cfg.Web.CookieSameSite = c.PostForm("web_samesite")
+	cfg.Web.Metrics = c.PostForm("web_metrics")
+	cfg.Web.TLSMode = c.PostForm("web_tls_mode")
+	cfg.Web.TLSCertFile = strings.TrimSpace(c.PostForm("web_tls_cert"))
+	cfg.Web.TLSKeyFile = strings.TrimSpace(c.PostForm("web_tls_key"))
+	cfg.Web.ACMEEmail = strings.TrimSpace(c.PostForm("web_acme_email"))
+	intField("web_redirect_port", "web.http_redirect_port", &cfg.Web.HTTPRedirectPort)
 
 	// Таймлапс
 	// Чекбоксы в HTML приходят как "on", если включены, или отсутствуют вовсе
 	cfg.Timelapse.Enabled = c.PostForm("tl_enabled") == "on"
-	cfg.Timelapse.SavePath = c.PostForm("tl_path")
-	if val, err := strconv.Atoi(c.PostForm("tl_fps")); err == nil {
-		cfg.Timelapse.Fps = val
-	}
-	if val, err := strconv.Atoi(c.PostForm("tl_after_layer")); err == nil {
-		cfg.Timelapse.AfterLayer = val
-	}
-	if val, err := strconv.Atoi(c.PostForm("tl_interval")); err == nil {
-		cfg.Timelapse.Interval = val
-	}
+	cfg.Timelapse.SavePath = strings.TrimSpace(c.PostForm("tl_path"))
+	intField("tl_fps", "timelapse.fps", &cfg.Timelapse.Fps)
+	intField("tl_after_layer", "timelapse.after_layer", &cfg.Timelapse.AfterLayer)
+	intField("tl_interval", "timelapse.interval_seconds", &cfg.Timelapse.Interval)
+	cfg.Timelapse.Smooth = c.PostForm("tl_smooth") == "on"
+	intField("tl_smooth_window", "timelapse.smooth_window_seconds", &cfg.Timelapse.SmoothWindow)
+	intField("tl_dedup_threshold", "timelapse.dedup_threshold", &cfg.Timelapse.DedupThreshold)
+	intField("tl_dedup_max_gap", "timelapse.dedup_max_gap_seconds", &cfg.Timelapse.DedupMaxGap)
+	cfg.Timelapse.Stages = c.PostForm("tl_stages")
 	cfg.Timelapse.AddTime = c.PostForm("tl_addtime") == "on"
+	cfg.Timelapse.Profile = c.PostForm("tl_profile")
+	cfg.Timelapse.Overlay = c.PostForm("tl_overlay")
+	intField("tl_max_jobs", "timelapse.max_jobs", &cfg.Timelapse.MaxJobs)
+	cfg.Timelapse.DeleteFrames = c.PostForm("tl_delete_frames") == "on"
+	intField("tl_keep_every", "timelapse.keep_every_frame", &cfg.Timelapse.KeepEveryFrame)
+	intField("tl_max_size", "timelapse.max_size_mb", &cfg.Timelapse.MaxSizeMB)
+	intField("tl_max_age", "timelapse.max_age_days", &cfg.Timelapse.MaxAgeDays)
+	intField("tl_min_free", "timelapse.min_free_mb", &cfg.Timelapse.MinFreeMB)
 
-	cfg.Telegram.Token = c.PostForm("tg_token")
+	// Выгрузка. Места назначения задаются только в config.yaml, здесь они лишь перечислены
+	cfg.Upload.Auto = c.PostForm("upload_auto") == "on"
+	intField("upload_retries", "upload.retries", &cfg.Upload.Retries)
+	intField("upload_retry_delay", "upload.retry_delay_seconds", &cfg.Upload.RetryDelay)
+
+	secretField("tg_token", "telegram.token", &cfg.Telegram.Token)
+	cfg.Telegram.AuditNotify = c.PostForm("tg_audit_notify") == "on"
 	cfg.Telegram.AdminIds = nil
-	tgids := c.PostForm("tg_adminids")
-	arr := strings.Split(tgids, ",")
-	for _, s := range arr {
-		ids := strings.TrimSpace(s)
-		if ids != "" {
-			id, err := strconv.ParseInt(ids, 10, 64)
-			if err == nil {
-				cfg.Telegram.AdminIds = append(cfg.Telegram.AdminIds, id)
-			}
+	for _, ids := range strings.Split(c.PostForm("tg_adminids"), ",") {
+		ids = strings.TrimSpace(ids)
+		if ids == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(ids, 10, 64)
+		if err != nil {
+			errs.Add("telegram.admin_ids", "Неверный ID: \""+ids+"\"")
+			continue
 		}
+		cfg.Telegram.AdminIds = append(cfg.Telegram.AdminIds, id)
 	}
 
-	// Сохраняем и обновляем в памяти
-	s.core.SetConfig(cfg)
-	go func() {
-		time.Sleep(time.Second * 5)
-		s.core.Restart()
-	}()
+	for field, msg := range cfg.Validate() {
+		errs.Add(field, msg)
+	}
+	if len(errs) > 0 {
+		s.renderConfig(c, http.StatusUnprocessableEntity, cfg, errs)
+		return
+	}
+
+	s.applyConfig(c, cfg)
 
 	// Возвращаемся на главную или показываем сообщение об успехе
-	c.Redirect(http.StatusSeeOther, "/")
+	c.Redirect(http.StatusSeeOther, s.url("/"))
+}
+
+// applyConfig сохраняет настройки. Приложение само перезапускает только те компоненты,
+// которых касаются изменения.
+func (s *Server) applyConfig(c *gin.Context, cfg *config.Config) {
+	changed := config.ChangedFields(s.core.GetConfig(), cfg)
+	if len(changed) == 0 {
+		return
+	}
+	s.audit(c, auth.ActionConfig, "изменены: "+strings.Join(changed, ", "), true)
+
+	s.core.SetConfig(cfg)
 }
@@ -1,140 +1,273 @@
 package web
 
 import (
+	"bambucam/auth"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// getJWTSecret генерирует динамический секретный ключ на основе логина и пароля
-func (s *Server) getJWTSecret() []byte {
-	username := s.core.GetConfig().Web.Username
-	password := s.core.GetConfig().Web.Password
-	return []byte(username + ":" + password + ":jwt_secure_salt_2026")
-}
+const sessionCookie = "bambu_token"
 
-// generateJWT генерирует JWT-токен на 30 дней
-func (s *Server) generateJWT() (string, error) {
-	username := s.core.GetConfig().Web.Username
-	secret := s.getJWTSecret()
+// sessionLifetime возвращает время жизни сессии из конфига
+func (s *Server) sessionLifetime() time.Duration {
+	hours := s.core.GetConfig().Web.SessionHours
+	if hours <= 0 {
+		hours = 30 * 24
+	}
+	return time.Duration(hours) * time.Hour
+}
 
-	// Наполняем токен полезной нагрузкой (claims)
-	claims := jwt.MapClaims{
-		"username": username,
-		"exp":      time.Now().Add(30 * 24 * time.Hour).Unix(), // время жизни 30 дней
+// generateJWT подписывает JWT для сессии, идентификатор сессии передается как jti
+func (s *Server) generateJWT(session auth.Session) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Subject:   session.Username,
+		ID:        session.ID,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secret)
+	return token.SignedString(s.jwtKey)
+}
+
+func (s *Server) setSessionCookie(c *gin.Context, value string, maxAge int) {
+	s.setCookie(c, sessionCookie, value, maxAge)
 }
 
-// AuthMiddleware защищает роуты с помощью проверки JWT-токена
+// setCookie выставляет cookie приложения: только для HTTP, на базовый путь и с настроенным SameSite
+func (s *Server) setCookie(c *gin.Context, name, value string, maxAge int) {
+	// Путь без слеша в конце, чтобы cookie отправлялась и на адрес самого префикса
+	path := s.basePath()
+	if path == "" {
+		path = "/"
+	}
+	c.SetSameSite(s.sameSite(c))
+	c.SetCookie(name, value, maxAge, path, "", s.isHTTPS(c), true)
+}
+
+// parseSession проверяет JWT из cookie и соответствующую ему запись сессии.
+// Когда прошла половина срока жизни, сессия продлевается и cookie перевыпускается.
+func (s *Server) parseSession(c *gin.Context) (auth.User, auth.Session, bool) {
+	cookie, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return auth.User{}, auth.Session{}, false
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(cookie, claims, func(token *jwt.Token) (interface{}, error) {
+		// Проверяем метод подписи
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.jwtKey, nil
+	})
+	if err != nil || !token.Valid {
+		return auth.User{}, auth.Session{}, false
+	}
+
+	user, ok := s.users.Get(claims.Subject)
+	if !ok {
+		return auth.User{}, auth.Session{}, false
+	}
+
+	// Скользящее продление: новая дата истечения только после половины срока
+	lifetime := s.sessionLifetime()
+	var extendTo time.Time
+	if claims.ExpiresAt != nil && time.Until(claims.ExpiresAt.Time) < lifetime/2 {
+		extendTo = time.Now().Add(lifetime)
+	}
+
+	session, ok := s.sessions.Touch(claims.ID, user.Username, extendTo)
+	if !ok {
+		return auth.User{}, auth.Session{}, false
+	}
+
+	if !extendTo.IsZero() {
+		if renewed, err := s.generateJWT(session); err == nil {
+			s.setSessionCookie(c, renewed, int(lifetime.Seconds()))
+		}
+	}
+
+	return user, session, true
+}
+
+// Ключи контекста gin: права, имя пользователя, сессия и API токен текущего запроса
+const (
+	ctxScopes  = "auth_scopes"
+	ctxUser    = "auth_user"
+	ctxSession = "auth_session"
+	ctxToken   = "auth_token"
+)
+
+// AuthMiddleware защищает роуты с помощью проверки JWT-токена или API токена
 func (s *Server) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		username := s.core.GetConfig().Web.Username
-		password := s.core.GetConfig().Web.Password
+		// API токен из заголовка имеет приоритет над cookie
+		if header := c.GetHeader("Authorization"); header != "" {
+			plain, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				s.unauthorized(c)
+				return
+			}
+			token, valid := s.tokens.Verify(strings.TrimSpace(plain))
+			if !valid {
+				s.unauthorized(c)
+				return
+			}
+			c.Set(ctxScopes, token.Scopes)
+			c.Set(ctxToken, token.Name)
+			c.Next()
+			return
+		}
 
-		// Если в конфигурации авторизация отключена, пропускаем всех без проверок
-		if username == "" || password == "" {
+		// Пока не создано ни одного пользователя, авторизация отключена
+		if s.users.Empty() {
+			c.Set(ctxScopes, []auth.Scope{auth.ScopeAdmin})
 			c.Next()
 			return
 		}
 
-		cookie, err := c.Cookie("bambu_token")
-		if err != nil {
+		user, session, ok := s.parseSession(c)
+		if !ok {
 			s.unauthorized(c)
 			return
 		}
 
-		// Декодируем и верифицируем подпись JWT
-		secret := s.getJWTSecret()
-		token, err := jwt.Parse(cookie, func(token *jwt.Token) (interface{}, error) {
-			// Проверяем метод подписи
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return secret, nil
-		})
+		// Права пользователя определяются его ролью
+		c.Set(ctxUser, user.Username)
+		c.Set(ctxSession, session.ID)
+		c.Set(ctxScopes, []auth.Scope{user.Role.Scope()})
+		c.Next()
+	}
+}
 
-		if err != nil || !token.Valid {
-			s.unauthorized(c)
+// hasScope сообщает, есть ли у запроса право (для отображения элементов страниц)
+func hasScope(c *gin.Context, required auth.Scope) bool {
+	scopes, _ := c.Get(ctxScopes)
+	list, _ := scopes.([]auth.Scope)
+	for _, scope := range list {
+		if scope.Includes(required) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireScope пропускает запрос, только если у него есть нужное право
+func (s *Server) requireScope(required auth.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if hasScope(c, required) {
+			c.Next()
 			return
 		}
 
-		c.Next()
+		if strings.HasPrefix(c.Request.URL.Path, apiPrefix+"/") {
+			apiFail(c, http.StatusForbidden, "forbidden", "scope '"+string(required)+"' is required")
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
 	}
 }
 
 // unauthorized обрабатывает ошибки авторизации в зависимости от типа запроса
 func (s *Server) unauthorized(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, apiPrefix+"/") {
+		apiFail(c, http.StatusUnauthorized, "unauthorized", "authentication required")
+		return
+	}
 	// Если это Ajax-запрос или POST формы, отдаем 401 ошибку
 	if c.Request.Header.Get("X-Requested-With") == "XMLHttpRequest" || c.Request.Method == "POST" {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 	// Для обычных страниц перенаправляем на страницу входа
-	c.Redirect(http.StatusSeeOther, "/login")
+	c.Redirect(http.StatusSeeOther, s.url("/login"))
 	c.Abort()
 }
 
 // LoginGetHandler отображает внешний файл-шаблон login.go.html
 func (s *Server) LoginGetHandler(c *gin.Context) {
 	// Если авторизация отключена
-	if s.core.GetConfig().Web.Username == "" || s.core.GetConfig().Web.Password == "" {
-		c.Redirect(http.StatusSeeOther, "/")
+	if s.users.Empty() {
+		c.Redirect(http.StatusSeeOther, s.url("/"))
 		return
 	}
 
 	// Если пользователь уже авторизован по JWT
-	cookie, err := c.Cookie("bambu_token")
-	if err == nil {
-		secret := s.getJWTSecret()
-		token, err := jwt.Parse(cookie, func(token *jwt.Token) (interface{}, error) {
-			return secret, nil
-		})
-		if err == nil && token.Valid {
-			c.Redirect(http.StatusSeeOther, "/")
-			return
-		}
+	if _, _, ok := s.parseSession(c); ok {
+		c.Redirect(http.StatusSeeOther, s.url("/"))
+		return
 	}
 
 	// Рендерим внешний шаблон
-	c.HTML(http.StatusOK, "login.go.html", gin.H{})
+	s.html(c, http.StatusOK, "login.go.html", gin.H{})
 }
 
-// LoginPostHandler обрабатывает POST форму входа
+// LoginPostHandler обрабатывает POST форму входа. Неудачные попытки считаются
+// отдельно по IP и по имени пользователя, после нескольких ошибок вход блокируется.
 func (s *Server) LoginPostHandler(c *gin.Context) {
 	username := c.PostForm("username")
 	password := c.PostForm("password")
+	keys := []string{"ip:" + c.ClientIP(), "user:" + strings.ToLower(username)}
+
+	if wait := s.limiter.Locked(keys...); wait > 0 {
+		s.audit(c, auth.ActionLoginLocked, "попытка входа как "+username+" во время блокировки", false)
+		s.html(c, http.StatusTooManyRequests, "login.go.html", gin.H{
+			"Error": fmt.Sprintf("Слишком много неудачных попыток, повторите через %s", wait.Round(time.Second)),
+		})
+		return
+	}
 
-	expectedUser := s.core.GetConfig().Web.Username
-	expectedPass := s.core.GetConfig().Web.Password
+	if user, ok := s.users.Authenticate(username, password); ok {
+		s.limiter.Success(keys...)
+		lifetime := s.sessionLifetime()
+		session, err := s.sessions.Create(user.Username, c.ClientIP(), c.Request.UserAgent(), lifetime)
+		if err != nil {
+			s.html(c, http.StatusInternalServerError, "login.go.html", gin.H{
+				"Error": "Ошибка создания сессии",
+			})
+			return
+		}
 
-	if username == expectedUser && password == expectedPass {
-		tokenString, err := s.generateJWT()
+		tokenString, err := s.generateJWT(session)
 		if err != nil {
-			c.HTML(http.StatusInternalServerError, "login.go.html", gin.H{
+			s.html(c, http.StatusInternalServerError, "login.go.html", gin.H{
 				"Error": "Ошибка генерации токена авторизации",
 			})
 			return
 		}
 
-		// Записываем подписанный JWT-токен в Cookies на 30 дней
-		c.SetCookie("bambu_token", tokenString, 2592000, "/", "", false, true)
-		c.Redirect(http.StatusSeeOther, "/")
+		s.setSessionCookie(c, tokenString, int(lifetime.Seconds()))
+		c.Set(ctxUser, user.Username)
+		s.audit(c, auth.ActionLogin, "", true)
+		c.Redirect(http.StatusSeeOther, s.url("/"))
 		return
 	}
 
-	c.HTML(http.StatusUnauthorized, "login.go.html", gin.H{
+	s.audit(c, auth.ActionLoginFailed, "имя: "+username, false)
+	if lockout := s.limiter.Fail(keys...); lockout > 0 {
+		log.Printf("[WEB] Вход заблокирован на %s (IP %s, имя %s)", lockout, c.ClientIP(), username)
+		s.audit(c, auth.ActionLoginLocked, fmt.Sprintf("блокировка на %s, имя: %s", lockout, username), false)
+	}
+
+	s.html(c, http.StatusUnauthorized, "login.go.html", gin.H{
 		"Error": "Неверное имя пользователя или пароль",
 	})
 }
 
-// LogoutHandler стирает сессию
+// LogoutHandler завершает текущую сессию и стирает cookie
 func (s *Server) LogoutHandler(c *gin.Context) {
-	c.SetCookie("bambu_token", "", -1, "/", "", false, true)
-	c.Redirect(http.StatusSeeOther, "/login")
+	if user, session, ok := s.parseSession(c); ok {
+		s.sessions.Revoke(session.ID)
+		c.Set(ctxUser, user.Username)
+		s.audit(c, auth.ActionLogout, "", true)
+	}
+	s.setSessionCookie(c, "", -1)
+	c.Redirect(http.StatusSeeOther, s.url("/login"))
 }
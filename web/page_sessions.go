@@ -0,0 +1,65 @@
+package web
+
+import (
+	"bambucam/auth"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionsHandler показывает активные сессии: свои для всех, все - для администратора
+func (s *Server) SessionsHandler(c *gin.Context) {
+	username := c.GetString(ctxUser)
+	isAdmin := hasScope(c, auth.ScopeAdmin)
+
+	var sessions []auth.Session
+	if username != "" {
+		filter := username
+		if isAdmin {
+			filter = ""
+		}
+		sessions = s.sessions.List(filter)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	s.html(c, http.StatusOK, "sessions.go.html", gin.H{
+		"Sessions":       sessions,
+		"CurrentUser":    username,
+		"CurrentSession": c.GetString(ctxSession),
+		"IsAdmin":        isAdmin,
+		"LifetimeHours":  int(s.sessionLifetime().Hours()),
+	})
+}
+
+// SessionRevoke завершает одну сессию. Чужие сессии может завершать только администратор.
+func (s *Server) SessionRevoke(c *gin.Context) {
+	session, ok := s.sessions.Get(c.PostForm("id"))
+	if !ok {
+		c.Redirect(http.StatusSeeOther, s.url("/sessions"))
+		return
+	}
+	if session.Username != c.GetString(ctxUser) && !hasScope(c, auth.ScopeAdmin) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	s.sessions.Revoke(session.ID)
+	if session.ID == c.GetString(ctxSession) {
+		s.setSessionCookie(c, "", -1)
+		c.Redirect(http.StatusSeeOther, s.url("/login"))
+		return
+	}
+	c.Redirect(http.StatusSeeOther, s.url("/sessions"))
+}
+
+// SessionsLogoutAll завершает все сессии текущего пользователя, включая эту
+func (s *Server) SessionsLogoutAll(c *gin.Context) {
+	if username := c.GetString(ctxUser); username != "" {
+		s.sessions.RevokeUser(username, "")
+	}
+	s.setSessionCookie(c, "", -1)
+	c.Redirect(http.StatusSeeOther, s.url("/login"))
+}
@@ -1,13 +1,17 @@
 package web
 
 import (
+	"bambucam/auth"
+	"bambucam/config"
+	"bambucam/printer/jobs"
 	"bambucam/printer/timelapse"
-	"encoding/json"
-	"log"
+	"bambucam/printer/upload"
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
@@ -16,22 +20,106 @@ import (
 
 func (s *Server) HandleAssemble(c *gin.Context) {
 	var req struct {
-		Folder string `json:"folder"`
+		Folder  string `json:"folder"`
+		Profile string `json:"profile"`
+		Overlay string `json:"overlay"`
+		Stages  string `json:"stages"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": "Неверный запрос"})
 		return
 	}
+	if _, ok := s.core.GetConfig().EncodingProfile(req.Profile); req.Profile != "" && !ok {
+		c.JSON(400, gin.H{"error": "Неизвестный профиль сборки"})
+		return
+	}
+	if _, ok := s.core.GetConfig().OverlayTemplate(req.Overlay); req.Overlay != "" && req.Overlay != config.NoOverlay && !ok {
+		c.JSON(400, gin.H{"error": "Неизвестный шаблон наложения"})
+		return
+	}
+	if req.Stages != "" && req.Stages != config.StagesAll && req.Stages != config.StagesPrinting {
+		c.JSON(400, gin.H{"error": "Неизвестный отбор кадров"})
+		return
+	}
 
-	go func() {
-		err := s.core.AssembleVideo(req.Folder)
-		if err != nil {
-			log.Printf("Ошибка сборки из веба: %v", err)
-		}
-	}()
+	if _, err := timelapse.FolderPath(s.core.GetConfig().Timelapse.SavePath, req.Folder); err != nil {
+		c.JSON(400, gin.H{"error": "Неверный запрос"})
+		return
+	}
+
+	job, err := s.core.Jobs().Enqueue(jobs.Job{Folder: req.Folder, Profile: req.Profile, Overlay: req.Overlay, Stages: req.Stages, Source: jobs.SourceWeb})
+	if errors.Is(err, jobs.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Эта папка уже собирается", "job": job})
+		return
+	}
+	s.audit(c, auth.ActionCommand, "assemble "+req.Folder, true)
+
+	message := "Сборка запущена"
+	if job.Status == jobs.StatusQueued {
+		message = "Сборка поставлена в очередь"
+	}
+	c.JSON(200, gin.H{"message": message, "job": job})
+}
+
+// HandleJobCancel отменяет задание сборки
+func (s *Server) HandleJobCancel(c *gin.Context) {
+	var req struct {
+		ID string `json:"id"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный запрос"})
+		return
+	}
+
+	job, err := s.core.Jobs().Cancel(req.ID)
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	s.audit(c, auth.ActionCommand, "cancel assemble "+job.Folder, true)
+
+	c.JSON(200, gin.H{"message": "Сборка отменена", "job": job})
+}
+
+// HandleUpload ставит сессию в очередь выгрузки: в одно место или, без target, во все включенные
+func (s *Server) HandleUpload(c *gin.Context) {
+	var req struct {
+		Folder string `json:"folder"`
+		Target string `json:"target"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный запрос"})
+		return
+	}
+	fullPath, err := timelapse.FolderPath(s.core.GetConfig().Timelapse.SavePath, req.Folder)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный запрос"})
+		return
+	}
+	if _, err := os.Stat(fullPath); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Таймлапс не найден"})
+		return
+	}
+
+	var targets []string
+	if req.Target != "" {
+		targets = append(targets, req.Target)
+	}
+	targets, err = s.core.Uploads().Enqueue(req.Folder, targets...)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.audit(c, auth.ActionCommand, "upload "+req.Folder+" to "+strings.Join(targets, ","), true)
 
-	c.JSON(200, gin.H{"message": "Сборка запущена в фоновом режиме"})
+	c.JSON(200, gin.H{"message": "Выгрузка поставлена в очередь: " + strings.Join(targets, ", "), "targets": targets})
 }
 
 func (s *Server) TimelapsHandler(c *gin.Context) {
@@ -46,69 +134,122 @@ func (s *Server) TimelapsHandler(c *gin.Context) {
 		Status     string
 		HasVideo   bool
 		HasPreview bool
-		FrameCount int
-		Thumbnail  string
-		Size       string
+		// Playable - видео в mp4, которое браузер покажет сам (AVI встроенного кодировщика только скачивается)
+		Playable    bool
+		VideoFile   string
+		PreviewFile string
+		PreviewGIF  bool
+		FrameCount  int
+		Thumbnail   string
+		Size        string
+		// DiskSize - папка целиком, FramesSize - исходные кадры в ней
+		DiskSize      string
+		FramesSize    string
+		FramesDeleted bool
+		// RawFrames - кадры чистые, наложение можно выбрать при пересборке
+		RawFrames bool
+		// Job - идущая или ожидающая сборка этой папки
+		Job *jobs.Job
 	}
 
 	var list []TimelapseView
+	// uploads - состояние выгрузок по папкам, карточки отрисовывает скрипт страницы
+	uploads := map[string]map[string]upload.TargetStatus{}
+	active := s.core.Jobs().Active()
+	sessions := timelapse.ListSessions(savePath)
 
-	entries, _ := os.ReadDir(savePath)
-	for _, entry := range entries {
-		if entry.IsDir() {
-			fullPath := filepath.Join(savePath, entry.Name())
-			infoPath := filepath.Join(fullPath, "info.json")
-
-			// Читаем info.json
-			var info timelapse.TimelapsInfo
-			data, err := os.ReadFile(infoPath)
-			if err == nil {
-				json.Unmarshal(data, &info)
-			}
-
-			// Считаем количество кадров
-			frames, _ := filepath.Glob(filepath.Join(fullPath, "layer_*.jpg"))
-
-			// Проверяем наличие видео
-			st, videoErr := os.Stat(filepath.Join(fullPath, "timelapse.mp4"))
-			size := uint64(0)
-			if st != nil {
-				size = uint64(st.Size())
-			}
+	for _, session := range sessions {
+		view := TimelapseView{
+			FolderName:  session.FolderName,
+			Name:        session.Info.Name,
+			StartedAt:   session.Info.StartedAt,
+			Date:        session.Info.StartedAt.Format("02.01.2006 15:04"),
+			Status:      session.Info.Status.String(),
+			Size:        humanize.Bytes(uint64(session.VideoSize)),
+			HasVideo:    session.HasVideo,
+			HasPreview:  session.HasPreview,
+			Playable:    session.VideoFile == timelapse.VideoMP4,
+			VideoFile:   session.VideoFile,
+			PreviewFile: session.PreviewFile,
+			PreviewGIF:  session.PreviewFile == timelapse.PreviewGIF,
+			FrameCount:  session.FrameCount,
+			RawFrames:   session.RawFrames,
 
-			_, previewErr := os.Stat(filepath.Join(fullPath, "preview.mp4"))
-
-			view := TimelapseView{
-				FolderName: entry.Name(),
-				Name:       info.Name,
-				StartedAt:  info.StartedAt,
-				Date:       info.StartedAt.Format("02.01.2006 15:04"),
-				Status:     info.Status.String(),
-				Size:       humanize.Bytes(size),
-				HasVideo:   videoErr == nil,
-				HasPreview: previewErr == nil,
-				FrameCount: len(frames),
-			}
+			DiskSize:      humanize.Bytes(uint64(session.Size)),
+			FramesSize:    humanize.Bytes(uint64(session.FramesSize)),
+			FramesDeleted: session.Info.FramesDeleted,
+		}
 
-			// Если есть хоть один кадр, используем его как превью
-			if len(frames) > 0 {
-				view.Thumbnail = filepath.Join(entry.Name(), filepath.Base(frames[len(frames)-1]))
-			}
+		if job, ok := active[session.FolderName]; ok {
+			view.Job = &job
+		}
+		if statuses := upload.ReadStatus(filepath.Join(savePath, session.FolderName)); len(statuses) > 0 {
+			uploads[session.FolderName] = statuses
+		}
 
-			list = append(list, view)
+		// Если есть хоть один кадр, используем его как превью
+		if session.LastFrame != "" {
+			view.Thumbnail = filepath.Join(session.FolderName, session.LastFrame)
 		}
+
+		list = append(list, view)
 	}
 
 	sort.Slice(list, func(i, j int) bool {
 		return list[i].StartedAt.After(list[j].StartedAt)
 	})
 
-	c.HTML(http.StatusOK, "timelaps.go.html", gin.H{
-		"Timelapses": list,
-		"Config":     s.core.GetConfig(),
+	cfg := s.core.GetConfig()
+	usage := timelapse.DiskUsage(savePath, sessions)
+	usageView := gin.H{
+		"Sessions": usage.Sessions,
+		"Total":    humanize.Bytes(uint64(usage.Total)),
+		"Frames":   humanize.Bytes(uint64(usage.Frames)),
+		"Videos":   humanize.Bytes(uint64(usage.Videos)),
+		"Limit":    "",
+		"Free":     "",
+		"LowSpace": false,
+		"MaxAge":   cfg.Timelapse.MaxAgeDays,
+	}
+	if cfg.Timelapse.MaxSizeMB > 0 {
+		usageView["Limit"] = humanize.Bytes(uint64(cfg.Timelapse.MaxSizeMB) << 20)
+	}
+	if usage.FreeKnown {
+		usageView["Free"] = humanize.Bytes(usage.Free)
+		usageView["LowSpace"] = usage.Free < uint64(cfg.Timelapse.MinFreeMB)<<20
+	}
+
+	s.html(c, http.StatusOK, "timelaps.go.html", gin.H{
+		"Timelapses":     list,
+		"Config":         cfg,
+		"Profiles":       cfg.EncodingProfiles(),
+		"DefaultProfile": cfg.Timelapse.Profile,
+		"Overlays":       cfg.OverlayTemplates(),
+		"NoOverlay":      config.NoOverlay,
+		"Jobs":           recentJobs(s.core.Jobs().List()),
+		"Usage":          usageView,
+		"UploadTargets":  cfg.UploadTargets(),
+		"Uploads":        uploads,
 	})
 }
 
+// recentJobs - идущие и ожидающие задания и несколько последних завершенных
+func recentJobs(list []jobs.Job) []jobs.Job {
+	const finishedShown = 10
+	var recent []jobs.Job
+	finished := 0
+	for _, job := range list {
+		if job.Finished() {
+			if finished >= finishedShown {
+				continue
+			}
+			finished++
+		}
+		recent = append(recent, job)
+	}
+	return recent
+}
+
 func (s *Server) TimelapsFile(c *gin.Context) {
 	filePath := c.Param("path")
 	filePath = filepath.Clean(filePath)
@@ -134,13 +275,32 @@ func (s *Server) TimelapsRemove(c *gin.Context) {
 		return
 	}
 
-	filePath := filepath.Clean(req.Folder)
-	fullPath := filepath.Join(s.core.GetConfig().Timelapse.SavePath, filePath)
+	savePath := s.core.GetConfig().Timelapse.SavePath
+	session, err := timelapse.ReadSession(savePath, req.Folder)
+	switch {
+	case errors.Is(err, timelapse.ErrBadFolder):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный запрос"})
+		return
+	case errors.Is(err, os.ErrNotExist):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Таймлапс не найден"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Удаление папки посреди сборки оставило бы упавшее задание и недописанный файл
+	if err := timelapse.InUse(s.core, session); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Нельзя удалить: " + err.Error()})
+		return
+	}
 
-	err := os.RemoveAll(fullPath)
+	fullPath, _ := timelapse.FolderPath(savePath, session.FolderName)
+	err = os.RemoveAll(fullPath)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+	s.audit(c, auth.ActionCommand, "delete "+session.FolderName, true)
 
 	c.JSON(200, gin.H{"message": "Таймлапс удален"})
 }
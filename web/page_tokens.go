@@ -0,0 +1,53 @@
+package web
+
+import (
+	"bambucam/auth"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) renderTokens(c *gin.Context, status int, data gin.H) {
+	tokens := s.tokens.List()
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+
+	data["Tokens"] = tokens
+	data["Scopes"] = auth.Scopes
+	s.html(c, status, "tokens.go.html", data)
+}
+
+func (s *Server) TokensHandler(c *gin.Context) {
+	s.renderTokens(c, http.StatusOK, gin.H{})
+}
+
+// TokenCreate выпускает токен и единственный раз показывает его значение
+func (s *Server) TokenCreate(c *gin.Context) {
+	var scopes []auth.Scope
+	for _, scope := range c.PostFormArray("scopes") {
+		scopes = append(scopes, auth.Scope(scope))
+	}
+
+	plain, token, err := s.tokens.Create(c.PostForm("name"), scopes)
+	if err != nil {
+		s.renderTokens(c, http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	s.audit(c, auth.ActionTokens, "выпущен токен "+token.Name, true)
+
+	s.renderTokens(c, http.StatusOK, gin.H{
+		"NewToken":     plain,
+		"NewTokenName": token.Name,
+	})
+}
+
+func (s *Server) TokenRevoke(c *gin.Context) {
+	if err := s.tokens.Revoke(c.PostForm("id")); err != nil {
+		s.renderTokens(c, http.StatusNotFound, gin.H{"Error": err.Error()})
+		return
+	}
+	s.audit(c, auth.ActionTokens, "отозван токен "+c.PostForm("id"), true)
+	c.Redirect(http.StatusSeeOther, s.url("/tokens"))
+}
@@ -0,0 +1,97 @@
+package web
+
+import (
+	"bambucam/auth"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// migrateLegacyCredentials переносит логин и пароль из config.yaml в хранилище пользователей
+// и удаляет открытый пароль из конфига
+func (s *Server) migrateLegacyCredentials() {
+	cfg := s.core.GetConfig()
+	if cfg.Web.Username == "" && cfg.Web.Password == "" {
+		return
+	}
+
+	created, err := s.users.MigrateLegacy(cfg.Web.Username, cfg.Web.Password)
+	if err != nil {
+		log.Println("[WEB] Ошибка переноса учетной записи из конфига:", err)
+		return
+	}
+	if created {
+		log.Printf("[WEB] Учетная запись %s перенесена из конфига в users.json", cfg.Web.Username)
+	}
+
+	cfg = cfg.Clone()
+	cfg.Web.Username = ""
+	cfg.Web.Password = ""
+	s.core.SetConfig(cfg)
+}
+
+func (s *Server) renderUsers(c *gin.Context, status int, data gin.H) {
+	users := s.users.List()
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	data["Users"] = users
+	data["Roles"] = auth.Roles
+	data["CurrentUser"] = c.GetString(ctxUser)
+	s.html(c, status, "users.go.html", data)
+}
+
+func (s *Server) UsersHandler(c *gin.Context) {
+	s.renderUsers(c, http.StatusOK, gin.H{})
+}
+
+func (s *Server) UserCreate(c *gin.Context) {
+	err := s.users.Create(c.PostForm("username"), c.PostForm("password"), auth.Role(c.PostForm("role")))
+	if err != nil {
+		s.renderUsers(c, http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	s.audit(c, auth.ActionUsers, "создан "+c.PostForm("username")+" ("+c.PostForm("role")+")", true)
+	c.Redirect(http.StatusSeeOther, s.url("/users"))
+}
+
+// UserUpdate меняет роль и, если указан, пароль пользователя
+func (s *Server) UserUpdate(c *gin.Context) {
+	username := c.PostForm("username")
+	role := auth.Role(c.PostForm("role"))
+	old, _ := s.users.Get(username)
+
+	if err := s.users.SetRole(username, role); err != nil {
+		s.renderUsers(c, http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	if old.Role != role {
+		s.audit(c, auth.ActionUsers, "роль "+username+": "+string(old.Role)+" → "+string(role), true)
+	}
+
+	if password := c.PostForm("password"); password != "" {
+		if err := s.users.SetPassword(username, password); err != nil {
+			s.renderUsers(c, http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		// Со старым паролем нельзя оставаться в системе
+		s.sessions.RevokeUser(username, c.GetString(ctxSession))
+		s.audit(c, auth.ActionUsers, "сменен пароль "+username, true)
+	}
+
+	c.Redirect(http.StatusSeeOther, s.url("/users"))
+}
+
+func (s *Server) UserDelete(c *gin.Context) {
+	username := c.PostForm("username")
+	if err := s.users.Delete(username); err != nil {
+		s.renderUsers(c, http.StatusBadRequest, gin.H{"Error": err.Error()})
+		return
+	}
+	s.sessions.RevokeUser(username, "")
+	s.audit(c, auth.ActionUsers, "удален "+username, true)
+	c.Redirect(http.StatusSeeOther, s.url("/users"))
+}
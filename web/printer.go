@@ -1,6 +1,7 @@
 package web
 
 import (
+	"bambucam/auth"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -25,12 +26,15 @@ func (s *Server) PrinterStatus(c *gin.Context) {
 
 func (s *Server) ToggleLight(c *gin.Context) {
 	s.core.ToggleLight()
+	s.audit(c, auth.ActionCommand, "light", true)
 }
 
 func (s *Server) StopPrinting(c *gin.Context) {
 	s.core.StopPrinting()
+	s.audit(c, auth.ActionCommand, "stop", true)
 }
 
 func (s *Server) TogglePause(c *gin.Context) {
 	s.core.TogglePause()
+	s.audit(c, auth.ActionCommand, "pause", true)
 }
@@ -1,12 +1,17 @@
 package web
 
 import (
+	"bambucam/auth"
+	"bambucam/config"
+	"bambucam/metrics"
 	"bambucam/printer"
 	"bambucam/web/static"
 	"context"
 	"log"
 	"net/http"
+	"net/netip"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,75 +21,270 @@ type Server struct {
 	core       printer.Core
 	Router     *gin.Engine
 	httpServer *http.Server
+	redirect   *http.Server
+	stop       chan struct{}
+	tokens     *auth.TokenStore
+	users      *auth.UserStore
+	sessions   *auth.SessionStore
+	limiter    *auth.LoginLimiter
+	jwtKey     []byte
+	proxies    []netip.Prefix
 }
 
-func NewServer(core printer.Core) *Server {
+// NewServer создает веб-сервер. limiter передается снаружи, чтобы блокировки входа
+// сохранялись, когда сервер пересоздается после изменения настроек.
+func NewServer(core printer.Core, limiter *auth.LoginLimiter) *Server {
 	//gin.SetMode(gin.ReleaseMode)
 
 	r := gin.New()
 	r.Use(gin.Recovery())
+	r.Use(metrics.GinMiddleware())
 
 	s := &Server{
-		core:   core,
-		Router: r,
+		core:     core,
+		Router:   r,
+		stop:     make(chan struct{}),
+		tokens:   auth.NewTokenStore(config.Path("tokens.json")),
+		users:    auth.NewUserStore(config.Path("users.json")),
+		sessions: auth.NewSessionStore(config.Path("sessions.json")),
+		limiter:  limiter,
 	}
+	s.migrateLegacyCredentials()
 
-	static.RouteEmbedFiles(r)
+	key, err := auth.LoadOrCreateKey(config.Path("session.key"))
+	if err != nil {
+		// Временный ключ работает, но сессии не переживут перезапуск
+		log.Println("[WEB] Ключ сессий не сохранен:", err)
+	}
+	s.jwtKey = key
+
+	s.setupProxies()
+
+	static.RouteEmbedFiles(r, s.basePath)
 	s.SetupRouts()
 	return s
 }
 
 func (s *Server) Start() {
-	addr := s.core.GetConfig().Web.BindAddress + ":" + strconv.Itoa(s.core.GetConfig().Web.Port)
+	cfg := s.core.GetConfig().Web
+	addr := cfg.BindAddress + ":" + strconv.Itoa(cfg.Port)
+
+	tlsConfig, acme, err := s.tlsSetup()
+	if err != nil {
+		// Лучше открыть интерфейс по HTTP, чем оставить пользователя без доступа к настройкам
+		log.Println("[WEB] Ошибка настройки HTTPS, сервер запускается по HTTP:", err)
+		tlsConfig, acme = nil, nil
+	}
 
 	s.httpServer = &http.Server{
-		Addr:    addr,
-		Handler: s.Router,
+		Addr:      addr,
+		Handler:   s.stripBasePath(s.Router),
+		TLSConfig: tlsConfig,
 	}
 
-	log.Printf("[WEB] Сервер запускается на %s", addr)
+	if tlsConfig == nil {
+		log.Printf("[WEB] Сервер запускается на http://%s", addr)
+		go func() {
+			if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Println("[WEB] Ошибка запуска:", err)
+			}
+		}()
+		return
+	}
 
+	log.Printf("[WEB] Сервер запускается на https://%s", addr)
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		// Сертификаты уже в TLSConfig, поэтому пути к файлам не нужны
+		if err := s.httpServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
 			log.Println("[WEB] Ошибка запуска:", err)
 		}
 	}()
+
+	if cfg.HTTPRedirectPort > 0 {
+		handler := s.redirectHandler()
+		if acme != nil {
+			// На этом же порту ACME проходит проверку владения доменом
+			handler = acme.HTTPHandler(handler)
+		}
+		s.redirect = &http.Server{
+			Addr:    cfg.BindAddress + ":" + strconv.Itoa(cfg.HTTPRedirectPort),
+			Handler: handler,
+		}
+		log.Printf("[WEB] Перенаправление HTTP -> HTTPS на %s", s.redirect.Addr)
+		go func() {
+			if err := s.redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Println("[WEB] Ошибка запуска перенаправления:", err)
+			}
+		}()
+	}
 }
 
 func (s *Server) Stop() {
 	log.Println("[WEB] Останавливаю сервер...")
+	// Закрываем потоки событий, иначе Shutdown будет ждать их до таймаута
+	close(s.stop)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	if s.httpServer != nil {
-		err := s.httpServer.Shutdown(ctx)
-		if err != nil {
-			s.httpServer.Close()
+	for _, srv := range []*http.Server{s.httpServer, s.redirect} {
+		if srv == nil {
+			continue
+		}
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	}
+}
+
+// metricsAccess применяет web.metrics при каждом запросе, поэтому смена настройки не
+// требует перезапуска сервера. Для token запрос идет дальше, к проверке авторизации.
+func (s *Server) metricsAccess(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch s.core.GetConfig().Web.Metrics {
+		case config.MetricsOff:
+			c.AbortWithStatus(http.StatusNotFound)
+		case config.MetricsPublic:
+			handler(c)
+			c.Abort()
 		}
 	}
 }
 
 func (s *Server) SetupRouts() {
 	s.Router.GET("/login", s.LoginGetHandler)
-	s.Router.POST("/login", s.LoginPostHandler)
-	s.Router.GET("/logout", s.LogoutHandler)
+	s.Router.POST("/login", s.CSRFMiddleware(), s.LoginPostHandler)
+	s.Router.POST("/logout", s.CSRFMiddleware(), s.LogoutHandler)
+	// Prometheus опрашивает метрики с API токеном (bearer_token в scrape_config), если в
+	// настройках не разрешен доступ без авторизации
+	metricsHandler := gin.WrapH(metrics.Handler(s.core))
+	s.Router.GET("/metrics", s.metricsAccess(metricsHandler), s.AuthMiddleware(), s.requireScope(auth.ScopeRead), metricsHandler)
 
 	protected := s.Router.Group("/")
-	protected.Use(s.AuthMiddleware())
+	protected.Use(s.AuthMiddleware(), s.CSRFMiddleware())
+
+	read := protected.Group("/", s.requireScope(auth.ScopeRead))
+	{
+		read.GET("/sessions", s.SessionsHandler)
+		read.POST("/sessions/revoke", s.SessionRevoke)
+		read.POST("/sessions/logout-all", s.SessionsLogoutAll)
+		read.GET("/", s.IndexHandler)
+		read.GET("/status", s.PrinterStatus)
+		read.GET("/events", s.EventsHandler)
+		read.GET("/timelapse", s.TimelapsHandler)
+		read.GET("/tl/file/*path", s.TimelapsFile)
+		read.GET("/snap", s.SnapHandler)
+	}
+
+	control := protected.Group("/", s.requireScope(auth.ScopeControl))
+	{
+		control.POST("/printer/light", s.ToggleLight)
+		control.POST("/printer/stop", s.StopPrinting)
+		control.POST("/printer/pause", s.TogglePause)
+		control.POST("/assemblevideo", s.HandleAssemble)
+		control.POST("/jobs/cancel", s.HandleJobCancel)
+		control.POST("/tl/remove", s.TimelapsRemove)
+		control.POST("/tl/upload", s.HandleUpload)
+	}
+
+	admin := protected.Group("/", s.requireScope(auth.ScopeAdmin))
 	{
-		protected.GET("/", s.IndexHandler)
-		protected.GET("/config", s.ConfigHandler)
-		protected.GET("/status", s.PrinterStatus)
-		protected.GET("/timelapse", s.TimelapsHandler)
-		protected.GET("/tl/file/*path", s.TimelapsFile)
-		protected.GET("/snap", s.SnapHandler)
-
-		protected.POST("/config", s.ConfigSetter)
-		protected.POST("/printer/light", s.ToggleLight)
-		protected.POST("/printer/stop", s.StopPrinting)
-		protected.POST("/printer/pause", s.TogglePause)
-		protected.POST("/assemblevideo", s.HandleAssemble)
-		protected.POST("/tl/remove", s.TimelapsRemove)
+		admin.GET("/config", s.ConfigHandler)
+		admin.POST("/config", s.ConfigSetter)
+		admin.GET("/tokens", s.TokensHandler)
+		admin.POST("/tokens", s.TokenCreate)
+		admin.POST("/tokens/revoke", s.TokenRevoke)
+		admin.GET("/users", s.UsersHandler)
+		admin.POST("/users", s.UserCreate)
+		admin.POST("/users/update", s.UserUpdate)
+		admin.POST("/users/delete", s.UserDelete)
+		admin.GET("/audit", s.AuditHandler)
 	}
+
+	s.setupAPI(protected)
+
+	s.Router.NoRoute(s.apiNoRoute)
+}
+
+// setupProxies настраивает доверенные прокси. Заголовки X-Forwarded-* от остальных
+// клиентов игнорируются, иначе ограничение входов по IP легко обойти.
+func (s *Server) setupProxies() {
+	var list []string
+	for _, entry := range s.core.GetConfig().Web.TrustedProxies {
+		entry = strings.TrimSpace(entry)
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				log.Printf("[WEB] Неверный адрес доверенного прокси %q: %v", entry, err)
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		s.proxies = append(s.proxies, prefix.Masked())
+		list = append(list, prefix.String())
+	}
+
+	if err := s.Router.SetTrustedProxies(list); err != nil {
+		log.Println("[WEB] Ошибка настройки доверенных прокси:", err)
+		s.Router.SetTrustedProxies(nil)
+	}
+}
+
+// fromTrustedProxy сообщает, пришел ли запрос напрямую от доверенного прокси
+func (s *Server) fromTrustedProxy(c *gin.Context) bool {
+	addr, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHTTPS определяет, пришел ли запрос по HTTPS: напрямую или через доверенный прокси
+func (s *Server) isHTTPS(c *gin.Context) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+	return s.fromTrustedProxy(c) && c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// basePath возвращает префикс, под которым приложение опубликовано за прокси
+func (s *Server) basePath() string {
+	return config.NormalizeBasePath(s.core.GetConfig().Web.BasePath)
+}
+
+// url добавляет базовый путь к адресу внутри приложения
+func (s *Server) url(path string) string {
+	return s.basePath() + path
+}
+
+// stripBasePath снимает базовый путь с адреса запроса. Запросы без префикса тоже
+// обрабатываются, поэтому в nginx подходит proxy_pass как со слешем в конце, так и без.
+func (s *Server) stripBasePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := s.basePath()
+		r = r.Clone(r.Context())
+		// gin добавляет X-Forwarded-Prefix к адресу при перенаправлениях, поэтому
+		// значение задаем сами, а не берем от клиента
+		r.Header.Del("X-Forwarded-Prefix")
+		if base == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Header.Set("X-Forwarded-Prefix", base)
+
+		if rest, ok := strings.CutPrefix(r.URL.Path, base); ok && (rest == "" || rest[0] == '/') {
+			r.URL.Path = "/" + strings.TrimPrefix(rest, "/")
+			if r.URL.RawPath != "" {
+				r.URL.RawPath = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.RawPath, base), "/")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
 }
@@ -7,6 +7,8 @@ import (
 	"log"
 	"mime"
 	"net/http"
+	"strings"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,6 +21,10 @@ var viewsFS embed.FS
 
 var htmlTmpl *template.Template
 
+// basePath хранит функцию, которая возвращает префикс адресов за обратным прокси.
+// В шаблонах доступна как {{ base }}.
+var basePath atomic.Value
+
 func init() {
 	err := mime.AddExtensionType(".webmanifest", "application/manifest+json")
 	if err != nil {
@@ -29,10 +35,22 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
-	htmlTmpl = template.Must(template.ParseFS(subTmplFS, "*.go.html"))
+	funcs := template.FuncMap{
+		"base": func() string {
+			if fn, ok := basePath.Load().(func() string); ok {
+				return fn()
+			}
+			return ""
+		},
+		"join": strings.Join,
+	}
+	htmlTmpl = template.Must(template.New("").Funcs(funcs).ParseFS(subTmplFS, "*.go.html"))
 }
 
-func RouteEmbedFiles(route *gin.Engine) {
+// RouteEmbedFiles подключает шаблоны и статические файлы. base возвращает
+// базовый путь приложения, который подставляется в ссылки шаблонов.
+func RouteEmbedFiles(route *gin.Engine, base func() string) {
+	basePath.Store(base)
 	route.SetHTMLTemplate(htmlTmpl)
 
 	subFS, err := fs.Sub(staticFS, "files")
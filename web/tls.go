@@ -0,0 +1,173 @@
+package web
+
+import (
+	"bambucam/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Режимы HTTPS для настройки Web.TLSMode
+const (
+	TLSOff        = ""
+	TLSFiles      = "files"
+	TLSSelfSigned = "self_signed"
+	TLSACME       = "acme"
+)
+
+// Файлы самоподписанного сертификата и кэш ACME лежат рядом с конфигом
+const (
+	selfSignedCert = "tls_selfsigned.crt"
+	selfSignedKey  = "tls_selfsigned.key"
+	acmeCacheDir   = "acme-cache"
+)
+
+// tlsSetup возвращает настройки TLS для основного сервера и, для ACME, менеджер
+// сертификатов (он же обслуживает проверки домена на HTTP порту)
+func (s *Server) tlsSetup() (*tls.Config, *autocert.Manager, error) {
+	cfg := s.core.GetConfig().Web
+
+	switch cfg.TLSMode {
+	case TLSOff:
+		return nil, nil, nil
+
+	case TLSFiles:
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			return nil, nil, errors.New("не указаны файлы сертификата и ключа")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil, nil
+
+	case TLSSelfSigned:
+		cert, err := loadOrCreateSelfSigned(config.HostOnly(cfg.Hostname))
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil, nil
+
+	case TLSACME:
+		domain := config.HostOnly(cfg.Hostname)
+		if domain == "" || net.ParseIP(domain) != nil {
+			return nil, nil, errors.New("для ACME в поле Host нужно указать доменное имя")
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(config.Path(acmeCacheDir)),
+			HostPolicy: autocert.HostWhitelist(domain),
+			Email:      cfg.ACMEEmail,
+		}
+		return manager.TLSConfig(), manager, nil
+	}
+
+	return nil, nil, fmt.Errorf("неизвестный режим TLS: %s", cfg.TLSMode)
+}
+
+// redirectHandler перенаправляет HTTP запросы на HTTPS порт основного сервера
+func (s *Server) redirectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port := s.core.GetConfig().Web.Port; port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// loadOrCreateSelfSigned читает сохраненный самоподписанный сертификат или
+// создает новый, если его нет или срок действия подходит к концу
+func loadOrCreateSelfSigned(hostname string) (tls.Certificate, error) {
+	certPath, keyPath := config.Path(selfSignedCert), config.Path(selfSignedKey)
+
+	if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		// После смены web.hostname сертификат выпускается заново, чтобы имя попало в SAN
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && time.Until(leaf.NotAfter) > 30*24*time.Hour &&
+			(hostname == "" || leaf.VerifyHostname(hostname) == nil) {
+			return cert, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Bambu Monitor", Organization: []string{"Bambu Monitor"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(2, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           localIPs(),
+	}
+	if hostname != "" {
+		if ip := net.ParseIP(hostname); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if hostname != "localhost" {
+			template.DNSNames = append(template.DNSNames, hostname)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Println("[WEB] Создан самоподписанный сертификат:", certPath)
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// localIPs возвращает адреса машины, чтобы сертификат подходил для входа по IP в локальной сети
+func localIPs() []net.IP {
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips
+}